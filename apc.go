@@ -2,24 +2,27 @@ package apc
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"sync"
 
 	"github.com/assagman/apc/core"
 	"github.com/assagman/apc/internal/environ"
-	"github.com/assagman/apc/internal/logger"
-
-	"github.com/assagman/apc/internal/providers/anthropic"
-	"github.com/assagman/apc/internal/providers/cerebras"
-
-	"github.com/assagman/apc/internal/providers/google"
-	"github.com/assagman/apc/internal/providers/groq"
-	"github.com/assagman/apc/internal/providers/openai"
-	"github.com/assagman/apc/internal/providers/openrouter"
-	"github.com/assagman/apc/internal/tools"
+	"github.com/assagman/apc/internal/http"
+	"github.com/assagman/apc/internal/providers"
+
+	// built-in providers, registered on import
+	_ "github.com/assagman/apc/internal/providers/anthropic"
+	_ "github.com/assagman/apc/internal/providers/cerebras"
+	_ "github.com/assagman/apc/internal/providers/google"
+	_ "github.com/assagman/apc/internal/providers/groq"
+	_ "github.com/assagman/apc/internal/providers/openai"
+	_ "github.com/assagman/apc/internal/providers/openaicompat"
+	_ "github.com/assagman/apc/internal/providers/openrouter"
 )
 
+// number of stream events buffered between the pipeline and the consumer
+const streamBufferSize = 64
+
 func LoadEnv(envFile string) error {
 	if err := environ.LoadEnv(envFile); err != nil {
 		return err
@@ -29,273 +32,168 @@ func LoadEnv(envFile string) error {
 
 type APC struct {
 	// public
-	Provider       core.IProvider
+	Provider       core.IProvider // provider of the default session used by Complete and Stream
 	ProviderConfig core.ProviderConfig
 	// private
-	chanWg sync.WaitGroup
+	providerName string
+	historyStore core.HistoryStore
+	toolApprover core.ToolApprover
+	session      *Session
 }
 
 // create new instance of APC
 //
-// providerName: openrouter, groq, cerebras, openai, google, anthropic
+// providerName: openrouter, groq, cerebras, openai, google, anthropic, openai-compatible (needs BaseURL)
+// or one added with RegisterProvider, see ListProviders
 // model: model name supported by the provider
 // systemPrompt: top-level system instructions for the chat
 // apcTools: The tools that will be registered and enabled to the model
-func New(providerName string, providerConfig core.ProviderConfig) (*APC, error) {
-	var provider core.IProvider
-	var err error
-	switch providerName {
-	case "openrouter":
-		provider, err = openrouter.New(providerConfig)
-		if err != nil {
-			return nil, err
-		}
-	case "groq":
-		provider, err = groq.New(providerConfig)
-		if err != nil {
-			return nil, err
-		}
-	case "cerebras":
-		provider, err = cerebras.New(providerConfig)
-		if err != nil {
-			return nil, err
-		}
-	case "openai":
-		provider, err = openai.New(providerConfig)
-		if err != nil {
-			return nil, err
-		}
-	case "google":
-		provider, err = google.New(providerConfig)
-		if err != nil {
-			return nil, err
-		}
-	case "anthropic":
-		provider, err = anthropic.New(providerConfig)
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, fmt.Errorf("Unsupported provider: %s", providerName)
+// opts: optional settings, e.g. WithHistoryStore and WithSessionId to persist and resume conversations,
+// WithToolApprover to confirm tool calls
+func New(providerName string, providerConfig core.ProviderConfig, opts ...Option) (*APC, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// one client for all sessions of the APC, so connections are pooled
+	providerConfig.HTTPClient = http.NewClient(providerConfig)
+	if providerConfig.APCTools.Approver == nil {
+		providerConfig.APCTools.Approver = o.toolApprover
 	}
 	apc := APC{
-		Provider:       provider,
 		ProviderConfig: providerConfig,
+		providerName:   providerName,
+		historyStore:   o.historyStore,
+		toolApprover:   o.toolApprover,
 	}
 
+	var session *Session
+	var err error
+	if o.historyStore != nil && o.sessionId != "" {
+		session, err = apc.ResumeSession(o.sessionId)
+		if errors.Is(err, core.ErrSessionNotFound) {
+			session, err = newSession(o.sessionId, providerName, providerConfig, o.historyStore)
+		}
+	} else {
+		session, err = newSession(o.sessionId, providerName, providerConfig, o.historyStore)
+	}
+	if err != nil {
+		return nil, err
+	}
+	apc.session = session
+	apc.Provider = session.Provider
+
 	return &apc, nil
 }
 
-func (apc *APC) ProcessUserPrompt(userPromptChan <-chan string, msgHistoryChan chan<- []core.GenericMessage) {
-	defer apc.chanWg.Done()
-
-	for prompt := range userPromptChan {
-		logger.Info("[ProcessUserPrompt] ✅ Got user prompt")
-		msgHistoryChan <- []core.GenericMessage{apc.Provider.ConstructUserPromptMessage(prompt)}
-	}
+// RegisterProvider makes a provider available to New and SwitchProvider under
+// name. The built-in providers are registered on import; registering one of
+// their names replaces it.
+func RegisterProvider(name string, factory core.ProviderFactory) {
+	providers.Register(name, factory)
 }
 
-func (apc *APC) ProcessMessage(msgHistoryChan <-chan []core.GenericMessage, reqChan chan<- core.GenericRequest, errChan chan<- error) {
-	defer apc.chanWg.Done()
-
-	for messages := range msgHistoryChan {
-		var err error
-		for _, msg := range messages {
-			err = apc.Provider.AppendMessageHistory(msg)
-			if err != nil {
-				break
-			}
-
-		}
-		if err != nil {
-			errChan <- err
-			continue
-		}
-		isSenderRole := false
-		for _, msg := range messages {
-			isSenderRole, err = apc.Provider.IsSenderRole(msg)
-			if err != nil {
-				break
-			}
-		}
-		if err != nil {
-			errChan <- err
-			continue
-		}
-		if isSenderRole {
-			req, err := apc.Provider.NewRequest()
-			if err != nil {
-				errChan <- err
-			}
-			reqChan <- req
-		}
-	}
+// ListProviders returns the sorted names of all registered providers.
+func ListProviders() []string {
+	return providers.List()
 }
-func (apc *APC) ProcessRequest(ctx context.Context, reqChan <-chan core.GenericRequest, respChan chan<- core.GenericResponse, errChan chan<- error) {
-	defer apc.chanWg.Done()
 
-	for req := range reqChan {
-		logger.Info("[ProcessRequest] ⏳ Awaiting response...")
-		resp, err := apc.Provider.SendRequest(ctx, req)
-		if err != nil {
-			errChan <- err
-			continue
-		}
-		respChan <- resp
-	}
+// newProvider creates a provider instance with its own, empty history.
+func newProvider(providerName string, providerConfig core.ProviderConfig) (core.IProvider, error) {
+	return providers.New(providerName, providerConfig)
 }
 
-func (apc *APC) ProcessToolCall(ctx context.Context, toolCallChan <-chan []tools.ToolCall, msgHistoryChan chan<- []core.GenericMessage, errChan chan<- error) {
-	defer apc.chanWg.Done()
-
-	for toolCalls := range toolCallChan {
-		tooCallCounter := 1
-		toolMessages := make([]core.GenericMessage, 0)
-		for _, toolCall := range toolCalls {
-			logger.Info("[ProcessToolCall] ⚡ Call tool `%s` [%d/%d]", toolCall.Function.Name, tooCallCounter, len(toolCalls))
-			isToolCallValid, err := apc.Provider.IsToolCallValid(toolCall)
-			if err != nil {
-				errChan <- err
-			}
-			if isToolCallValid {
-				var argsStr string
-				var argsMap = make(map[string]any)
-				if toolCall.Function.Arguments != nil && string(toolCall.Function.Arguments) != "{}" {
-					var err error
-					if toolCall.Function.Arguments[0] == '"' { // string
-						err = json.Unmarshal([]byte(toolCall.Function.Arguments), &argsStr)
-						if err != nil {
-							errChan <- fmt.Errorf("Failed to unmarshal toolCall.Function.Arguments to argStr. Value: %s\n, err: %v", string(toolCall.Function.Arguments), err)
-							continue
-						}
-						err = json.Unmarshal([]byte(argsStr), &argsMap)
-						if err != nil {
-							errChan <- fmt.Errorf("Failed to unmarshal argStr to argsMap. Value: %s\n, err: %v", string(toolCall.Function.Arguments), err)
-							continue
-						}
-					} else { // object ready
-						err = json.Unmarshal([]byte(toolCall.Function.Arguments), &argsMap)
-						if err != nil {
-							errChan <- fmt.Errorf("Failed to unmarshal argStr to argsMap. Value: %s\n, err: %v", string(toolCall.Function.Arguments), err)
-							continue
-						}
-					}
-				}
-
-				var toolResultStr string
-				toolResult, toolErr := tools.ExecTool(toolCall.Function.Name, argsMap)
-				if toolErr != nil {
-					toolResultStr = toolErr.Error()
-					logger.Warning("[ProcessToolCall] Tool `%s` returned err: %s", toolCall.Function.Name, toolResultStr)
-				} else {
-					var ok bool
-					toolResultStr, ok = toolResult.(string)
-					if !ok {
-						errChan <- fmt.Errorf("Failed to cast toolResult to string")
-						continue
-					}
-					logger.Info("[ProcessToolCall] ✅ Tool call successful `%s` [%d/%d]", toolCall.Function.Name, tooCallCounter, len(toolCalls))
-				}
-
-				toolMsg := apc.Provider.ConstructToolMessage(toolCall, toolResultStr)
-				toolMessages = append(toolMessages, toolMsg)
-			}
-			tooCallCounter += 1
-		}
-		if len(toolMessages) > 0 {
-			msgHistoryChan <- toolMessages
-		} else {
-			logger.Warning("\t\t\t[ProcessToolCall] ⚠️ No tool message constructed")
-			// TODO: compare tool name(s) with registered tools, validate it. if tool name(s) are wrong, retry it
-		}
+// send delivers v on ch unless ctx is done first.
+func send[T any](ctx context.Context, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
-func (apc *APC) ProcessResponse(ctx context.Context, respChan <-chan core.GenericResponse, msgHistoryChan chan<- []core.GenericMessage, toolCallChan chan<- []tools.ToolCall, errChan chan<- error, outChan chan<- string) {
-	defer apc.chanWg.Done()
-
-	for resp := range respChan {
-		logger.Info("[ProcessResponse] 📦 Got response")
-		msg, err := apc.Provider.GetMessageFromResponse(resp)
-		if err != nil {
-			errChan <- err
-		}
-		msgHistoryChan <- []core.GenericMessage{msg}
-
-		isToolCall, err := apc.Provider.IsToolCall(resp)
-		if err != nil {
-			errChan <- err
-			continue
-		}
-		if !isToolCall {
-			answer, err := apc.Provider.GetAnswerFromResponse(resp)
-			if err != nil {
-				errChan <- err
-				continue
-			}
-			outChan <- answer
-		} else {
-			toolCalls, err := apc.Provider.GetToolCallsFromResponse(resp)
-			if err != nil {
-				errChan <- err
-			}
-			toolCallChan <- toolCalls
-		}
-		logger.Info("[ProcessResponse] ✅ Response processed successfully")
+// emit forwards a stream event when streaming is enabled (eventChan != nil).
+func emit(ctx context.Context, eventChan chan<- core.StreamEvent, event core.StreamEvent) {
+	if eventChan == nil {
+		return
 	}
+	send(ctx, eventChan, event)
 }
 
+// Complete sends the user prompt within the default session, runs tool calls
+// requested by the model and returns the final answer. Consecutive calls
+// continue the same conversation; use NewSession for independent ones.
 func (apc *APC) Complete(ctx context.Context, userPrompt string) (string, error) {
-	userPromptChan := make(chan string, 1)
-	toolCallChan := make(chan []tools.ToolCall, 1)
-	msgHistoryChan := make(chan []core.GenericMessage, 1)
-	reqChan := make(chan core.GenericRequest, 1)
-	respChan := make(chan core.GenericResponse, 1)
-	outChan := make(chan string, 1)
-	errChan := make(chan error, 1)
+	return apc.session.Send(ctx, userPrompt)
+}
 
-	apc.chanWg.Add(6)
-	go apc.ProcessUserPrompt(userPromptChan, msgHistoryChan)
-	go apc.ProcessMessage(msgHistoryChan, reqChan, errChan)
-	go apc.ProcessRequest(ctx, reqChan, respChan, errChan)
-	go apc.ProcessToolCall(ctx, toolCallChan, msgHistoryChan, errChan)
-	go apc.ProcessResponse(ctx, respChan, msgHistoryChan, toolCallChan, errChan, outChan)
+// Stream works like Complete but streams the response. See Session.Stream.
+func (apc *APC) Stream(ctx context.Context, userPrompt string) <-chan core.StreamEvent {
+	return apc.session.Stream(ctx, userPrompt)
+}
 
-	var answer string
-	var err error
-	go func() {
-		defer apc.chanWg.Done()
+// History returns the history of the default session as provider-neutral
+// messages.
+func (apc *APC) History() ([]core.Message, error) {
+	return apc.session.History()
+}
 
-		for e := range errChan {
-			fmt.Printf("%v", e)
-			err = e
-			close(userPromptChan)
-			close(msgHistoryChan)
-			close(reqChan)
-			close(respChan)
-			close(toolCallChan)
-			close(outChan)
-			break
-		}
-	}()
+// SwitchProvider continues the default session on another provider. See
+// Session.SwitchProvider.
+func (apc *APC) SwitchProvider(providerName string, providerConfig core.ProviderConfig) error {
+	if providerConfig.HTTPClient == nil && providerConfig.Transport == nil && providerConfig.ProxyURL == nil {
+		providerConfig.HTTPClient = apc.ProviderConfig.HTTPClient
+	} else {
+		providerConfig.HTTPClient = http.NewClient(providerConfig)
+	}
+	if providerConfig.APCTools.Approver == nil {
+		providerConfig.APCTools.Approver = apc.toolApprover
+	}
+	if err := apc.session.SwitchProvider(providerName, providerConfig); err != nil {
+		return err
+	}
+	apc.Provider = apc.session.Provider
+	apc.ProviderConfig = providerConfig
+	apc.providerName = providerName
+	return nil
+}
 
-	userPromptChan <- userPrompt
-	answer = <-outChan
-	close(errChan)
+// NewSession creates a new conversation with an empty history, using the
+// provider, configuration and history store of the APC.
+func (apc *APC) NewSession() (*Session, error) {
+	return newSession("", apc.providerName, apc.ProviderConfig, apc.historyStore)
+}
 
+// ResumeSession loads a stored conversation from the history store and
+// returns a session that continues it.
+func (apc *APC) ResumeSession(sessionId string) (*Session, error) {
+	if apc.historyStore == nil {
+		return nil, fmt.Errorf("[ResumeSession] No history store configured, see apc.WithHistoryStore")
+	}
+	history, err := apc.historyStore.Load(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	session, err := newSession(sessionId, apc.providerName, apc.ProviderConfig, apc.historyStore)
 	if err != nil {
-		logger.PrintV(apc.Provider.GetMessageHistory())
-		return "", err
+		return nil, err
+	}
+	if err := session.Provider.ImportHistory(history); err != nil {
+		return nil, err
 	}
+	return session, nil
+}
 
-	close(userPromptChan)
-	close(msgHistoryChan)
-	close(reqChan)
-	close(respChan)
-	close(toolCallChan)
-	close(outChan)
+// ListSessions returns the ids of the conversations in the history store.
+func (apc *APC) ListSessions() ([]string, error) {
+	if apc.historyStore == nil {
+		return nil, fmt.Errorf("[ListSessions] No history store configured, see apc.WithHistoryStore")
+	}
+	return apc.historyStore.List()
+}
 
-	// fmt.Printf("%+v", apc.waitGroup)
-	apc.chanWg.Wait()
-	return answer, nil
+// Session returns the default session used by Complete and Stream.
+func (apc *APC) Session() *Session {
+	return apc.session
 }
@@ -1 +1,516 @@
 package apc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/mock"
+)
+
+func TestRegisterProvider(t *testing.T) {
+	errGateway := errors.New("gateway factory called")
+	RegisterProvider("test-gateway", func(config core.ProviderConfig) (core.IProvider, error) {
+		if config.Model != "model" {
+			t.Errorf("unexpected config: %+v", config)
+		}
+		return nil, errGateway
+	})
+
+	names := ListProviders()
+	for _, name := range []string{"anthropic", "openai", "openai-compatible", "test-gateway"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("expected `%s` in %v", name, names)
+		}
+	}
+	if _, err := New("test-gateway", core.ProviderConfig{Model: "model"}); !errors.Is(err, errGateway) {
+		t.Errorf("expected the registered factory to be used, got %v", err)
+	}
+	if _, err := New("missing", core.ProviderConfig{}); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
+
+func newMockClient(t *testing.T, script *mock.Script, config core.ProviderConfig) *APC {
+	t.Helper()
+	RegisterProvider("mock", script.Factory())
+	client, err := New("mock", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestComplete_ToolLoop(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	apcTools := core.APCTools{}
+	if err := apcTools.EnableFsTools(dir); err != nil {
+		t.Fatal(err)
+	}
+	script := mock.NewScript(
+		mock.ToolCalls(mock.Call("ToolReadFile", map[string]any{"filePath": "notes.txt"})),
+		mock.Response{Text: "It says hello.", Usage: core.Usage{InputTokens: 10, OutputTokens: 4}},
+	)
+	client := newMockClient(t, script, core.ProviderConfig{Model: "test", SystemPrompt: "be brief", APCTools: apcTools})
+
+	answer, err := client.Complete(context.Background(), "what is in notes.txt?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "It says hello." {
+		t.Errorf("unexpected answer: %q", answer)
+	}
+
+	expected := []core.Message{
+		{Role: core.RoleUser, Parts: []core.Part{core.TextPart("what is in notes.txt?")}},
+		{Role: core.RoleAssistant, Parts: []core.Part{
+			core.ToolCallPart(core.ToolCall{Id: "call_1", Name: "ToolReadFile", Arguments: json.RawMessage(`{"filePath":"notes.txt"}`)}),
+		}},
+		{Role: core.RoleTool, Parts: []core.Part{
+			core.ToolResultPart(core.ToolResult{ToolCallId: "call_1", Name: "ToolReadFile", Content: "hello"}),
+		}},
+	}
+	requests := script.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	if requests[0].SystemPrompt != "be brief" || !slices.Contains(requests[0].Tools, "ToolReadFile") {
+		t.Errorf("unexpected request: %+v", requests[0])
+	}
+	if !reflect.DeepEqual(expected, requests[1].Messages) {
+		t.Errorf("unexpected messages of the second request: %+v", requests[1].Messages)
+	}
+
+	history, err := client.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, core.Message{Role: core.RoleAssistant, Parts: []core.Part{core.TextPart("It says hello.")}})
+	if !reflect.DeepEqual(expected, history) {
+		t.Errorf("unexpected history: %+v", history)
+	}
+}
+
+func TestComplete_Errors(t *testing.T) {
+	script := mock.NewScript(mock.Error(&core.APIError{Provider: "mock", StatusCode: 503, Retryable: true}))
+	client := newMockClient(t, script, core.ProviderConfig{Model: "test"})
+	_, err := client.Complete(context.Background(), "hi")
+	var apiErr *core.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 503 {
+		t.Errorf("expected *core.APIError, got %v", err)
+	}
+
+	call := mock.Call("ToolGetCurrentWorkingDirectory", map[string]any{})
+	script = mock.NewScript(mock.ToolCalls(call), mock.ToolCalls(call), mock.Text("unreachable"))
+	client = newMockClient(t, script, core.ProviderConfig{Model: "test", Limits: core.Limits{MaxToolRounds: 1}})
+	_, err = client.Complete(context.Background(), "cwd?")
+	var limitErr *core.LimitExceededError
+	if !errors.As(err, &limitErr) || limitErr.Limit != core.LimitToolRounds || limitErr.ToolRounds != 1 {
+		t.Errorf("expected tool round limit error, got %v", err)
+	}
+	if script.Remaining() != 1 {
+		t.Errorf("expected the run to stop after 2 responses, %d left", script.Remaining())
+	}
+}
+
+func TestComplete_Limits(t *testing.T) {
+	prompt := core.Message{Role: core.RoleUser, Parts: []core.Part{core.TextPart("go")}}
+	call := mock.Call("CwdTool", map[string]any{})
+	call.Id = "call_1"
+	toolCall := core.Message{Role: core.RoleAssistant, Parts: []core.Part{core.ToolCallPart(call)}}
+	toolResult := core.Message{Role: core.RoleTool, Parts: []core.Part{
+		core.ToolResultPart(core.ToolResult{ToolCallId: call.Id, Name: "CwdTool", Content: "/work"}),
+	}}
+	partial := core.Message{Role: core.RoleAssistant, Parts: []core.Part{core.TextPart("partial")}}
+	for _, tc := range []struct {
+		name      string
+		limits    core.Limits
+		responses []mock.Response
+		limit     core.LimitKind // empty if the call succeeds
+		history   []core.Message
+	}{
+		{
+			// the tool blocks until the timeout
+			name:      "timeout",
+			limits:    core.Limits{Timeout: 20 * time.Millisecond},
+			responses: []mock.Response{mock.ToolCalls(call)},
+			limit:     core.LimitTimeout,
+			history:   []core.Message{prompt, toolCall},
+		},
+		{
+			name:   "max total tokens",
+			limits: core.Limits{MaxTotalTokens: 30},
+			responses: []mock.Response{
+				{ToolCalls: []core.ToolCall{call}, Usage: core.Usage{InputTokens: 10, OutputTokens: 5}},
+				{Text: "partial", Usage: core.Usage{InputTokens: 20, OutputTokens: 5}},
+			},
+			limit:   core.LimitTotalTokens,
+			history: []core.Message{prompt, toolCall, toolResult, partial},
+		},
+		{
+			name:      "max output tokens",
+			limits:    core.Limits{MaxOutputTokens: 5},
+			responses: []mock.Response{{Text: "partial", FinishReason: mock.FinishReasonMaxTokens}},
+			limit:     core.LimitOutputTokens,
+			history:   []core.Message{prompt, partial},
+		},
+		{
+			// without MaxOutputTokens, hitting the provider default isn't an error
+			name:      "provider max tokens",
+			responses: []mock.Response{{Text: "partial", FinishReason: mock.FinishReasonMaxTokens}},
+			history:   []core.Message{prompt, partial},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			apcTools := core.APCTools{}
+			if err := apcTools.RegisterTool("CwdTool", func(ctx context.Context) (string, error) {
+				if tc.limits.Timeout > 0 {
+					<-ctx.Done()
+					return "", ctx.Err()
+				}
+				return "/work", nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			script := mock.NewScript(tc.responses...)
+			client := newMockClient(t, script, core.ProviderConfig{Model: "test", Limits: tc.limits, APCTools: apcTools})
+
+			answer, err := client.Complete(context.Background(), "go")
+			history, historyErr := client.History()
+			if historyErr != nil {
+				t.Fatal(historyErr)
+			}
+			if tc.limit == "" {
+				if err != nil || answer != "partial" {
+					t.Fatalf("expected the partial answer, got %q, %v", answer, err)
+				}
+			} else {
+				var limitErr *core.LimitExceededError
+				if !errors.As(err, &limitErr) || limitErr.Limit != tc.limit {
+					t.Fatalf("expected %s limit error, got %v", tc.limit, err)
+				}
+				if !reflect.DeepEqual(history, limitErr.History) {
+					t.Errorf("expected the error to carry the history, got %+v", limitErr.History)
+				}
+			}
+			// the history is kept as it was when the limit tripped
+			if !reflect.DeepEqual(tc.history, history) {
+				t.Errorf("unexpected history: %+v", history)
+			}
+		})
+	}
+}
+
+func TestComplete_ParallelTools(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	slowTool := func(result string) func() (string, error) {
+		return func() (string, error) {
+			mu.Lock()
+			running += 1
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			running -= 1
+			mu.Unlock()
+			return result, nil
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		sequential []string
+		maxRunning int
+	}{
+		{name: "concurrent", maxRunning: 3},
+		{name: "sequential", sequential: []string{"SlowA", "SlowB"}, maxRunning: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			maxRunning = 0
+			apcTools := core.APCTools{Sequential: tc.sequential}
+			for _, name := range []string{"SlowA", "SlowB"} {
+				if err := apcTools.RegisterTool(name, slowTool(name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			script := mock.NewScript(
+				mock.ToolCalls(mock.Call("SlowA", map[string]any{}), mock.Call("SlowB", map[string]any{}), mock.Call("SlowA", map[string]any{})),
+				mock.Text("done"),
+			)
+			client := newMockClient(t, script, core.ProviderConfig{Model: "test", APCTools: apcTools})
+			if _, err := client.Complete(context.Background(), "run them"); err != nil {
+				t.Fatal(err)
+			}
+			if maxRunning != tc.maxRunning {
+				t.Errorf("expected %d tools running at once, got %d", tc.maxRunning, maxRunning)
+			}
+
+			// results keep the order of the tool calls
+			messages := script.Requests()[1].Messages
+			var results []string
+			for _, result := range messages[len(messages)-1].ToolResults() {
+				results = append(results, result.ToolCallId+"="+result.Content)
+			}
+			expected := []string{"call_1=SlowA", "call_2=SlowB", "call_3=SlowA"}
+			if !slices.Equal(expected, results) {
+				t.Errorf("expected tool results %v, got %v", expected, results)
+			}
+		})
+	}
+}
+
+func TestComplete_ToolTimeout(t *testing.T) {
+	apcTools := core.APCTools{
+		ToolTimeout: 20 * time.Millisecond,
+		Timeouts:    map[string]time.Duration{"FastTool": time.Second},
+	}
+	// one tool honors ctx, the other one ignores it and keeps running
+	if err := apcTools.RegisterTool("HungTool", func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := apcTools.RegisterTool("StuckTool", func() (string, error) {
+		time.Sleep(time.Minute)
+		return "unreachable", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := apcTools.RegisterTool("FastTool", func(ctx context.Context, city string) (string, error) {
+		return "sunny in " + city, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	script := mock.NewScript(
+		mock.ToolCalls(
+			mock.Call("HungTool", map[string]any{}),
+			mock.Call("StuckTool", map[string]any{}),
+			mock.Call("FastTool", map[string]any{"city": "Paris"}),
+		),
+		mock.Text("done"),
+	)
+	client := newMockClient(t, script, core.ProviderConfig{Model: "test", APCTools: apcTools})
+
+	start := time.Now()
+	if _, err := client.Complete(context.Background(), "run them"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the timeouts to unblock Complete, took %s", elapsed)
+	}
+
+	messages := script.Requests()[1].Messages
+	results := messages[len(messages)-1].ToolResults()
+	if len(results) != 3 {
+		t.Fatalf("expected 3 tool results, got %+v", results)
+	}
+	for _, result := range results[:2] {
+		if result.Content != "timed out after 20ms: context deadline exceeded" {
+			t.Errorf("expected the timeout to be reported to the model, got %q", result.Content)
+		}
+	}
+	if results[2].Content != "sunny in Paris" {
+		t.Errorf("unexpected result: %q", results[2].Content)
+	}
+}
+
+func TestComplete_ToolPanic(t *testing.T) {
+	apcTools := core.APCTools{}
+	if err := apcTools.RegisterTool("PanicTool", func() (string, error) {
+		panic("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	script := mock.NewScript(
+		mock.ToolCalls(mock.Call("PanicTool", map[string]any{})),
+		mock.Text("done"),
+	)
+	client := newMockClient(t, script, core.ProviderConfig{Model: "test", APCTools: apcTools})
+	if _, err := client.Complete(context.Background(), "run it"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the panic is reported to the model instead of crashing the process
+	messages := script.Requests()[1].Messages
+	results := messages[len(messages)-1].ToolResults()
+	if len(results) != 1 || results[0].Content != "tool PanicTool panicked: boom" || !results[0].IsError {
+		t.Errorf("expected the panic to be reported to the model, got %+v", results)
+	}
+}
+
+func TestComplete_ToolApproval(t *testing.T) {
+	echo := func(text string) (string, error) { return text, nil }
+	apcTools := core.APCTools{}
+	if err := apcTools.RegisterTool("EchoTool", echo); err != nil {
+		t.Fatal(err)
+	}
+	// the tool's own approver wins over the one of the APC
+	if err := apcTools.RegisterTool("TrustedEchoTool", echo, core.WithApprover(func(ctx context.Context, name string, args map[string]any) (core.ToolApproval, error) {
+		return core.Approve(), nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	var approved []string
+	approver := func(ctx context.Context, name string, args map[string]any) (core.ToolApproval, error) {
+		approved = append(approved, name+":"+args["text"].(string))
+		switch args["text"] {
+		case "rm -rf /":
+			return core.Deny("Not allowed."), nil
+		case "hello":
+			return core.ApproveWithArguments(map[string]any{"text": "hello, world"}), nil
+		}
+		return core.Approve(), nil
+	}
+	script := mock.NewScript(
+		mock.ToolCalls(
+			mock.Call("EchoTool", map[string]any{"text": "rm -rf /"}),
+			mock.Call("EchoTool", map[string]any{"text": "hello"}),
+			mock.Call("TrustedEchoTool", map[string]any{"text": "trusted"}),
+		),
+		mock.Text("done"),
+	)
+	RegisterProvider("mock", script.Factory())
+	client, err := New("mock", core.ProviderConfig{Model: "test", APCTools: apcTools}, WithToolApprover(approver))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var denied error
+	for event := range client.Stream(context.Background(), "run them") {
+		if event.Type == core.StreamEventToolResult && event.ToolCall.Id == "call_1" {
+			denied = event.Err
+		}
+	}
+	if !errors.Is(denied, core.ErrToolCallDenied) {
+		t.Errorf("expected the denied call to report ErrToolCallDenied, got %v", denied)
+	}
+	slices.Sort(approved)
+	if expected := []string{"EchoTool:hello", "EchoTool:rm -rf /"}; !slices.Equal(expected, approved) {
+		t.Errorf("expected approvals %v, got %v", expected, approved)
+	}
+
+	messages := script.Requests()[1].Messages
+	var results []string
+	for _, result := range messages[len(messages)-1].ToolResults() {
+		results = append(results, result.Content)
+	}
+	if expected := []string{"Not allowed.", "hello, world", "trusted"}; !slices.Equal(expected, results) {
+		t.Errorf("expected tool results %v, got %v", expected, results)
+	}
+}
+
+func TestAPCTools_Registry(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	toolsA := core.APCTools{}
+	if err := toolsA.EnableFsTools(dir, core.WithNamespace("fs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := toolsA.EnableFsTools(dir, core.WithNamespace("fs")); err == nil {
+		t.Error("expected the second registration to collide")
+	}
+	if err := toolsA.RegisterTool("ToolReadFile", func(path string) (string, error) { return "", nil }, core.WithNamespace("fs")); err == nil {
+		t.Error("expected a collision with fs_ToolReadFile")
+	}
+	if err := toolsA.RegisterTool("read.file", func(path string) (string, error) { return "", nil }); err == nil {
+		t.Error("expected an invalid tool name error")
+	}
+	toolsB := core.APCTools{}
+	if err := toolsB.RegisterTool("SecretTool", func() (string, error) { return "secret", nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	script := mock.NewScript(
+		mock.ToolCalls(
+			mock.Call("fs_ToolReadFile", map[string]any{"filePath": "notes.txt"}),
+			mock.Call("SecretTool", map[string]any{}),
+		),
+		mock.Text("done"),
+	)
+	client := newMockClient(t, script, core.ProviderConfig{Model: "test", APCTools: toolsA})
+	if _, err := client.Complete(context.Background(), "read notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if tools := script.Requests()[0].Tools; !slices.Contains(tools, "fs_ToolGrepText") || slices.Contains(tools, "SecretTool") {
+		t.Errorf("unexpected tools: %v", tools)
+	}
+	messages := script.Requests()[1].Messages
+	results := messages[len(messages)-1].ToolResults()
+	if len(results) != 2 || results[0].Content != "hello" || results[1].Content != "function SecretTool not found" {
+		t.Errorf("expected tools of another client to be unknown, got %+v", results)
+	}
+}
+
+func TestComplete_ToolResultTypes(t *testing.T) {
+	type forecast struct {
+		City string `json:"city"`
+		Temp int    `json:"temp"`
+	}
+	image := core.Image{MimeType: "image/png", Data: []byte{0x89, 'P', 'N', 'G'}}
+	apcTools := core.APCTools{}
+	toolFuncs := map[string]any{
+		"ForecastTool": func(city string) (forecast, error) { return forecast{City: city, Temp: 22}, nil },
+		"FailingTool":  func() (core.ToolResult, error) { return core.ToolResult{Content: "no such city", IsError: true}, nil },
+		"ChartTool": func() ([]core.Part, error) {
+			return []core.Part{core.TextPart("the chart:"), core.ImagePart(image)}, nil
+		},
+	}
+	for name, fn := range toolFuncs {
+		if err := apcTools.RegisterTool(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := mock.NewScript(
+		mock.ToolCalls(
+			mock.Call("ForecastTool", map[string]any{"city": "Paris"}),
+			mock.Call("FailingTool", map[string]any{}),
+			mock.Call("ChartTool", map[string]any{}),
+		),
+		mock.Text("done"),
+	)
+	RegisterProvider("mock", script.Factory())
+	client, err := New("mock", core.ProviderConfig{Model: "test", APCTools: apcTools})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var toolErrs []error
+	for event := range client.Stream(context.Background(), "go") {
+		if event.Type == core.StreamEventToolResult && event.Err != nil {
+			toolErrs = append(toolErrs, event.Err)
+		}
+	}
+	if len(toolErrs) != 1 {
+		t.Errorf("expected the failing tool to report an error, got %v", toolErrs)
+	}
+
+	messages := script.Requests()[1].Messages
+	results := messages[len(messages)-1].ToolResults()
+	if len(results) != 3 {
+		t.Fatalf("expected 3 tool results, got %d", len(results))
+	}
+	if results[0].Content != `{"city":"Paris","temp":22}` || results[0].IsError {
+		t.Errorf("expected the JSON encoded forecast, got %+v", results[0])
+	}
+	if results[1].Content != "no such city" || !results[1].IsError {
+		t.Errorf("expected an error result, got %+v", results[1])
+	}
+	if results[2].Text() != "the chart:" || len(results[2].Images()) != 1 {
+		t.Errorf("expected a text and an image part, got %+v", results[2])
+	}
+}
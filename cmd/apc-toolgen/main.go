@@ -0,0 +1,209 @@
+// apc-toolgen extracts the parameter names and doc comments of tool
+// functions and methods at build time, into generated Go code registering
+// them with core.RegisterToolMetadata. Tools of a package with generated
+// metadata are registered without reading its source at runtime, e.g. in
+// binaries shipped without the Go toolchain and source tree.
+//
+// Usage, in a file of the package:
+//
+//	//go:generate go run github.com/assagman/apc/cmd/apc-toolgen -type ToolBox -func ToolGetTime
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/assagman/apc/internal/tools"
+	"golang.org/x/tools/go/packages"
+)
+
+// registryAPI names the registration API used by the generated code.
+type registryAPI struct {
+	importPath string
+	register   string
+	metadata   string
+	funcDocs   string
+	paramDoc   string
+}
+
+var coreAPI = registryAPI{
+	importPath: "github.com/assagman/apc/core",
+	register:   "core.RegisterToolMetadata",
+	metadata:   "core.ToolMetadata",
+	funcDocs:   "core.ToolDocs",
+	paramDoc:   "core.ToolParamDoc",
+}
+
+// toolsAPI is used for the built-in tools, declared in the package of the
+// registry, which core imports.
+var toolsAPI = registryAPI{
+	register: "RegisterMetadata",
+	metadata: "Metadata",
+	funcDocs: "FuncDocs",
+	paramDoc: "ParamDoc",
+}
+
+var toolsPkgPath = reflect.TypeOf(tools.Metadata{}).PkgPath()
+
+func main() {
+	typeNames := flag.String("type", "", "comma separated types whose exported methods are tools")
+	funcNames := flag.String("func", "", "comma separated functions that are tools")
+	output := flag.String("output", "apc_tools_gen.go", "output file name, in the package directory")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: apc-toolgen [-type T,...] [-func F,...] [-output file] [dir]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	src, err := generate(dir, splitList(*typeNames), splitList(*funcNames))
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, *output), src, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "apc-toolgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// generate returns the metadata code of the tools of the package in dir.
+func generate(dir string, typeNames []string, funcNames []string) ([]byte, error) {
+	if len(typeNames) == 0 && len(funcNames) == 0 {
+		return nil, fmt.Errorf("no -type or -func given")
+	}
+	cfg := packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax,
+		Dir:  dir,
+	}
+	pkgs, err := packages.Load(&cfg, ".")
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected 1 package in %s, got %d", dir, len(pkgs))
+	}
+	pkg := pkgs[0]
+	if len(pkg.Errors) > 0 {
+		return nil, pkg.Errors[0]
+	}
+
+	funcs := make(map[string]tools.FuncDocs)
+	found := make(map[string]bool)
+	for _, file := range pkg.Syntax {
+		for _, d := range file.Decls {
+			decl, ok := d.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			if decl.Recv == nil {
+				if slices.Contains(funcNames, decl.Name.Name) {
+					funcs[decl.Name.Name] = tools.FuncDocsFromSource(decl.Type, decl.Doc.Text())
+					found[decl.Name.Name] = true
+				}
+				continue
+			}
+			typeName := strings.TrimPrefix(recvTypeName(decl.Recv), "*")
+			if decl.Name.IsExported() && slices.Contains(typeNames, typeName) {
+				funcs[typeName+"."+decl.Name.Name] = tools.FuncDocsFromSource(decl.Type, decl.Doc.Text())
+				found[typeName] = true
+			}
+		}
+	}
+	for _, name := range append(typeNames, funcNames...) {
+		if !found[name] {
+			return nil, fmt.Errorf("no tool `%s` found in package %s", name, pkg.PkgPath)
+		}
+	}
+
+	// reflection reports main as the package path of commands
+	pkgPath := pkg.PkgPath
+	if pkg.Name == "main" {
+		pkgPath = "main"
+	}
+	api := coreAPI
+	if pkgPath == toolsPkgPath {
+		api = toolsAPI
+	}
+	return render(api, pkg.Name, pkgPath, funcs, tools.FieldDocsFromSource(pkg.Syntax))
+}
+
+func recvTypeName(recv *ast.FieldList) string {
+	switch t := recv.List[0].Type.(type) {
+	case *ast.StarExpr:
+		if id, ok := t.X.(*ast.Ident); ok {
+			return "*" + id.Name
+		}
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+func render(api registryAPI, pkgName string, pkgPath string, funcs map[string]tools.FuncDocs, fieldDocs map[string]string) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by apc-toolgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkgName)
+	if api.importPath != "" {
+		fmt.Fprintf(&b, "import %s\n\n", strconv.Quote(api.importPath))
+	}
+	fmt.Fprintf(&b, "func init() {\n")
+	fmt.Fprintf(&b, "%s(%s, %s{\n", api.register, strconv.Quote(pkgPath), api.metadata)
+
+	fmt.Fprintf(&b, "Funcs: map[string]%s{\n", api.funcDocs)
+	for _, name := range sortedKeys(funcs) {
+		docs := funcs[name]
+		fmt.Fprintf(&b, "%s: {\n", strconv.Quote(name))
+		fmt.Fprintf(&b, "Description: %s,\n", strconv.Quote(docs.Description))
+		if len(docs.Params) > 0 {
+			fmt.Fprintf(&b, "Params: []%s{\n", api.paramDoc)
+			for _, param := range docs.Params {
+				fmt.Fprintf(&b, "{Name: %s, Description: %s},\n", strconv.Quote(param.Name), strconv.Quote(param.Description))
+			}
+			fmt.Fprintf(&b, "},\n")
+		}
+		fmt.Fprintf(&b, "},\n")
+	}
+	fmt.Fprintf(&b, "},\n")
+
+	fmt.Fprintf(&b, "FieldDocs: map[string]string{\n")
+	for _, key := range sortedKeys(fieldDocs) {
+		if fieldDocs[key] != "" {
+			fmt.Fprintf(&b, "%s: %s,\n", strconv.Quote(key), strconv.Quote(fieldDocs[key]))
+		}
+	}
+	fmt.Fprintf(&b, "},\n")
+
+	fmt.Fprintf(&b, "})\n}\n")
+	return format.Source(b.Bytes())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// The metadata of the built-in tools is checked in, so that they register
+// without the source at runtime; it must be regenerated when they change.
+func TestGenerate_BuiltinTools(t *testing.T) {
+	src, err := generate("../../internal/tools", []string{"FS", "FSWrite", "Shell"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := os.ReadFile("../../internal/tools/apc_tools_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, committed) {
+		t.Error("internal/tools/apc_tools_gen.go is stale, run go generate ./internal/tools")
+	}
+}
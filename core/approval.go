@@ -0,0 +1,40 @@
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrToolCallDenied is wrapped by the ToolError of a denied tool call.
+var ErrToolCallDenied = errors.New("tool call denied")
+
+// ToolApproval is the decision of a ToolApprover.
+type ToolApproval struct {
+	// Approved runs the tool. Otherwise Reason is sent back to the model as
+	// the result of the tool call.
+	Approved bool
+	Reason   string
+	// Arguments replace the arguments of the model if not nil.
+	Arguments map[string]any
+}
+
+// ToolApprover is called with the tool name and the parsed arguments before
+// a tool runs. An error aborts the Complete/Send call. Calls are serialized,
+// so an approver may prompt the user.
+type ToolApprover func(ctx context.Context, name string, args map[string]any) (ToolApproval, error)
+
+// Approve runs the tool call as requested by the model.
+func Approve() ToolApproval {
+	return ToolApproval{Approved: true}
+}
+
+// ApproveWithArguments runs the tool call with args instead of the arguments
+// of the model.
+func ApproveWithArguments(args map[string]any) ToolApproval {
+	return ToolApproval{Approved: true, Arguments: args}
+}
+
+// Deny skips the tool call and sends reason back to the model.
+func Deny(reason string) ToolApproval {
+	return ToolApproval{Reason: reason}
+}
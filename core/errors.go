@@ -0,0 +1,111 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LimitKind identifies the limit of a LimitExceededError.
+type LimitKind string
+
+const (
+	LimitToolRounds   LimitKind = "max_tool_rounds"
+	LimitOutputTokens LimitKind = "max_output_tokens"
+	LimitTimeout      LimitKind = "timeout"
+	LimitTotalTokens  LimitKind = "max_total_tokens"
+)
+
+// LimitExceededError is returned when a call trips one of the configured
+// Limits. The session history is kept as is, so the partial conversation can
+// be inspected via History or continued with another Send.
+type LimitExceededError struct {
+	Limit LimitKind
+	// Max is the configured limit: a number of rounds or tokens, or a
+	// time.Duration for LimitTimeout.
+	Max int64
+	// Usage and ToolRounds are the totals of the call until the limit tripped.
+	Usage      Usage
+	ToolRounds int
+	// History is the session history when the limit tripped.
+	History []Message
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.Limit == LimitTimeout {
+		return fmt.Sprintf("limit exceeded: %s of %s", e.Limit, time.Duration(e.Max))
+	}
+	return fmt.Sprintf("limit exceeded: %s of %d", e.Limit, e.Max)
+}
+
+// APIError is a non-success response of a provider API.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	// Code and Type are the provider specific error code and type when the
+	// response body carries them, e.g. `rate_limit_exceeded` or
+	// `overloaded_error`.
+	Code      string
+	Type      string
+	Message   string
+	RequestId string
+	// Retryable reports whether sending the same request again may succeed,
+	// e.g. on rate limits and overload.
+	Retryable bool
+	Body      []byte
+}
+
+func (e *APIError) Error() string {
+	var sb strings.Builder
+	if e.Provider != "" {
+		sb.WriteString(e.Provider + " ")
+	}
+	sb.WriteString(fmt.Sprintf("API error (status %d", e.StatusCode))
+	if e.Code != "" {
+		sb.WriteString(", code " + e.Code)
+	}
+	if e.Type != "" && e.Type != e.Code {
+		sb.WriteString(", type " + e.Type)
+	}
+	sb.WriteString(")")
+	if e.Message != "" {
+		sb.WriteString(": " + e.Message)
+	}
+	return sb.String()
+}
+
+// IsRetryableStatus reports whether a response with the given HTTP status code
+// is worth retrying.
+func IsRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case 408, 429, 500, 502, 503, 504, 529: // 529: anthropic overloaded
+		return true
+	}
+	return false
+}
+
+// ToolError is a failure to execute a tool call requested by the model.
+type ToolError struct {
+	Tool       string
+	ToolCallId string
+	Err        error
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("tool `%s` failed: %v", e.Tool, e.Err)
+}
+
+func (e *ToolError) Unwrap() error { return e.Err }
+
+// DecodeError is returned when a provider response can't be decoded.
+type DecodeError struct {
+	Provider string
+	Body     []byte
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s response: %v", e.Provider, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
@@ -2,6 +2,11 @@ package core
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
 
 	"github.com/assagman/apc/internal/tools"
 )
@@ -17,41 +22,239 @@ type ProviderConfig struct {
 	Model        string
 	SystemPrompt string
 	APCTools     APCTools
+	Limits       Limits
+	Retry        RetryPolicy
+
+	// BaseURL overrides the API base URL of the provider, e.g. to go through
+	// a gateway or a regional endpoint. It replaces the part before the
+	// endpoint path, e.g. `https://api.openai.com/v1` of
+	// `https://api.openai.com/v1/chat/completions`.
+	BaseURL string
+	// APIKeyEnv is the environment variable holding the API key of the
+	// openai-compatible provider. If empty, no Authorization header is sent.
+	APIKeyEnv string
+	// Headers are added to every request, overriding provider headers with
+	// the same name.
+	Headers map[string]string
+	// HTTPClient sends the requests. If nil, a client using Transport and
+	// ProxyURL is created; APC shares it between all its sessions.
+	HTTPClient *http.Client
+	// Transport is the round tripper of the created client. Defaults to
+	// http.DefaultTransport, which honors the HTTP(S)_PROXY environment.
+	Transport http.RoundTripper
+	// ProxyURL routes the requests of the created client through a proxy.
+	// Ignored if Transport is set.
+	ProxyURL *url.URL
+	// RequestTimeout bounds a single HTTP request attempt until the response
+	// is read, or until its headers arrive for streamed responses.
+	RequestTimeout time.Duration
+}
+
+// ProviderFactory creates a provider instance with its own, empty history.
+type ProviderFactory func(ProviderConfig) (IProvider, error)
+
+// Limits bound a single Complete/Send call. Zero values mean no limit, except
+// for MaxOutputTokens where it means the provider default.
+type Limits struct {
+	// MaxToolRounds is the maximum number of responses requesting tool calls.
+	MaxToolRounds int
+	// MaxOutputTokens is the maximum number of tokens generated per request.
+	MaxOutputTokens int
+	// Timeout is the wall-clock budget for the whole call, including tools.
+	Timeout time.Duration
+	// MaxTotalTokens is the budget of input + output tokens summed over all
+	// requests of the call.
+	MaxTotalTokens int
+}
+
+// Usage is the token usage reported by the provider.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 }
 
+func (u Usage) TotalTokens() int { return u.InputTokens + u.OutputTokens }
+
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		InputTokens:  u.InputTokens + other.InputTokens,
+		OutputTokens: u.OutputTokens + other.OutputTokens,
+	}
+}
+
+// DefaultToolConcurrency is the number of tool calls of one response executed
+// at the same time if APCTools.MaxConcurrency is zero.
+const DefaultToolConcurrency = 4
+
+// APCTools is the tool set of a client. Its tools are registered in its own
+// registry, so tools of one APCTools can't be called through another. Tool
+// names must be unique within an APCTools.
 type APCTools struct {
-	Tools []tools.Tool
+	Tools []Tool
+	// MaxConcurrency is the maximum number of tool calls of one response
+	// executed at the same time. Zero means DefaultToolConcurrency, 1 runs
+	// them one after another.
+	MaxConcurrency int
+	// Sequential holds the names of tools that aren't safe to run
+	// concurrently. Their calls run alone, once the calls before them have
+	// finished.
+	Sequential []string
+	// ToolTimeout bounds every tool call. Zero means no timeout beyond the one
+	// of the Complete/Send call. A tool that ignores its ctx keeps running in
+	// the background after timing out, even if it's Sequential, so the next
+	// call of a Sequential tool may overlap it.
+	ToolTimeout time.Duration
+	// Timeouts overrides ToolTimeout per tool name.
+	Timeouts map[string]time.Duration
+	// Approver approves every tool call before it runs, if set.
+	Approver ToolApprover
+	// Approvers overrides Approver per tool name, see WithApprover.
+	Approvers map[string]ToolApprover
+
+	registry *tools.FunctionRegistry
+}
+
+// funcs returns the registry of t, created on first use. Copies of t share
+// it.
+func (t *APCTools) funcs() *tools.FunctionRegistry {
+	if t.registry == nil {
+		t.registry = tools.NewFunctionRegistry()
+	}
+	return t.registry
+}
+
+// ExecTool runs the named tool of t with the arguments of the model.
+func (t *APCTools) ExecTool(ctx context.Context, name string, args map[string]any) (any, error) {
+	if t.registry == nil {
+		return nil, fmt.Errorf("function %s not found", name)
+	}
+	return tools.ExecTool(ctx, t.registry, name, args)
+}
+
+// grepTimeout is the default timeout of ToolGrepText, set by EnableFsTools.
+const grepTimeout = 5 * time.Second
+
+// Concurrency returns MaxConcurrency, or DefaultToolConcurrency if unset.
+func (t *APCTools) Concurrency() int {
+	if t.MaxConcurrency > 0 {
+		return t.MaxConcurrency
+	}
+	return DefaultToolConcurrency
+}
+
+// Timeout returns the timeout of the named tool, zero if none.
+func (t *APCTools) Timeout(name string) time.Duration {
+	if timeout, ok := t.Timeouts[name]; ok {
+		return timeout
+	}
+	return t.ToolTimeout
+}
+
+// ApproverFor returns the approver of the named tool, nil if its calls run
+// without approval.
+func (t *APCTools) ApproverFor(name string) ToolApprover {
+	if approver, ok := t.Approvers[name]; ok {
+		return approver
+	}
+	return t.Approver
+}
+
+// IsSequential reports whether calls of the named tool must run alone.
+func (t *APCTools) IsSequential(name string) bool {
+	return slices.Contains(t.Sequential, name)
 }
 
-func (t *APCTools) EnableFsTools(path string) error {
-	fsTools, err := tools.GetFsTools(path)
+// EnableFsTools registers the file system tools, working in path. Accepts
+// WithNamespace, WithApprover and WithSandbox.
+func (t *APCTools) EnableFsTools(path string, opts ...ToolOption) error {
+	o := newToolOptions(opts)
+	fsTools, err := tools.GetFsTools(t.funcs(), path, o.sandbox, o.namespace)
 	if err != nil {
 		return err
 	}
-	t.Tools = append(t.Tools, fsTools...)
+	t.add(fsTools, o)
+	grepTool := tools.QualifiedName(o.namespace, "ToolGrepText")
+	if _, ok := t.Timeouts[grepTool]; !ok && t.ToolTimeout == 0 {
+		if t.Timeouts == nil {
+			t.Timeouts = make(map[string]time.Duration)
+		}
+		t.Timeouts[grepTool] = grepTimeout
+	}
 	return nil
 }
 
-func (t *APCTools) RegisterTool(name string, fn any) error {
-	tool, err := tools.RegisterTool(name, fn)
+// EnableFsWriteTools registers the tools creating, editing, patching,
+// moving and deleting files in path. They run one at a time, see Sequential.
+// The returned journal records their changes, which can be rolled back.
+// Accepts WithNamespace, WithApprover and WithSandbox.
+func (t *APCTools) EnableFsWriteTools(path string, opts ...ToolOption) (*FsJournal, error) {
+	o := newToolOptions(opts)
+	writeTools, journal, err := tools.GetFsWriteTools(t.funcs(), path, o.sandbox, o.namespace)
+	if err != nil {
+		return nil, err
+	}
+	t.add(writeTools, o)
+	for _, tool := range writeTools {
+		if !t.IsSequential(tool.Function.Name) {
+			t.Sequential = append(t.Sequential, tool.Function.Name)
+		}
+	}
+	return journal, nil
+}
+
+// EnableShellTools registers the tool running commands in path. It's limited
+// to the commands of DefaultShellAllow unless WithShellConfig says
+// otherwise; consider WithApprover for anything broader. Accepts
+// WithNamespace, WithApprover, WithSandbox and WithShellConfig.
+func (t *APCTools) EnableShellTools(path string, opts ...ToolOption) error {
+	o := newToolOptions(opts)
+	shellTools, err := tools.GetShellTools(t.funcs(), path, o.sandbox, o.shell, o.namespace)
 	if err != nil {
 		return err
 	}
-	t.Tools = append(t.Tools, tool)
+	t.add(shellTools, o)
 	return nil
 }
 
-func (t *APCTools) RegisterMethods(inst any) error {
-	methodTools, err := tools.RegisterMethods(inst)
+// RegisterTool registers fn as a tool named name. Parameter names and
+// descriptions are read from the source of fn if available, see WithParam to
+// set them explicitly.
+func (t *APCTools) RegisterTool(name string, fn any, opts ...ToolOption) error {
+	o := newToolOptions(opts)
+	tool, err := tools.RegisterTool(t.funcs(), tools.QualifiedName(o.namespace, name), fn, o.docs)
 	if err != nil {
 		return err
 	}
-	for _, tool := range methodTools {
-		t.Tools = append(t.Tools, tool)
+	t.add([]Tool{tool}, o)
+	return nil
+}
+
+// RegisterMethods registers every exported method of inst as a tool. Accepts
+// WithNamespace and WithApprover.
+func (t *APCTools) RegisterMethods(inst any, opts ...ToolOption) error {
+	o := newToolOptions(opts)
+	methodTools, err := tools.RegisterMethods(t.funcs(), inst, o.namespace)
+	if err != nil {
+		return err
 	}
+	t.add(methodTools, o)
 	return nil
 }
 
+// add appends registered tools, with the approver of the options.
+func (t *APCTools) add(registered []Tool, o toolOptions) {
+	t.Tools = append(t.Tools, registered...)
+	if o.approver == nil {
+		return
+	}
+	if t.Approvers == nil {
+		t.Approvers = make(map[string]ToolApprover)
+	}
+	for _, tool := range registered {
+		t.Approvers[tool.Function.Name] = o.approver
+	}
+}
+
 type GenericMessage any
 type GenericRequest any
 type GenericResponse any
@@ -64,20 +267,36 @@ type IProvider interface {
 	GetHeaders() map[string]string
 	// Message Construction Methods
 	ConstructUserPromptMessage(prompt string) GenericMessage
-	ConstructToolMessage(toolCall tools.ToolCall, toolResult string) GenericMessage
+	ConstructToolMessage(toolCall ProviderToolCall, toolResult ToolResult) GenericMessage
 	// Message History Management
 	AppendMessageHistory(msg GenericMessage) error
 	GetMessageHistory() any
+	// SetMessageHistory replaces the history with a copy of the given one,
+	// which must be in the shape returned by GetMessageHistory.
+	SetMessageHistory(history any) error
+	// ResetMessageHistory drops every message except the system prompt.
+	ResetMessageHistory()
+	// ExportHistory converts the history to provider-neutral messages.
+	ExportHistory() ([]Message, error)
+	// ImportHistory replaces the history with the given provider-neutral
+	// messages, converted to the provider's wire format.
+	ImportHistory(messages []Message) error
 	// Request/Response Processing
 	NewRequest() (GenericRequest, error)
 	SendRequest(ctx context.Context, genericRequest GenericRequest) (GenericResponse, error)
+	// SendStreamRequest sends the request in streaming mode, reports deltas
+	// to onEvent as they arrive and returns the assembled response, which is
+	// interchangeable with the one returned by SendRequest.
+	SendStreamRequest(ctx context.Context, genericRequest GenericRequest, onEvent StreamHandler) (GenericResponse, error)
 	IsSenderRole(genericMessage GenericMessage) (bool, error)
 	GetMessageFromResponse(genericResponse GenericResponse) (GenericMessage, error)
 	GetFinishReasonFromResponse(genericResponse GenericResponse) (string, error)
 	GetAnswerFromResponse(genericResponse GenericResponse) (string, error)
-	GetToolCallsFromResponse(genericResponse GenericResponse) ([]tools.ToolCall, error)
+	GetToolCallsFromResponse(genericResponse GenericResponse) ([]ProviderToolCall, error)
 	FinishReasonStop() string
 	FinishReasonToolCall() string
+	FinishReasonMaxTokens() string
+	GetUsageFromResponse(genericResponse GenericResponse) (Usage, error)
 	IsToolCall(genericResponse GenericResponse) (bool, error)
-	IsToolCallValid(toolCall tools.ToolCall) (bool, error)
+	IsToolCallValid(toolCall ProviderToolCall) (bool, error)
 }
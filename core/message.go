@@ -0,0 +1,164 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Role is the author of a Message.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	// RoleTool messages carry the results of the tool calls requested in the
+	// preceding assistant message.
+	RoleTool Role = "tool"
+)
+
+// PartType identifies the payload of a Part.
+type PartType string
+
+const (
+	PartTypeText       PartType = "text"
+	PartTypeImage      PartType = "image"
+	PartTypeToolCall   PartType = "tool_call"
+	PartTypeToolResult PartType = "tool_result"
+)
+
+// Message is the provider-neutral representation of a single conversation
+// turn. Every provider can convert its history to and from []Message, which
+// allows inspecting a conversation or continuing it on another provider.
+//
+// The system prompt is part of the ProviderConfig, not of the history.
+type Message struct {
+	Role  Role   `json:"role"`
+	Parts []Part `json:"parts"`
+}
+
+// Part is one piece of a Message. Exactly one payload field matching Type is
+// set.
+type Part struct {
+	Type       PartType    `json:"type"`
+	Text       string      `json:"text,omitempty"`
+	Image      *Image      `json:"image,omitempty"`
+	ToolCall   *ToolCall   `json:"tool_call,omitempty"`
+	ToolResult *ToolResult `json:"tool_result,omitempty"`
+}
+
+// Image is either inline data or a URL.
+type Image struct {
+	MimeType string `json:"mime_type,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// ToolCall is a tool invocation requested by the model. Arguments is always a
+// JSON object.
+type ToolCall struct {
+	Id        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolResult is the outcome of a ToolCall sent back to the model. Tools
+// returning a ToolResult can flag a failure or attach images; any other
+// non-string result is sent as its JSON encoding in Content.
+type ToolResult struct {
+	ToolCallId string `json:"tool_call_id"`
+	Name       string `json:"name,omitempty"`
+	Content    string `json:"content"`
+	// Parts are text and image parts following Content, for multi-part
+	// results. Providers without multi-part tool results receive their text
+	// only.
+	Parts []Part `json:"parts,omitempty"`
+	// IsError reports that the tool failed, Content describes the failure.
+	IsError bool `json:"is_error,omitempty"`
+}
+
+func TextPart(text string) Part {
+	return Part{Type: PartTypeText, Text: text}
+}
+
+func ImagePart(image Image) Part {
+	return Part{Type: PartTypeImage, Image: &image}
+}
+
+func ToolCallPart(toolCall ToolCall) Part {
+	return Part{Type: PartTypeToolCall, ToolCall: &toolCall}
+}
+
+func ToolResultPart(toolResult ToolResult) Part {
+	return Part{Type: PartTypeToolResult, ToolResult: &toolResult}
+}
+
+// Text returns the concatenated text parts of the message.
+func (m Message) Text() string {
+	var sb strings.Builder
+	for _, part := range m.Parts {
+		if part.Type == PartTypeText {
+			sb.WriteString(part.Text)
+		}
+	}
+	return sb.String()
+}
+
+// Text returns Content followed by the text parts of the result.
+func (r ToolResult) Text() string {
+	return Message{Parts: append([]Part{TextPart(r.Content)}, r.Parts...)}.Text()
+}
+
+// Images returns the images attached to the result.
+func (r ToolResult) Images() []Image {
+	var images []Image
+	for _, part := range r.Parts {
+		if part.Type == PartTypeImage && part.Image != nil {
+			images = append(images, *part.Image)
+		}
+	}
+	return images
+}
+
+// ToolCalls returns the tool calls requested in the message.
+func (m Message) ToolCalls() []ToolCall {
+	var toolCalls []ToolCall
+	for _, part := range m.Parts {
+		if part.Type == PartTypeToolCall && part.ToolCall != nil {
+			toolCalls = append(toolCalls, *part.ToolCall)
+		}
+	}
+	return toolCalls
+}
+
+// ToolResults returns the tool results carried by the message.
+func (m Message) ToolResults() []ToolResult {
+	var toolResults []ToolResult
+	for _, part := range m.Parts {
+		if part.Type == PartTypeToolResult && part.ToolResult != nil {
+			toolResults = append(toolResults, *part.ToolResult)
+		}
+	}
+	return toolResults
+}
+
+// NormalizeToolArguments returns tool call arguments as a JSON object. Some
+// providers encode arguments as a JSON string holding the object; empty
+// arguments become "{}".
+func NormalizeToolArguments(args json.RawMessage) (json.RawMessage, error) {
+	trimmed := strings.TrimSpace(string(args))
+	if trimmed == "" || trimmed == "null" {
+		return json.RawMessage("{}"), nil
+	}
+	if trimmed[0] == '"' {
+		var argsStr string
+		if err := json.Unmarshal([]byte(trimmed), &argsStr); err != nil {
+			return nil, err
+		}
+		return NormalizeToolArguments(json.RawMessage(argsStr))
+	}
+	if !json.Valid([]byte(trimmed)) {
+		return nil, fmt.Errorf("invalid tool arguments: %s", trimmed)
+	}
+	return json.RawMessage(trimmed), nil
+}
@@ -0,0 +1,54 @@
+package core
+
+import "time"
+
+// RetryPolicy controls how requests failing with a retryable status (see
+// IsRetryableStatus) or a transient network error are retried. Zero fields
+// fall back to the values of DefaultRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	// Set it to 1 to disable retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with every
+	// further attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps a single delay, including delays requested by the
+	// provider via Retry-After or rate limit reset headers.
+	MaxDelay time.Duration
+	// Jitter is the fraction (0-1) of a delay that is randomized so that
+	// concurrent clients don't retry in lockstep. A negative value disables
+	// jitter.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used for the unset fields of ProviderConfig.Retry.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.2,
+}
+
+// WithDefaults returns a copy of the policy with zero fields set from
+// DefaultRetryPolicy.
+func (p RetryPolicy) WithDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.Jitter == 0 {
+		p.Jitter = DefaultRetryPolicy.Jitter
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	return p
+}
@@ -0,0 +1,20 @@
+package core
+
+import "errors"
+
+// ErrSessionNotFound is returned by HistoryStore.Load and Delete for unknown
+// session ids.
+var ErrSessionNotFound = errors.New("session not found")
+
+// HistoryStore persists conversation histories keyed by session id.
+type HistoryStore interface {
+	// Save stores the full history of the session, replacing any previous
+	// version.
+	Save(sessionId string, messages []Message) error
+	// Load returns the stored history or ErrSessionNotFound.
+	Load(sessionId string) ([]Message, error)
+	// List returns the ids of all stored sessions in ascending order.
+	List() ([]string, error)
+	// Delete removes the stored history or returns ErrSessionNotFound.
+	Delete(sessionId string) error
+}
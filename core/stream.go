@@ -0,0 +1,47 @@
+package core
+
+// StreamEventType identifies the kind of a StreamEvent.
+type StreamEventType string
+
+const (
+	// StreamEventTextDelta carries a piece of the model's text answer in Text.
+	StreamEventTextDelta StreamEventType = "text_delta"
+	// StreamEventToolCallDelta carries a piece of a tool call. ToolCallIndex
+	// identifies the call within the current response; ToolCallId and
+	// ToolName are set once known, ArgumentsDelta holds the next chunk of the
+	// JSON encoded arguments.
+	StreamEventToolCallDelta StreamEventType = "tool_call_delta"
+	// StreamEventToolCall carries a fully assembled tool call in ToolCall,
+	// emitted right before the tool is executed.
+	StreamEventToolCall StreamEventType = "tool_call"
+	// StreamEventToolResult carries the text of the result of an executed
+	// tool in Text. If the tool failed, Err holds a *ToolError and Text the
+	// message sent back to the model.
+	StreamEventToolResult StreamEventType = "tool_result"
+	// StreamEventFinish marks the end of a single model response and carries
+	// the provider specific finish reason.
+	StreamEventFinish StreamEventType = "finish"
+	// StreamEventDone is the last event of a successful stream. Text holds the
+	// full final answer.
+	StreamEventDone StreamEventType = "done"
+	// StreamEventError is the last event of a failed stream, Err holds the
+	// error.
+	StreamEventError StreamEventType = "error"
+)
+
+// StreamEvent is a single typed event emitted while streaming a completion.
+type StreamEvent struct {
+	Type           StreamEventType
+	Text           string
+	ToolCallIndex  int
+	ToolCallId     string
+	ToolName       string
+	ArgumentsDelta string
+	ToolCall       ProviderToolCall
+	FinishReason   string
+	Err            error
+}
+
+// StreamHandler receives events from a provider while a streamed response is
+// being read.
+type StreamHandler func(StreamEvent)
@@ -0,0 +1,144 @@
+package core
+
+import "github.com/assagman/apc/internal/tools"
+
+// ToolOption customizes a tool registered with APCTools.RegisterTool.
+type ToolOption func(o *toolOptions)
+
+type toolOptions struct {
+	approver  ToolApprover
+	docs      tools.FuncDocs
+	namespace string
+	sandbox   FsSandbox
+	shell     ShellConfig
+}
+
+func newToolOptions(opts []ToolOption) toolOptions {
+	o := toolOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithNamespace prefixes the tool names with namespace, e.g. fs_ToolReadFile
+// for the namespace fs, to keep tools of different sources apart. The
+// timeouts, approvers and sequential tools of APCTools use the prefixed
+// names.
+func WithNamespace(namespace string) ToolOption {
+	return func(o *toolOptions) {
+		o.namespace = namespace
+	}
+}
+
+// WithApprover requires approver to approve every call of the tool, instead
+// of APCTools.Approver.
+func WithApprover(approver ToolApprover) ToolOption {
+	return func(o *toolOptions) {
+		o.approver = approver
+	}
+}
+
+// FsSandbox restricts the files the file system tools can access: paths
+// matching its deny-list, ignored by .gitignore files or over its size limit
+// are rejected, and symlinks can't lead outside of the working directory.
+type FsSandbox = tools.Sandbox
+
+// DefaultFsDeny is the deny-list of an FsSandbox without one.
+var DefaultFsDeny = tools.DefaultDeny
+
+// WithSandbox sets the sandbox of the file system tools. Only for
+// EnableFsTools and EnableFsWriteTools.
+func WithSandbox(sandbox FsSandbox) ToolOption {
+	return func(o *toolOptions) {
+		o.sandbox = sandbox
+	}
+}
+
+// ShellConfig restricts the commands the shell tools can run: which
+// binaries, for how long, with which environment and how much of their
+// output is returned.
+type ShellConfig = tools.ShellConfig
+
+// DefaultShellAllow, DefaultShellDeny and DefaultShellDenyArgs are the
+// allow-list and deny-lists of a ShellConfig without them.
+var (
+	DefaultShellAllow    = tools.DefaultShellAllow
+	DefaultShellDeny     = tools.DefaultShellDeny
+	DefaultShellDenyArgs = tools.DefaultShellDenyArgs
+)
+
+// WithShellConfig sets the restrictions of the shell tools. Only for
+// EnableShellTools.
+func WithShellConfig(config ShellConfig) ToolOption {
+	return func(o *toolOptions) {
+		o.shell = config
+	}
+}
+
+// WithDescription sets the description of the tool, instead of the doc
+// comment of the function. Only for RegisterTool.
+func WithDescription(description string) ToolOption {
+	return func(o *toolOptions) {
+		o.docs.Description = description
+	}
+}
+
+// WithParam names and describes the next parameter of the function, skipping
+// a leading context.Context. If used, it must be given for every parameter,
+// in order. Useful for closures and binaries without their source. Only for
+// RegisterTool.
+func WithParam(name string, description string) ToolOption {
+	return func(o *toolOptions) {
+		o.docs.Params = append(o.docs.Params, tools.ParamDoc{Name: name, Description: description})
+	}
+}
+
+// FsJournal records the changes made by the tools of
+// APCTools.EnableFsWriteTools. Undo reverts the last one, Rollback all of
+// them.
+type FsJournal = tools.Journal
+
+// FsJournalEntry is a change recorded in an FsJournal.
+type FsJournalEntry = tools.JournalEntry
+
+// Tool is the definition of a tool sent to the model: its name, description
+// and the JSON schema of its parameters.
+type Tool = tools.Tool
+
+// FunctionDefinition names and describes the function of a Tool.
+type FunctionDefinition = tools.FunctionDefinition
+
+// ToolFunctionParameters is the JSON schema of the parameters of a tool, an
+// object.
+type ToolFunctionParameters = tools.ToolFunctionParameters
+
+// ToolProperty is the JSON schema of a tool parameter.
+type ToolProperty = tools.Property
+
+// ProviderToolCall is a tool call requested by the model, in the shape
+// providers hand it to the session: its id and the called function. Unlike
+// ToolCall, the provider neutral form used in history, it's what an
+// IProvider implementation returns and receives.
+type ProviderToolCall = tools.ToolCall
+
+// Function is the function called by a ProviderToolCall, with its arguments
+// as a JSON object.
+type Function = tools.Function
+
+// ToolMetadata is the source information of the tools of a package,
+// generated by cmd/apc-toolgen.
+type ToolMetadata = tools.Metadata
+
+// ToolDocs describes a tool function and its parameters.
+type ToolDocs = tools.FuncDocs
+
+// ToolParamDoc names and describes a parameter of a tool function.
+type ToolParamDoc = tools.ParamDoc
+
+// RegisterToolMetadata makes the metadata generated for the package at
+// pkgPath available to tool registration, which then doesn't read the source
+// at runtime. It's called by the init function of the generated code.
+func RegisterToolMetadata(pkgPath string, metadata ToolMetadata) {
+	tools.RegisterMetadata(pkgPath, metadata)
+}
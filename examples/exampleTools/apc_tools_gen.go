@@ -0,0 +1,19 @@
+// Code generated by apc-toolgen; DO NOT EDIT.
+
+package exampleTools
+
+import "github.com/assagman/apc/core"
+
+func init() {
+	core.RegisterToolMetadata("github.com/assagman/apc/examples/exampleTools", core.ToolMetadata{
+		Funcs: map[string]core.ToolDocs{
+			"ToolBox.ToolGetAge": {
+				Description: "ToolGetAge returns the age of the user.",
+			},
+			"ToolBox.ToolGetName": {
+				Description: "ToolGetName returns the name of the user.",
+			},
+		},
+		FieldDocs: map[string]string{},
+	})
+}
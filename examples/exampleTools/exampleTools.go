@@ -1,13 +1,17 @@
 package exampleTools
 
+//go:generate go run github.com/assagman/apc/cmd/apc-toolgen -type ToolBox
+
 import "strconv"
 
 type ToolBox struct{}
 
+// ToolGetName returns the name of the user.
 func (t *ToolBox) ToolGetName() (string, error) {
 	return "einstein", nil
 }
 
+// ToolGetAge returns the age of the user.
 func (t *ToolBox) ToolGetAge() (string, error) {
 	return strconv.FormatInt(75, 10), nil
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -228,6 +229,212 @@ func TestRegisterMethods() {
 	}
 }
 
+func TestStream(providerName string, modelName string, prompt string) {
+	apcTools := core.APCTools{}
+	err := apcTools.RegisterTool("ToolGetMyName", ToolGetMyName)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	client, err := apc.New(providerName, core.ProviderConfig{
+		Model:        modelName,
+		SystemPrompt: "Always write your response in bullet list",
+		APCTools:     apcTools,
+	})
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	for event := range client.Stream(context.TODO(), prompt) {
+		switch event.Type {
+		case core.StreamEventTextDelta:
+			fmt.Print(event.Text)
+		case core.StreamEventToolCall:
+			fmt.Printf("\n[tool call] %s(%s)\n", event.ToolCall.Function.Name, string(event.ToolCall.Function.Arguments))
+		case core.StreamEventToolResult:
+			fmt.Printf("[tool result] %s\n", event.Text)
+		case core.StreamEventDone:
+			fmt.Println()
+		case core.StreamEventError:
+			fmt.Printf("\nfailed:\n\n%v\n", event.Err)
+		}
+	}
+}
+
+func TestSessions(providerName string, modelName string) {
+	client, err := apc.New(providerName, core.ProviderConfig{
+		Model:        modelName,
+		SystemPrompt: "Always write your response in bullet list",
+	})
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	if _, err := session.Send(context.TODO(), "My favourite color is green. Remember it."); err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	fork, err := session.Fork()
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	session.Reset()
+	for name, s := range map[string]*apc.Session{"fork": fork, "reset": session} {
+		answer, err := s.Send(context.TODO(), "What is my favourite color?")
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+			continue
+		}
+		fmt.Printf("[%s]:\n%s\n\n", name, answer)
+	}
+}
+
+func TestPersistence(providerName string, modelName string, sessionId string) {
+	store, err := apc.NewJSONLStore(".apc/sessions")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	apcTools := core.APCTools{}
+	if err := apcTools.EnableFsTools(""); err != nil {
+		fmt.Println(err)
+		return
+	}
+	client, err := apc.New(providerName, core.ProviderConfig{
+		Model:        modelName,
+		SystemPrompt: "Always write your response in bullet list",
+		APCTools:     apcTools,
+	}, apc.WithHistoryStore(store), apc.WithSessionId(sessionId))
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	sessionIds, err := client.ListSessions()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("stored sessions: %v\n", sessionIds)
+	history, err := client.History()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("resuming session `%s` with %d message(s)\n", client.Session().Id, len(history))
+	for {
+		fmt.Print(">> Prompt: ")
+		reader := bufio.NewReader(os.Stdin)
+		prompt, err := reader.ReadString('±')
+		prompt = strings.TrimRight(prompt, "±")
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		answer, err := client.Complete(context.TODO(), prompt)
+		if err != nil {
+			fmt.Printf("failed:\n\n")
+			fmt.Printf("\n%v\n", err)
+			continue
+		}
+		fmt.Printf("[AI]:\n%s\n\n", answer)
+	}
+}
+
+// TestOpenAICompatible runs against a local server, e.g. `ollama serve`
+func TestOpenAICompatible(baseUrl string, modelName string) {
+	apcTools := core.APCTools{}
+	if err := apcTools.EnableFsTools(""); err != nil {
+		fmt.Println(err)
+		return
+	}
+	client, err := apc.New("openai-compatible", core.ProviderConfig{
+		BaseURL:      baseUrl,
+		Model:        modelName,
+		SystemPrompt: "Be brief",
+		APCTools:     apcTools,
+	})
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	answer, err := client.Complete(context.TODO(), "Get cwd")
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	fmt.Printf("%s\n", answer)
+}
+
+// TerminalApprover asks on the terminal before every tool call. The answer
+// can approve it, deny it with a reason for the model, or replace the
+// arguments with a JSON object.
+func TerminalApprover(ctx context.Context, name string, args map[string]any) (core.ToolApproval, error) {
+	argsJson, err := json.Marshal(args)
+	if err != nil {
+		return core.ToolApproval{}, err
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("\n>> Run tool `%s` with %s? [y]es / [n]o / [e]dit: ", name, argsJson)
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			return core.ToolApproval{}, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return core.Approve(), nil
+		case "n", "no":
+			fmt.Print(">> Reason for the model: ")
+			reason, err := reader.ReadString('\n')
+			if err != nil {
+				return core.ToolApproval{}, err
+			}
+			return core.Deny(strings.TrimSpace(reason)), nil
+		case "e", "edit":
+			fmt.Print(">> Arguments as JSON: ")
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return core.ToolApproval{}, err
+			}
+			var newArgs map[string]any
+			if err := json.Unmarshal([]byte(line), &newArgs); err != nil {
+				fmt.Printf("invalid JSON: %v\n", err)
+				continue
+			}
+			return core.ApproveWithArguments(newArgs), nil
+		}
+	}
+}
+
+func TestApproval(providerName string, modelName string) {
+	apcTools := core.APCTools{}
+	if err := apcTools.EnableFsTools(""); err != nil {
+		fmt.Println(err)
+		return
+	}
+	client, err := apc.New(providerName, core.ProviderConfig{
+		Model:        modelName,
+		SystemPrompt: "Be brief",
+		APCTools:     apcTools,
+	}, apc.WithToolApprover(TerminalApprover))
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	answer, err := client.Complete(context.TODO(), "Read go.mod and tell me the module name")
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+		return
+	}
+	fmt.Printf("%s\n", answer)
+}
+
 func main() {
 	fmt.Println("Starting examples main")
 	if err := apc.LoadEnv(".env"); err != nil {
@@ -254,6 +461,15 @@ func main() {
 	// TestEnablingTool("google", "gemini-2.5-flash", "get cwd")
 	// TestEnablingTool("anthropic", "claude-sonnet-4-20250514", "get cwd")
 
+	// TestStream("openai", "gpt-4o", "get my name and write a short poem about it")
+
+	// TestSessions("openai", "gpt-4o")
+	// TestPersistence("anthropic", "claude-sonnet-4-20250514", "investigation")
+
+	// TestOpenAICompatible("http://localhost:11434/v1", "qwen3:8b")
+
+	// TestApproval("openai", "gpt-4o")
+
 	// TestOpenrouterSubProvider()
 	TestRegisterMethods()
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/assagman/apc/internal/logger"
 )
@@ -34,6 +35,15 @@ func CheckEnvFile(envFile string) (bool, error) {
 	return true, nil
 }
 
+// loaded holds the names of the variables set by LoadEnv.
+var loaded sync.Map
+
+// Loaded reports whether the variable named key was set by LoadEnv.
+func Loaded(key string) bool {
+	_, ok := loaded.Load(key)
+	return ok
+}
+
 func LoadEnv(envFile string) error {
 	ok, err := CheckEnvFile(envFile)
 	if err != nil {
@@ -72,7 +82,9 @@ func LoadEnv(envFile string) error {
 		}
 
 		err := os.Setenv(key, val)
-		if err != nil {
+		if err == nil {
+			loaded.Store(key, struct{}{})
+		} else {
 			// logger.Warning("Unable to set env %s : %e.", key, err) // TODO: must record missing values for displaying user that it's not possible to do related actions
 		}
 		// logger.Debug("Loaded %s", key)
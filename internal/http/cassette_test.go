@@ -0,0 +1,60 @@
+package http_test
+
+import (
+	"context"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/http"
+)
+
+func TestCassette_RecordReplay(t *testing.T) {
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	headers := map[string]string{"Authorization": "Bearer sk-secret"}
+
+	recorder, err := http.LoadCassette(path, http.CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := http.New(core.ProviderConfig{Transport: recorder})
+	if _, err := c.Post(context.Background(), srv.URL+"?key=secret", headers, []byte(`{"b":1, "a":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("expected credentials to be scrubbed, got %s", data)
+	}
+
+	player, err := http.LoadCassette(path, http.CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = http.New(core.ProviderConfig{Transport: player, Retry: core.RetryPolicy{MaxAttempts: 1}})
+	// key order and whitespace of JSON bodies don't matter
+	resp, err := c.Post(context.Background(), srv.URL+"?key=other", headers, []byte(`{"a":2,"b":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != `{"ok":true}` {
+		t.Errorf("unexpected response: %s", resp)
+	}
+	// every interaction is replayed once
+	if _, err := c.Post(context.Background(), srv.URL+"?key=other", headers, []byte(`{"a":2,"b":1}`)); err == nil {
+		t.Error("expected an error for an unrecorded request")
+	}
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/assagman/apc/core"
+)
+
+// NewClient returns the client to send requests with: config.HTTPClient if
+// set, otherwise a new one using config.Transport or config.ProxyURL.
+func NewClient(config core.ProviderConfig) *http.Client {
+	if config.HTTPClient != nil {
+		return config.HTTPClient
+	}
+	transport := config.Transport
+	if transport == nil && config.ProxyURL != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = http.ProxyURL(config.ProxyURL)
+		transport = t
+	}
+	return &http.Client{Transport: transport}
+}
+
+// Endpoint joins the endpoint path to baseUrl, or to defaultBaseUrl if baseUrl
+// is empty.
+func Endpoint(baseUrl string, defaultBaseUrl string, path string) string {
+	if baseUrl == "" {
+		baseUrl = defaultBaseUrl
+	}
+	return strings.TrimRight(baseUrl, "/") + path
+}
@@ -6,29 +6,48 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/httputil"
+	"strings"
 	"time"
 
+	"github.com/assagman/apc/core"
 	"github.com/assagman/apc/internal/logger"
 )
 
 type BaseHttpClient struct {
+	Client  *http.Client
+	Retry   core.RetryPolicy
+	Headers map[string]string // added to every request
+	Timeout time.Duration     // per attempt, see core.ProviderConfig.RequestTimeout
 }
 
-func New() *BaseHttpClient {
-	return &BaseHttpClient{}
+func New(config core.ProviderConfig) *BaseHttpClient {
+	return &BaseHttpClient{
+		Client:  NewClient(config),
+		Retry:   config.Retry.WithDefaults(),
+		Headers: config.Headers,
+		Timeout: config.RequestTimeout,
+	}
 }
 
 func (c *BaseHttpClient) Get(ctx context.Context, url string, headers map[string]string) ([]byte, error) {
-	client := http.Client{}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := c.do(ctx, http.MethodGet, url, headers, nil, false)
 	if err != nil {
 		return nil, err
 	}
-	for hk, hv := range headers {
-		req.Header.Add(hk, hv)
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
 	}
-	resp, err := client.Do(req)
+	if resp.StatusCode != 200 {
+		return respBytes, newAPIError(resp, respBytes)
+	}
+	return respBytes, nil
+}
+
+func (c *BaseHttpClient) Post(ctx context.Context, url string, headers map[string]string, body []byte) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodPost, url, headers, body, false)
 	if err != nil {
 		return nil, err
 	}
@@ -36,54 +55,167 @@ func (c *BaseHttpClient) Get(ctx context.Context, url string, headers map[string
 
 	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return respBytes, newAPIError(resp, respBytes)
 	}
 	return respBytes, nil
 }
 
-func (c *BaseHttpClient) Post(ctx context.Context, url string, headers map[string]string, body []byte) ([]byte, error) {
-	// println("url: " + url)
-	client := http.Client{}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+// PostStream sends a POST request and returns the response body unread so the
+// caller can consume a streamed (e.g. text/event-stream) response. The caller
+// must close the returned body.
+func (c *BaseHttpClient) PostStream(ctx context.Context, url string, headers map[string]string, body []byte) (io.ReadCloser, error) {
+	streamHeaders := make(map[string]string, len(headers)+1)
+	for hk, hv := range headers {
+		streamHeaders[hk] = hv
+	}
+	streamHeaders["Accept"] = "text/event-stream"
+
+	resp, err := c.do(ctx, http.MethodPost, url, streamHeaders, body, true)
 	if err != nil {
 		return nil, err
 	}
-	for hk, hv := range headers {
-		req.Header.Add(hk, hv)
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		respBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, newAPIError(resp, respBytes)
 	}
 
-	// reqpb, err := httputil.DumpRequest(req, true)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// println(string(reqpb))
+	return resp.Body, nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// do sends the request, retrying per c.Retry. The last response is returned
+// as is once it succeeds, isn't retryable or the attempts are exhausted. For
+// stream requests c.Timeout only applies until the response headers arrive.
+func (c *BaseHttpClient) do(ctx context.Context, method string, url string, headers map[string]string, body []byte, stream bool) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.attempt(ctx, method, url, headers, body, stream)
+		if err != nil {
+			if ctx.Err() != nil || !isTransientError(err) || attempt >= c.Retry.MaxAttempts {
+				return nil, err
+			}
+			delay := c.backoff(attempt, 0)
+			if exceedsDeadline(ctx, delay) {
+				return nil, err
+			}
+			logger.Warning("Request failed: %v. Retrying in %s [%d/%d]", err, delay, attempt+1, c.Retry.MaxAttempts)
+			if err := sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode == 200 || !shouldRetry(resp) || attempt >= c.Retry.MaxAttempts {
+			return resp, nil
+		}
+		delay := c.backoff(attempt, serverDelay(resp))
+		if exceedsDeadline(ctx, delay) {
+			return resp, nil
+		}
+
+		// let the connection be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		logger.Warning("Request status: %s. Retrying in %s [%d/%d]", resp.Status, delay, attempt+1, c.Retry.MaxAttempts)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *BaseHttpClient) attempt(ctx context.Context, method string, url string, headers map[string]string, body []byte, stream bool) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
 	}
-	defer resp.Body.Close()
 
-	respDump, err := httputil.DumpResponse(resp, true)
+	var timer *time.Timer
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	if c.Timeout > 0 {
+		timer = time.AfterFunc(c.Timeout, func() { cancel(errRequestTimeout) })
+	}
+	release := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel(nil)
+	}
+
+	req, err := http.NewRequestWithContext(attemptCtx, method, url, bodyReader)
 	if err != nil {
+		release()
 		return nil, err
 	}
+	for hk, hv := range headers {
+		req.Header.Set(hk, hv)
+	}
+	for hk, hv := range c.Headers {
+		req.Header.Set(hk, hv)
+	}
 
-	respBytes, err := io.ReadAll(resp.Body)
+	resp, err := c.Client.Do(req)
 	if err != nil {
+		if context.Cause(attemptCtx) == errRequestTimeout {
+			err = errRequestTimeout
+		}
+		release()
 		return nil, err
 	}
+	if stream && timer != nil {
+		timer.Stop()
+	}
+	resp.Body = &attemptBody{ReadCloser: resp.Body, ctx: attemptCtx, release: release}
+	return resp, nil
+}
 
-	if resp.StatusCode != 200 {
-		if resp.StatusCode == 429 { // too many requests
-			sleepSeconds := 5
-			sleepTime := time.Duration(sleepSeconds) * time.Second
-			logger.Warning("Request status code: %d. Retrying after %d second(s)", resp.StatusCode, sleepTime)
-			time.Sleep(sleepTime)
-			return c.Post(ctx, url, headers, body)
-		}
-		return respBytes, fmt.Errorf("Non-200 POST request. Status: %s.\nResponse dump:\n\n%s\n", resp.Status, string(respDump))
+// errRequestTimeout is the cause of attempts exceeding BaseHttpClient.Timeout.
+var errRequestTimeout = fmt.Errorf("request timeout: %w", context.DeadlineExceeded)
+
+// attemptBody releases the context of the attempt once the body is closed.
+type attemptBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	release func()
+}
+
+func (b *attemptBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && context.Cause(b.ctx) == errRequestTimeout {
+		err = errRequestTimeout
 	}
+	return n, err
+}
 
-	return respBytes, nil
+func (b *attemptBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// headers carrying the request id, by provider convention
+var requestIdHeaders = []string{"x-request-id", "request-id", "x-goog-request-id"}
+
+// newAPIError builds the error for a non-200 response. Provider, Code and Type
+// are left to the provider, which knows the shape of its error body.
+func newAPIError(resp *http.Response, body []byte) *core.APIError {
+	apiErr := &core.APIError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		Retryable:  core.IsRetryableStatus(resp.StatusCode),
+		Body:       body,
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = resp.Status
+	}
+	for _, h := range requestIdHeaders {
+		if id := resp.Header.Get(h); id != "" {
+			apiErr.RequestId = id
+			break
+		}
+	}
+	return apiErr
 }
@@ -0,0 +1,112 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/http"
+)
+
+func TestPost_APIError(t *testing.T) {
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("x-request-id", "req_123")
+		w.WriteHeader(nethttp.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"message":"overloaded"}}`))
+	}))
+	defer srv.Close()
+
+	c := http.New(core.ProviderConfig{Retry: core.RetryPolicy{MaxAttempts: 1}})
+	_, err := c.Post(context.Background(), srv.URL, nil, []byte("{}"))
+	var apiErr *core.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *core.APIError, got %T: %v", err, err)
+	}
+	if apiErr.StatusCode != 503 || !apiErr.Retryable || apiErr.RequestId != "req_123" {
+		t.Errorf("unexpected error: %+v", apiErr)
+	}
+	if string(apiErr.Body) != `{"error":{"message":"overloaded"}}` {
+		t.Errorf("unexpected body: %s", apiErr.Body)
+	}
+}
+
+func TestPost_Retry(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		switch attempts.Add(1) {
+		case 1:
+			w.WriteHeader(529)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(nethttp.StatusTooManyRequests)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+
+	c := http.New(core.ProviderConfig{Retry: core.RetryPolicy{BaseDelay: time.Millisecond}})
+	resp, err := c.Post(context.Background(), srv.URL, nil, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "ok" || attempts.Load() != 3 {
+		t.Errorf("unexpected result %q after %d attempts", resp, attempts.Load())
+	}
+}
+
+func TestPost_RetryDeadline(t *testing.T) {
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(nethttp.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := http.New(core.ProviderConfig{}).Post(ctx, srv.URL, nil, []byte("{}"))
+	var apiErr *core.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 {
+		t.Fatalf("expected 429 *core.APIError, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("expected no retry past the context deadline")
+	}
+}
+
+func TestPost_HeadersAndTimeout(t *testing.T) {
+	var attempts atomic.Int32
+	// the first attempt hangs until the client gave up on it
+	release := make(chan struct{})
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if attempts.Add(1) == 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte(r.Header.Get("X-Gateway") + " " + r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := http.New(core.ProviderConfig{
+		Headers:        map[string]string{"X-Gateway": "gw", "Authorization": "Bearer override"},
+		RequestTimeout: 100 * time.Millisecond,
+		Retry:          core.RetryPolicy{BaseDelay: time.Millisecond},
+	})
+	resp, err := c.Post(context.Background(), srv.URL, map[string]string{"Authorization": "Bearer key"}, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "gw Bearer override" || attempts.Load() != 2 {
+		t.Errorf("unexpected result %q after %d attempts", resp, attempts.Load())
+	}
+}
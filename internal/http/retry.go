@@ -0,0 +1,123 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/assagman/apc/core"
+)
+
+// rate limit reset headers, used when a 429 response has no Retry-After
+var rateLimitResetHeaders = []string{
+	"x-ratelimit-reset-requests",         // openai, groq: duration, e.g. 1m30.5s
+	"x-ratelimit-reset-tokens",           // openai, groq
+	"anthropic-ratelimit-requests-reset", // RFC 3339 timestamp
+	"anthropic-ratelimit-tokens-reset",   // RFC 3339 timestamp
+	"x-ratelimit-reset",                  // openrouter: unix time in ms
+}
+
+// shouldRetry reports whether the non-200 response is worth retrying.
+func shouldRetry(resp *http.Response) bool {
+	// anthropic tells explicitly whether a retry makes sense
+	switch resp.Header.Get("x-should-retry") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return core.IsRetryableStatus(resp.StatusCode)
+}
+
+// isTransientError reports whether a failed round trip may succeed if
+// repeated, e.g. on connection resets or timeouts.
+func isTransientError(err error) bool {
+	if errors.Is(err, errRequestTimeout) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// serverDelay returns the delay requested by the server, or 0 if the response
+// doesn't request one.
+func serverDelay(resp *http.Response) time.Duration {
+	if ms := resp.Header.Get("retry-after-ms"); ms != "" {
+		if v, err := strconv.ParseFloat(ms, 64); err == nil && v > 0 {
+			return time.Duration(v * float64(time.Millisecond))
+		}
+	}
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.ParseFloat(ra, 64); err == nil && secs > 0 {
+			return time.Duration(secs * float64(time.Second))
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return time.Until(t)
+		}
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0
+	}
+	var delay time.Duration
+	for _, h := range rateLimitResetHeaders {
+		if d := parseResetHeader(resp.Header.Get(h)); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+func parseResetHeader(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return time.Until(t)
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Until(time.UnixMilli(ms))
+	}
+	return 0
+}
+
+// backoff returns the delay before the retry following the given attempt.
+func (c *BaseHttpClient) backoff(attempt int, serverDelay time.Duration) time.Duration {
+	delay := c.Retry.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > c.Retry.MaxDelay { // <= 0: overflow
+		delay = c.Retry.MaxDelay
+	}
+	delay -= time.Duration(c.Retry.Jitter * rand.Float64() * float64(delay))
+	if serverDelay > delay {
+		delay = min(serverDelay, c.Retry.MaxDelay)
+	}
+	return delay
+}
+
+// sleep waits for delay unless ctx is done before.
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// exceedsDeadline reports whether ctx would expire while waiting for delay,
+// in which case there is no point in retrying.
+func exceedsDeadline(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < delay
+}
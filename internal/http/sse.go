@@ -0,0 +1,68 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxSSELineSize bounds a single SSE line. Tool call arguments and large text
+// deltas can exceed bufio.Scanner's 64KiB default.
+const maxSSELineSize = 10 * 1024 * 1024
+
+// SSEEvent is a single dispatched server-sent event.
+type SSEEvent struct {
+	Event string
+	Data  string
+	Id    string
+}
+
+// ReadSSE parses a text/event-stream body and calls fn for every dispatched
+// event. Multi-line data fields are joined with "\n" as per the SSE spec.
+// Reading stops at EOF or at the first error returned by fn.
+func ReadSSE(r io.Reader, fn func(SSEEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSSELineSize)
+
+	var event SSEEvent
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			event = SSEEvent{}
+			return nil
+		}
+		event.Data = strings.Join(data, "\n")
+		err := fn(event)
+		event = SSEEvent{}
+		data = data[:0]
+		return err
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			if err := dispatch(); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") { // comment, e.g. keep-alive
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			event.Id = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// flush a trailing event that wasn't followed by a blank line
+	return dispatch()
+}
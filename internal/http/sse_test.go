@@ -0,0 +1,61 @@
+package http_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/assagman/apc/internal/http"
+)
+
+func TestReadSSE(t *testing.T) {
+	body := ": keep-alive\n" +
+		"event: message_start\n" +
+		"data: {\"a\":1}\n" +
+		"\n" +
+		"data: line1\r\n" +
+		"data: line2\r\n" +
+		"\r\n" +
+		"id: 7\n" +
+		"data:no-space\n"
+
+	var events []http.SSEEvent
+	err := http.ReadSSE(strings.NewReader(body), func(ev http.SSEEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []http.SSEEvent{
+		{Event: "message_start", Data: `{"a":1}`},
+		{Data: "line1\nline2"},
+		{Id: "7", Data: "no-space"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, expected[i], events[i])
+		}
+	}
+}
+
+func TestReadSSE_StopsOnHandlerError(t *testing.T) {
+	body := "data: 1\n\ndata: 2\n\n"
+	stop := errors.New("stop")
+
+	calls := 0
+	err := http.ReadSSE(strings.NewReader(body), func(ev http.SSEEvent) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("expected handler error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
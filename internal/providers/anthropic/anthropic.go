@@ -2,19 +2,26 @@ package anthropic
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"slices"
+	"strings"
 
 	// "github.com/assagman/apc/internal/core"
 	"github.com/assagman/apc/core"
 	"github.com/assagman/apc/internal/http"
+	"github.com/assagman/apc/internal/providers"
 	"github.com/assagman/apc/internal/tools"
 )
 
-const chatCompletionRequestUrl = "https://api.anthropic.com/v1/messages"
-const maxTokens = 10000
+const (
+	defaultBaseUrl            = "https://api.anthropic.com/v1"
+	chatCompletionRequestPath = "/messages"
+)
+const defaultMaxTokens = 10000
 const (
 	roleUser  = "user"
 	roleModel = "assistant"
@@ -33,6 +40,8 @@ type Provider struct {
 	SystemPrompt string
 	History      []Message
 	Tools        []Tool
+	MaxTokens    int
+	HttpClient   *http.BaseHttpClient
 }
 
 type Message struct {
@@ -46,6 +55,7 @@ type Request struct {
 	MaxTokens int       `json:"max_tokens"`
 	System    string    `json:"system"`
 	Tools     []Tool    `json:"tools"`
+	Stream    bool      `json:"stream,omitempty"`
 }
 
 type ToolCall struct {
@@ -54,20 +64,62 @@ type ToolCall struct {
 	Arguments string `json:"args,omitempty"`
 }
 
+type ImageSource struct {
+	Type      string `json:"type"` // base64, url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Url       string `json:"url,omitempty"`
+}
+
 type Content struct {
-	Type              string          `json:"type,omitempty"` // text, tool_use
+	Type              string          `json:"type,omitempty"` // text, image, tool_use, tool_result
 	Text              string          `json:"text,omitempty"`
+	Source            *ImageSource    `json:"source,omitempty"`
 	ToolId            string          `json:"id,omitempty"`
 	ToolUseId         string          `json:"tool_use_id,omitempty"`
 	ToolName          string          `json:"name,omitempty"`
 	ToolInput         json.RawMessage `json:"input,omitempty"`
-	ToolResultContent string          `json:"content,omitempty"`
+	ToolResultContent any             `json:"content,omitempty"` // string or []Content
+	IsError           bool            `json:"is_error,omitempty"`
+}
+
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 }
 
 type Response struct {
 	Role       string    `json:"role"`
 	Content    []Content `json:"content"`
 	StopReason string    `json:"stop_reason"`
+	Usage      Usage     `json:"usage"`
+}
+
+type StreamDelta struct {
+	Type        string `json:"type,omitempty"` // text_delta, input_json_delta
+	Text        string `json:"text,omitempty"`
+	PartialJson string `json:"partial_json,omitempty"`
+	StopReason  string `json:"stop_reason,omitempty"` // message_delta only
+}
+
+type StreamChunk struct {
+	Type         string          `json:"type"`
+	Index        int             `json:"index"`
+	Message      *Response       `json:"message,omitempty"`
+	ContentBlock *Content        `json:"content_block,omitempty"`
+	Delta        *StreamDelta    `json:"delta,omitempty"`
+	Usage        *Usage          `json:"usage,omitempty"` // message_delta only, cumulative
+	Error        json.RawMessage `json:"error,omitempty"`
+}
+
+type ErrorBody struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Error     *ErrorBody `json:"error"`
+	RequestId string     `json:"request_id"`
 }
 
 type Tool struct {
@@ -84,15 +136,24 @@ func CheckModelName(model string) error {
 	return nil
 }
 
+func init() {
+	providers.Register("anthropic", New)
+}
+
 func New(config core.ProviderConfig) (core.IProvider, error) {
 	CheckModelName(config.Model)
 	p := &Provider{
 		Name:         "anthropic",
-		Endpoint:     chatCompletionRequestUrl,
+		Endpoint:     http.Endpoint(config.BaseURL, defaultBaseUrl, chatCompletionRequestPath),
 		Model:        config.Model,
 		SystemPrompt: config.SystemPrompt,
 		History:      make([]Message, 0),
 		Tools:        make([]Tool, 0),
+		MaxTokens:    defaultMaxTokens,
+		HttpClient:   http.New(config),
+	}
+	if config.Limits.MaxOutputTokens > 0 {
+		p.MaxTokens = config.Limits.MaxOutputTokens
 	}
 	p.Tools = append(p.Tools, p.GetToolsAdapter(config.APCTools.Tools)...)
 	return p, nil
@@ -100,7 +161,7 @@ func New(config core.ProviderConfig) (core.IProvider, error) {
 
 func (p *Provider) GetApiKey() string { return os.Getenv("ANTHROPIC_API_KEY") }
 
-func (p *Provider) GetEndpoint() string { return chatCompletionRequestUrl }
+func (p *Provider) GetEndpoint() string { return p.Endpoint }
 
 func (p *Provider) GetHeaders() map[string]string {
 	return map[string]string{
@@ -124,6 +185,16 @@ func (p *Provider) FinishReasonStop() string { return stopReasonStop }
 
 func (p *Provider) FinishReasonToolCall() string { return stopReasonToolUse }
 
+func (p *Provider) FinishReasonMaxTokens() string { return stopReasonMaxTokens }
+
+func (p *Provider) GetUsageFromResponse(resp core.GenericResponse) (core.Usage, error) {
+	response, ok := resp.(Response)
+	if !ok {
+		return core.Usage{}, fmt.Errorf("[GetUsageFromResponse] Failed to cast core.GenericResponse -> %s.Response", p.Name)
+	}
+	return core.Usage{InputTokens: response.Usage.InputTokens, OutputTokens: response.Usage.OutputTokens}, nil
+}
+
 func (p *Provider) GetAnswerFromResponse(resp core.GenericResponse) (string, error) {
 	response, ok := resp.(Response)
 	if !ok {
@@ -188,6 +259,208 @@ func (p *Provider) GetMessageHistory() any {
 	return p.History
 }
 
+func (p *Provider) SetMessageHistory(history any) error {
+	messages, ok := history.([]Message)
+	if !ok {
+		return fmt.Errorf("[SetMessageHistory] Failed to cast history -> []%s.Message", p.Name)
+	}
+	p.History = slices.Clone(messages)
+	return nil
+}
+
+// ExportHistory converts the history to core messages. tool_result blocks
+// are split from user messages into core.RoleTool messages.
+func (p *Provider) ExportHistory() ([]core.Message, error) {
+	messages := make([]core.Message, 0, len(p.History))
+	toolNames := make(map[string]string)
+	for _, msg := range p.History {
+		var parts, toolResults []core.Part
+		for _, content := range msg.Content {
+			switch content.Type {
+			case "text":
+				parts = append(parts, core.TextPart(content.Text))
+			case "image":
+				image, err := imageFromSource(content.Source)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, core.ImagePart(image))
+			case "tool_use":
+				args, err := core.NormalizeToolArguments(content.ToolInput)
+				if err != nil {
+					return nil, fmt.Errorf("[ExportHistory] Tool call `%s`: %w", content.ToolName, err)
+				}
+				toolNames[content.ToolId] = content.ToolName
+				parts = append(parts, core.ToolCallPart(core.ToolCall{
+					Id:        content.ToolId,
+					Name:      content.ToolName,
+					Arguments: args,
+				}))
+			case "tool_result":
+				toolResult, err := toolResultFromContent(content.ToolResultContent)
+				if err != nil {
+					return nil, err
+				}
+				toolResult.ToolCallId = content.ToolUseId
+				toolResult.Name = toolNames[content.ToolUseId]
+				toolResult.IsError = content.IsError
+				toolResults = append(toolResults, core.ToolResultPart(toolResult))
+			default:
+				return nil, fmt.Errorf("[ExportHistory] Unsupported content type: %s", content.Type)
+			}
+		}
+		if len(toolResults) > 0 {
+			messages = append(messages, core.Message{Role: core.RoleTool, Parts: toolResults})
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		switch msg.Role {
+		case roleUser:
+			messages = append(messages, core.Message{Role: core.RoleUser, Parts: parts})
+		case roleModel:
+			messages = append(messages, core.Message{Role: core.RoleAssistant, Parts: parts})
+		default:
+			return nil, fmt.Errorf("[ExportHistory] Unexpected role: %s", msg.Role)
+		}
+	}
+	return messages, nil
+}
+
+// ImportHistory replaces the history with the given core messages. Tool
+// results are sent as tool_result blocks of a user message.
+func (p *Provider) ImportHistory(messages []core.Message) error {
+	history := make([]Message, 0, len(messages))
+	for _, msg := range messages {
+		message := Message{Role: roleUser}
+		if msg.Role == core.RoleAssistant {
+			message.Role = roleModel
+		}
+		for _, part := range msg.Parts {
+			switch {
+			case part.Type == core.PartTypeText && msg.Role != core.RoleTool:
+				message.Content = append(message.Content, Content{Type: "text", Text: part.Text})
+			case part.Type == core.PartTypeImage && part.Image != nil && msg.Role == core.RoleUser:
+				message.Content = append(message.Content, Content{Type: "image", Source: imageToSource(*part.Image)})
+			case part.Type == core.PartTypeToolCall && part.ToolCall != nil && msg.Role == core.RoleAssistant:
+				args, err := core.NormalizeToolArguments(part.ToolCall.Arguments)
+				if err != nil {
+					return fmt.Errorf("[ImportHistory] Tool call `%s`: %w", part.ToolCall.Name, err)
+				}
+				message.Content = append(message.Content, Content{
+					Type:      "tool_use",
+					ToolId:    part.ToolCall.Id,
+					ToolName:  part.ToolCall.Name,
+					ToolInput: args,
+				})
+			case part.Type == core.PartTypeToolResult && part.ToolResult != nil && msg.Role == core.RoleTool:
+				message.Content = append(message.Content, toolResultToContent(part.ToolResult.ToolCallId, *part.ToolResult))
+			default:
+				return fmt.Errorf("[ImportHistory] Unexpected `%s` part in `%s` message", part.Type, msg.Role)
+			}
+		}
+		history = append(history, message)
+	}
+	p.History = history
+	return nil
+}
+
+// toolResultToContent returns the tool_result block of a result. The content
+// is a plain string unless the result has multiple parts.
+func toolResultToContent(toolUseId string, result core.ToolResult) Content {
+	content := Content{Type: "tool_result", ToolUseId: toolUseId, IsError: result.IsError}
+	if len(result.Parts) == 0 {
+		content.ToolResultContent = result.Content
+		return content
+	}
+	blocks := make([]Content, 0, len(result.Parts)+1)
+	if result.Content != "" {
+		blocks = append(blocks, Content{Type: "text", Text: result.Content})
+	}
+	for _, part := range result.Parts {
+		switch {
+		case part.Type == core.PartTypeText:
+			blocks = append(blocks, Content{Type: "text", Text: part.Text})
+		case part.Type == core.PartTypeImage && part.Image != nil:
+			blocks = append(blocks, Content{Type: "image", Source: imageToSource(*part.Image)})
+		}
+	}
+	content.ToolResultContent = blocks
+	return content
+}
+
+// toolResultFromContent converts the content of a tool_result block. The
+// leading text block of multi-part content becomes the result's Content.
+func toolResultFromContent(resultContent any) (core.ToolResult, error) {
+	var blocks []Content
+	switch c := resultContent.(type) {
+	case nil:
+		return core.ToolResult{}, nil
+	case string:
+		return core.ToolResult{Content: c}, nil
+	case []Content:
+		blocks = c
+	default: // e.g. []any after a JSON round trip
+		b, err := json.Marshal(c)
+		if err != nil {
+			return core.ToolResult{}, err
+		}
+		if err := json.Unmarshal(b, &blocks); err != nil {
+			return core.ToolResult{}, fmt.Errorf("[toolResultFromContent] Unexpected tool result content: %s", string(b))
+		}
+	}
+
+	var result core.ToolResult
+	for i, block := range blocks {
+		switch block.Type {
+		case "text":
+			if i == 0 {
+				result.Content = block.Text
+			} else {
+				result.Parts = append(result.Parts, core.TextPart(block.Text))
+			}
+		case "image":
+			image, err := imageFromSource(block.Source)
+			if err != nil {
+				return core.ToolResult{}, err
+			}
+			result.Parts = append(result.Parts, core.ImagePart(image))
+		default:
+			return core.ToolResult{}, fmt.Errorf("[toolResultFromContent] Unsupported content type: %s", block.Type)
+		}
+	}
+	return result, nil
+}
+
+func imageToSource(image core.Image) *ImageSource {
+	if image.URL != "" {
+		return &ImageSource{Type: "url", Url: image.URL}
+	}
+	return &ImageSource{
+		Type:      "base64",
+		MediaType: image.MimeType,
+		Data:      base64.StdEncoding.EncodeToString(image.Data),
+	}
+}
+
+func imageFromSource(source *ImageSource) (core.Image, error) {
+	if source == nil {
+		return core.Image{}, fmt.Errorf("[imageFromSource] image content without source")
+	}
+	if source.Type == "url" {
+		return core.Image{URL: source.Url}, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return core.Image{}, fmt.Errorf("[imageFromSource] Invalid base64 image data: %w", err)
+	}
+	return core.Image{MimeType: source.MediaType, Data: data}, nil
+}
+
+func (p *Provider) ResetMessageHistory() {
+	p.History = make([]Message, 0)
+}
+
 func (p *Provider) IsSenderRole(msg core.GenericMessage) (bool, error) {
 	message, ok := msg.(Message)
 	if !ok {
@@ -232,7 +505,7 @@ func (p *Provider) NewRequest() (core.GenericRequest, error) {
 		System:    p.SystemPrompt,
 		Tools:     p.Tools,
 		Messages:  p.History,
-		MaxTokens: maxTokens,
+		MaxTokens: p.MaxTokens,
 	}, nil
 }
 
@@ -252,18 +525,11 @@ func (p *Provider) ConstructSystemPromptMessage() Message {
 	return Message{} // TODO: No sys msg in anthropic, it's at top level
 }
 
-func (p *Provider) ConstructToolMessage(tooCall tools.ToolCall, toolResult string) core.GenericMessage {
+func (p *Provider) ConstructToolMessage(tooCall tools.ToolCall, toolResult core.ToolResult) core.GenericMessage {
 	return Message{
-		Role: roleUser,
-		Content: []Content{
-			{
-				Type:              "tool_result",
-				ToolUseId:         tooCall.Id,
-				ToolResultContent: toolResult,
-			},
-		},
+		Role:    roleUser,
+		Content: []Content{toolResultToContent(tooCall.Id, toolResult)},
 	}
-
 }
 
 func (p *Provider) ConstructUserPromptMessage(prompt string) core.GenericMessage {
@@ -284,16 +550,149 @@ func (p *Provider) SendRequest(ctx context.Context, req core.GenericRequest) (co
 		return nil, err
 	}
 
-	c := http.New()
+	c := p.HttpClient
 	respBytes, err := c.Post(ctx, p.Endpoint, p.GetHeaders(), reqBytes)
 	if err != nil {
-		return nil, err
+		return nil, p.wrapAPIError(err)
 	}
 
 	var resp Response
 	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		return nil, &core.DecodeError{Provider: p.Name, Body: respBytes, Err: err}
+	}
+
+	return resp, nil
+}
+
+func (p *Provider) SendStreamRequest(ctx context.Context, request core.GenericRequest, onEvent core.StreamHandler) (core.GenericResponse, error) {
+	req, ok := request.(Request)
+	if !ok {
+		return nil, fmt.Errorf("[SendStreamRequest] Failed to cast core.GenericRequest -> %s.Request", p.Name)
+	}
+	req.Stream = true
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c := p.HttpClient
+	body, err := c.PostStream(ctx, p.Endpoint, p.GetHeaders(), reqBytes)
+	if err != nil {
+		return nil, p.wrapAPIError(err)
+	}
+	defer body.Close()
+
+	resp := Response{Role: roleModel}
+	toolInputs := make(map[int]*strings.Builder)
+	err = http.ReadSSE(body, func(ev http.SSEEvent) error {
+		var chunk StreamChunk
+		if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+			return &core.DecodeError{Provider: p.Name, Body: []byte(ev.Data), Err: err}
+		}
+		switch chunk.Type {
+		case "message_start":
+			if chunk.Message != nil {
+				if chunk.Message.Role != "" {
+					resp.Role = chunk.Message.Role
+				}
+				resp.Usage = chunk.Message.Usage
+			}
+		case "content_block_start":
+			if chunk.ContentBlock == nil {
+				return fmt.Errorf("[SendStreamRequest][%s] content_block_start without content_block", p.Name)
+			}
+			for len(resp.Content) <= chunk.Index {
+				resp.Content = append(resp.Content, Content{})
+			}
+			resp.Content[chunk.Index] = *chunk.ContentBlock
+			if chunk.ContentBlock.Type == "tool_use" {
+				toolInputs[chunk.Index] = &strings.Builder{}
+				onEvent(core.StreamEvent{
+					Type:          core.StreamEventToolCallDelta,
+					ToolCallIndex: chunk.Index,
+					ToolCallId:    chunk.ContentBlock.ToolId,
+					ToolName:      chunk.ContentBlock.ToolName,
+				})
+			}
+		case "content_block_delta":
+			if chunk.Delta == nil || chunk.Index >= len(resp.Content) {
+				return fmt.Errorf("[SendStreamRequest][%s] Unexpected content_block_delta for block %d", p.Name, chunk.Index)
+			}
+			block := &resp.Content[chunk.Index]
+			switch chunk.Delta.Type {
+			case "text_delta":
+				block.Text += chunk.Delta.Text
+				onEvent(core.StreamEvent{Type: core.StreamEventTextDelta, Text: chunk.Delta.Text})
+			case "input_json_delta":
+				if input, ok := toolInputs[chunk.Index]; ok {
+					input.WriteString(chunk.Delta.PartialJson)
+				}
+				onEvent(core.StreamEvent{
+					Type:           core.StreamEventToolCallDelta,
+					ToolCallIndex:  chunk.Index,
+					ToolCallId:     block.ToolId,
+					ToolName:       block.ToolName,
+					ArgumentsDelta: chunk.Delta.PartialJson,
+				})
+			}
+		case "content_block_stop":
+			if input, ok := toolInputs[chunk.Index]; ok && chunk.Index < len(resp.Content) {
+				inputStr := input.String()
+				if strings.TrimSpace(inputStr) == "" {
+					inputStr = "{}"
+				}
+				resp.Content[chunk.Index].ToolInput = json.RawMessage(inputStr)
+			}
+		case "message_delta":
+			if chunk.Delta != nil && chunk.Delta.StopReason != "" {
+				resp.StopReason = chunk.Delta.StopReason
+			}
+			if chunk.Usage != nil {
+				resp.Usage.OutputTokens = chunk.Usage.OutputTokens
+				if chunk.Usage.InputTokens > 0 {
+					resp.Usage.InputTokens = chunk.Usage.InputTokens
+				}
+			}
+		case "error":
+			apiErr := &core.APIError{Provider: p.Name, Body: []byte(ev.Data)}
+			p.fillAPIError(apiErr)
+			return apiErr
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return resp, nil
 }
+
+// wrapAPIError fills the provider name and the error details of the response
+// body into err if it is a *core.APIError.
+func (p *Provider) wrapAPIError(err error) error {
+	var apiErr *core.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	apiErr.Provider = p.Name
+	p.fillAPIError(apiErr)
+	return apiErr
+}
+
+func (p *Provider) fillAPIError(apiErr *core.APIError) {
+	var body ErrorResponse
+	if json.Unmarshal(apiErr.Body, &body) != nil || body.Error == nil {
+		return
+	}
+	apiErr.Type = body.Error.Type
+	if body.Error.Message != "" {
+		apiErr.Message = body.Error.Message
+	}
+	if body.RequestId != "" {
+		apiErr.RequestId = body.RequestId
+	}
+	switch body.Error.Type {
+	case "rate_limit_error", "overloaded_error", "api_error":
+		apiErr.Retryable = true
+	}
+}
@@ -0,0 +1,366 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/http"
+	"github.com/assagman/apc/internal/tools"
+)
+
+const chatCompletionRequestPath = "/chat/completions"
+
+const (
+	stopReasonStop      = "stop"
+	stopReasonMaxTokens = "length"
+	stopReasonToolUse   = "tool_calls"
+)
+
+// ChatCompletionConfig describes a chat completions API. It's all that
+// differs between the providers built on ChatCompletionProvider.
+type ChatCompletionConfig struct {
+	Name string
+	// DefaultBaseURL is used unless core.ProviderConfig.BaseURL is set. Empty
+	// if the latter is required.
+	DefaultBaseURL string
+	// APIKeyEnv is the environment variable holding the API key, sent as a
+	// bearer token. No Authorization header is sent without a key.
+	APIKeyEnv string
+	// Headers are sent with every request, on top of Content-Type and
+	// Authorization.
+	Headers map[string]string
+	// StringContent sends message content as a plain string instead of an
+	// array of parts, for APIs that don't support the latter.
+	StringContent bool
+	// LegacyMaxTokens sends the output token limit as `max_tokens` instead of
+	// `max_completion_tokens`.
+	LegacyMaxTokens bool
+}
+
+// ChatCompletionProvider implements core.IProvider for a chat completions
+// API. Providers embed it and override the methods their API needs to.
+type ChatCompletionProvider struct {
+	ChatCompletionConfig
+	Endpoint     string
+	Model        string
+	SystemPrompt string
+	History      []Message
+	Tools        []tools.Tool
+	MaxTokens    int
+	HttpClient   *http.BaseHttpClient
+}
+
+type ChatCompletionRequest struct {
+	Model               string                  `json:"model"`
+	Messages            []Message               `json:"messages"`
+	Tools               []tools.Tool            `json:"tools,omitempty"`
+	Provider            *core.SubProviderConfig `json:"provider,omitempty"` // openrouter only
+	MaxCompletionTokens int                     `json:"max_completion_tokens,omitempty"`
+	MaxTokens           int                     `json:"max_tokens,omitempty"`
+	Stream              bool                    `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions          `json:"stream_options,omitempty"`
+}
+
+type ChatCompletionChoice struct {
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+type ChatCompletionResponse struct {
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   *Usage                 `json:"usage,omitempty"`
+}
+
+// NewChatCompletionProvider returns a provider for the API described by chat,
+// configured by config.
+func NewChatCompletionProvider(chat ChatCompletionConfig, config core.ProviderConfig) *ChatCompletionProvider {
+	p := &ChatCompletionProvider{
+		ChatCompletionConfig: chat,
+		Endpoint:             http.Endpoint(config.BaseURL, chat.DefaultBaseURL, chatCompletionRequestPath),
+		Model:                config.Model,
+		SystemPrompt:         config.SystemPrompt,
+		Tools:                config.APCTools.Tools,
+		MaxTokens:            config.Limits.MaxOutputTokens,
+		HttpClient:           http.New(config),
+	}
+	p.History = []Message{p.ConstructSystemPromptMessage()}
+	return p
+}
+
+func (p *ChatCompletionProvider) GetApiKey() string {
+	if p.APIKeyEnv == "" {
+		return ""
+	}
+	return os.Getenv(p.APIKeyEnv)
+}
+
+func (p *ChatCompletionProvider) GetEndpoint() string { return p.Endpoint }
+
+func (p *ChatCompletionProvider) GetHeaders() map[string]string {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	if apiKey := p.GetApiKey(); apiKey != "" {
+		headers["Authorization"] = "Bearer " + apiKey
+	}
+	for hk, hv := range p.Headers {
+		headers[hk] = hv
+	}
+	return headers
+}
+
+func (p *ChatCompletionProvider) FinishReasonStop() string { return stopReasonStop }
+
+func (p *ChatCompletionProvider) FinishReasonToolCall() string { return stopReasonToolUse }
+
+func (p *ChatCompletionProvider) FinishReasonMaxTokens() string { return stopReasonMaxTokens }
+
+// content returns text as message content, in the shape the API accepts.
+func (p *ChatCompletionProvider) content(text string) any {
+	if p.StringContent {
+		return text
+	}
+	return []Part{
+		{
+			Type: "text",
+			Text: text,
+		},
+	}
+}
+
+func (p *ChatCompletionProvider) ConstructSystemPromptMessage() Message {
+	return Message{
+		Role:    roleSys,
+		Content: p.content(p.SystemPrompt),
+	}
+}
+
+func (p *ChatCompletionProvider) ConstructUserPromptMessage(prompt string) core.GenericMessage {
+	return Message{
+		Role:    roleUser,
+		Content: p.content(prompt),
+	}
+}
+
+func (p *ChatCompletionProvider) ConstructToolMessage(toolCall tools.ToolCall, toolResult core.ToolResult) core.GenericMessage {
+	return Message{
+		Role:       roleTool,
+		Content:    p.content(ToolResultText(toolResult)),
+		ToolCallId: toolCall.Id,
+		IsError:    toolResult.IsError,
+	}
+}
+
+func (p *ChatCompletionProvider) AppendMessageHistory(msg core.GenericMessage) error {
+	message, ok := msg.(Message)
+	if !ok {
+		return fmt.Errorf("[AppendMessageHistory] Failed to cast core.GenericMessage -> %s.Message", p.Name)
+	}
+
+	p.History = append(p.History, message)
+	return nil
+}
+
+func (p *ChatCompletionProvider) GetMessageHistory() any {
+	return p.History
+}
+
+func (p *ChatCompletionProvider) SetMessageHistory(history any) error {
+	messages, ok := history.([]Message)
+	if !ok {
+		return fmt.Errorf("[SetMessageHistory] Failed to cast history -> []%s.Message", p.Name)
+	}
+	p.History = slices.Clone(messages)
+	return nil
+}
+
+func (p *ChatCompletionProvider) ExportHistory() ([]core.Message, error) {
+	return ExportMessages(p.History)
+}
+
+func (p *ChatCompletionProvider) ImportHistory(messages []core.Message) error {
+	history, err := ImportMessages(messages, p.StringContent)
+	if err != nil {
+		return err
+	}
+	p.History = append([]Message{p.ConstructSystemPromptMessage()}, history...)
+	return nil
+}
+
+func (p *ChatCompletionProvider) ResetMessageHistory() {
+	p.History = []Message{p.ConstructSystemPromptMessage()}
+}
+
+func (p *ChatCompletionProvider) IsSenderRole(msg core.GenericMessage) (bool, error) {
+	message, ok := msg.(Message)
+	if !ok {
+		return false, fmt.Errorf("[IsSenderRole] Failed to cast core.GenericMessage -> %s.Message", p.Name)
+	}
+	senderRoles := []string{roleUser, roleDev, roleTool}
+	return slices.Contains(senderRoles, message.Role), nil
+}
+
+// choice returns the first choice of resp, the only one requested.
+func (p *ChatCompletionProvider) choice(caller string, resp core.GenericResponse) (ChatCompletionChoice, error) {
+	response, ok := resp.(ChatCompletionResponse)
+	if !ok {
+		return ChatCompletionChoice{}, fmt.Errorf("[%s] Failed to cast core.GenericResponse -> %s.Response", caller, p.Name)
+	}
+	if len(response.Choices) == 0 {
+		return ChatCompletionChoice{}, fmt.Errorf("[%s][%s] Empty choices in response", caller, p.Name)
+	}
+	return response.Choices[0], nil
+}
+
+func (p *ChatCompletionProvider) GetUsageFromResponse(resp core.GenericResponse) (core.Usage, error) {
+	response, ok := resp.(ChatCompletionResponse)
+	if !ok {
+		return core.Usage{}, fmt.Errorf("[GetUsageFromResponse] Failed to cast core.GenericResponse -> %s.Response", p.Name)
+	}
+	return response.Usage.ToCore(), nil
+}
+
+func (p *ChatCompletionProvider) GetAnswerFromResponse(resp core.GenericResponse) (string, error) {
+	choice, err := p.choice("GetAnswerFromResponse", resp)
+	if err != nil {
+		return "", err
+	}
+	if choice.Message.Content == nil {
+		return "", nil
+	}
+	answer, err := choice.Message.GetContentAsString()
+	if err != nil {
+		return "", fmt.Errorf("[GetAnswerFromResponse][%s] %w", p.Name, err)
+	}
+	return answer, nil
+}
+
+func (p *ChatCompletionProvider) GetFinishReasonFromResponse(resp core.GenericResponse) (string, error) {
+	choice, err := p.choice("GetFinishReasonFromResponse", resp)
+	if err != nil {
+		return "", err
+	}
+	return choice.FinishReason, nil
+}
+
+func (p *ChatCompletionProvider) GetMessageFromResponse(resp core.GenericResponse) (core.GenericMessage, error) {
+	choice, err := p.choice("GetMessageFromResponse", resp)
+	if err != nil {
+		return nil, err
+	}
+	return choice.Message, nil
+}
+
+func (p *ChatCompletionProvider) GetToolCallsFromResponse(resp core.GenericResponse) ([]tools.ToolCall, error) {
+	choice, err := p.choice("GetToolCallsFromResponse", resp)
+	if err != nil {
+		return nil, err
+	}
+	return choice.Message.ToolCalls, nil
+}
+
+func (p *ChatCompletionProvider) IsToolCall(resp core.GenericResponse) (bool, error) {
+	finishReason, err := p.GetFinishReasonFromResponse(resp)
+	if err != nil {
+		return false, err
+	}
+	switch finishReason {
+	case p.FinishReasonStop():
+		return false, nil
+	case p.FinishReasonToolCall():
+		return true, nil
+	default:
+		return false, fmt.Errorf("Unexpected finish reason: %s", finishReason)
+	}
+}
+
+func (p *ChatCompletionProvider) IsToolCallValid(toolCall tools.ToolCall) (bool, error) {
+	// some servers omit the type
+	return toolCall.Type == "function" || toolCall.Type == "", nil
+}
+
+func (p *ChatCompletionProvider) NewRequest() (core.GenericRequest, error) {
+	req := ChatCompletionRequest{
+		Model:    p.Model,
+		Tools:    p.Tools,
+		Messages: p.History,
+	}
+	if p.LegacyMaxTokens {
+		req.MaxTokens = p.MaxTokens
+	} else {
+		req.MaxCompletionTokens = p.MaxTokens
+	}
+	return req, nil
+}
+
+func (p *ChatCompletionProvider) SendRequest(ctx context.Context, request core.GenericRequest) (core.GenericResponse, error) {
+	req, ok := request.(ChatCompletionRequest)
+	if !ok {
+		return nil, fmt.Errorf("[SendRequest] Failed to cast core.GenericRequest -> %s.Request", p.Name)
+	}
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBytes, err := p.HttpClient.Post(ctx, p.Endpoint, p.GetHeaders(), reqBytes)
+	if err != nil {
+		return nil, WrapAPIError(p.Name, err)
+	}
+
+	var resp ChatCompletionResponse
+	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		return nil, &core.DecodeError{Provider: p.Name, Body: respBytes, Err: err}
+	}
+
+	return resp, nil
+}
+
+func (p *ChatCompletionProvider) SendStreamRequest(ctx context.Context, request core.GenericRequest, onEvent core.StreamHandler) (core.GenericResponse, error) {
+	req, ok := request.(ChatCompletionRequest)
+	if !ok {
+		return nil, fmt.Errorf("[SendStreamRequest] Failed to cast core.GenericRequest -> %s.Request", p.Name)
+	}
+	req.Stream = true
+	req.StreamOptions = &StreamOptions{IncludeUsage: true}
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.HttpClient.PostStream(ctx, p.Endpoint, p.GetHeaders(), reqBytes)
+	if err != nil {
+		return nil, WrapAPIError(p.Name, err)
+	}
+	defer body.Close()
+
+	stream := NewChatCompletionStream(p.Name)
+	if err := stream.Read(body, onEvent); err != nil {
+		return nil, err
+	}
+	toolCalls, err := stream.ToolCalls()
+	if err != nil {
+		return nil, err
+	}
+
+	message := Message{
+		Role:      roleModel,
+		ToolCalls: toolCalls,
+	}
+	if text := stream.Text(); text != "" {
+		message.Content = text
+	}
+	return ChatCompletionResponse{
+		Choices: []ChatCompletionChoice{
+			{
+				Message:      message,
+				FinishReason: stream.FinishReason(),
+			},
+		},
+		Usage: stream.Usage(),
+	}, nil
+}
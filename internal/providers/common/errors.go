@@ -0,0 +1,76 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/assagman/apc/core"
+)
+
+// ErrorBody is the error object of chat completions APIs:
+// `{"error": {"message": "...", "type": "...", "code": "..."}}`
+type ErrorBody struct {
+	Message string          `json:"message"`
+	Type    string          `json:"type"`
+	Code    json.RawMessage `json:"code"` // string, number or null depending on provider
+}
+
+// CodeString returns the error code without JSON quoting.
+func (e *ErrorBody) CodeString() string {
+	var code string
+	if err := json.Unmarshal(e.Code, &code); err == nil {
+		return code
+	}
+	if string(e.Code) == "null" {
+		return ""
+	}
+	return string(e.Code)
+}
+
+// WrapAPIError fills the provider name and the error details of the response
+// body into err if it is a *core.APIError. Other errors are returned as is.
+func WrapAPIError(providerName string, err error) error {
+	var apiErr *core.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	apiErr.Provider = providerName
+	var body struct {
+		Error *ErrorBody `json:"error"`
+	}
+	if json.Unmarshal(apiErr.Body, &body) == nil && body.Error != nil {
+		apiErr.Code = body.Error.CodeString()
+		apiErr.Type = body.Error.Type
+		if body.Error.Message != "" {
+			apiErr.Message = body.Error.Message
+		}
+	}
+	return apiErr
+}
+
+// NewStreamAPIError converts an error object received in the middle of a
+// stream, after the response status was already sent as 200.
+func NewStreamAPIError(providerName string, raw json.RawMessage) *core.APIError {
+	apiErr := &core.APIError{
+		Provider: providerName,
+		Message:  strings.TrimSpace(string(raw)),
+		Body:     raw,
+	}
+	var body ErrorBody
+	if json.Unmarshal(raw, &body) != nil {
+		return apiErr
+	}
+	apiErr.Code = body.CodeString()
+	apiErr.Type = body.Type
+	if body.Message != "" {
+		apiErr.Message = body.Message
+	}
+	// openrouter reports the upstream HTTP status as the code
+	if status, err := strconv.Atoi(apiErr.Code); err == nil {
+		apiErr.StatusCode = status
+		apiErr.Retryable = core.IsRetryableStatus(status)
+	}
+	return apiErr
+}
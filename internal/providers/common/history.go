@@ -0,0 +1,251 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/tools"
+)
+
+const (
+	roleSys   = "system"
+	roleDev   = "developer"
+	roleUser  = "user"
+	roleModel = "assistant"
+	roleTool  = "tool"
+)
+
+// ExportMessages converts a chat completions history to core messages.
+// System and developer messages are skipped since the system prompt is part
+// of the provider config; consecutive tool messages are merged into a single
+// core.RoleTool message, and those of failed tool calls get IsError back.
+func ExportMessages(history []Message) ([]core.Message, error) {
+	messages := make([]core.Message, 0, len(history))
+	toolNames := make(map[string]string)
+	for _, msg := range history {
+		parts, err := contentToParts(msg.Content)
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Role {
+		case roleSys, roleDev:
+			continue
+		case roleUser:
+			messages = append(messages, core.Message{Role: core.RoleUser, Parts: parts})
+		case roleModel:
+			for _, toolCall := range msg.ToolCalls {
+				args, err := core.NormalizeToolArguments(toolCall.Function.Arguments)
+				if err != nil {
+					return nil, fmt.Errorf("[ExportMessages] Tool call `%s`: %w", toolCall.Function.Name, err)
+				}
+				toolNames[toolCall.Id] = toolCall.Function.Name
+				parts = append(parts, core.ToolCallPart(core.ToolCall{
+					Id:        toolCall.Id,
+					Name:      toolCall.Function.Name,
+					Arguments: args,
+				}))
+			}
+			messages = append(messages, core.Message{Role: core.RoleAssistant, Parts: parts})
+		case roleTool:
+			name := msg.Name
+			if name == "" {
+				name = toolNames[msg.ToolCallId]
+			}
+			content := core.Message{Parts: parts}.Text()
+			if msg.IsError {
+				content = strings.TrimPrefix(content, toolErrorPrefix)
+			}
+			part := core.ToolResultPart(core.ToolResult{
+				ToolCallId: msg.ToolCallId,
+				Name:       name,
+				Content:    content,
+				IsError:    msg.IsError,
+			})
+			if last := len(messages) - 1; last >= 0 && messages[last].Role == core.RoleTool {
+				messages[last].Parts = append(messages[last].Parts, part)
+			} else {
+				messages = append(messages, core.Message{Role: core.RoleTool, Parts: []core.Part{part}})
+			}
+		default:
+			return nil, fmt.Errorf("[ExportMessages] Unexpected role: %s", msg.Role)
+		}
+	}
+	return messages, nil
+}
+
+// ImportMessages converts core messages to a chat completions history, without
+// the system prompt message. When stringContent is set, message content is
+// sent as a plain string instead of an array of parts, for providers that
+// don't support the latter.
+func ImportMessages(messages []core.Message, stringContent bool) ([]Message, error) {
+	history := make([]Message, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case core.RoleUser:
+			content, err := partsToContent(msg.Parts, stringContent)
+			if err != nil {
+				return nil, err
+			}
+			history = append(history, Message{Role: roleUser, Content: content})
+		case core.RoleAssistant:
+			message := Message{Role: roleModel}
+			if text := msg.Text(); text != "" {
+				message.Content = text
+			}
+			for _, toolCall := range msg.ToolCalls() {
+				args, err := core.NormalizeToolArguments(toolCall.Arguments)
+				if err != nil {
+					return nil, fmt.Errorf("[ImportMessages] Tool call `%s`: %w", toolCall.Name, err)
+				}
+				argsBytes, err := json.Marshal(string(args))
+				if err != nil {
+					return nil, err
+				}
+				message.ToolCalls = append(message.ToolCalls, tools.ToolCall{
+					Id:   toolCall.Id,
+					Type: "function",
+					Function: tools.Function{
+						Name:      toolCall.Name,
+						Arguments: argsBytes,
+					},
+				})
+			}
+			history = append(history, message)
+		case core.RoleTool:
+			for _, toolResult := range msg.ToolResults() {
+				content, err := partsToContent([]core.Part{core.TextPart(ToolResultText(toolResult))}, stringContent)
+				if err != nil {
+					return nil, err
+				}
+				history = append(history, Message{
+					Role:       roleTool,
+					Content:    content,
+					ToolCallId: toolResult.ToolCallId,
+					IsError:    toolResult.IsError,
+				})
+			}
+		default:
+			return nil, fmt.Errorf("[ImportMessages] Unexpected role: %s", msg.Role)
+		}
+	}
+	return history, nil
+}
+
+// toolErrorPrefix marks the content of the tool messages of failed tool
+// calls, for the model. ExportMessages strips it off again.
+const toolErrorPrefix = "Error: "
+
+// ToolResultText returns the content of a tool message for the result. Tool
+// messages carry text only: failures are marked with toolErrorPrefix and
+// images are replaced by a note.
+func ToolResultText(result core.ToolResult) string {
+	var sb strings.Builder
+	if result.IsError {
+		sb.WriteString(toolErrorPrefix)
+	}
+	sb.WriteString(result.Content)
+	for _, part := range result.Parts {
+		switch part.Type {
+		case core.PartTypeText:
+			sb.WriteString(part.Text)
+		case core.PartTypeImage:
+			sb.WriteString("\n[image omitted: images are not supported in tool results]")
+		}
+	}
+	return sb.String()
+}
+
+func contentToParts(content any) ([]core.Part, error) {
+	var chatParts []Part
+	switch c := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if c == "" {
+			return nil, nil
+		}
+		return []core.Part{core.TextPart(c)}, nil
+	case []Part:
+		chatParts = c
+	default: // e.g. []any after a JSON round trip
+		b, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &chatParts); err != nil {
+			return nil, fmt.Errorf("[contentToParts] Unexpected content: %s", string(b))
+		}
+	}
+
+	parts := make([]core.Part, 0, len(chatParts))
+	for _, chatPart := range chatParts {
+		switch chatPart.Type {
+		case "text":
+			parts = append(parts, core.TextPart(chatPart.Text))
+		case "image_url":
+			if chatPart.ImageUrl == nil {
+				return nil, fmt.Errorf("[contentToParts] image_url part without url")
+			}
+			image, err := imageFromUrl(chatPart.ImageUrl.Url)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, core.ImagePart(image))
+		default:
+			return nil, fmt.Errorf("[contentToParts] Unsupported part type: %s", chatPart.Type)
+		}
+	}
+	return parts, nil
+}
+
+func partsToContent(parts []core.Part, stringContent bool) (any, error) {
+	if stringContent {
+		var sb strings.Builder
+		for _, part := range parts {
+			if part.Type != core.PartTypeText {
+				return nil, fmt.Errorf("[partsToContent] Part type `%s` is not supported in string content", part.Type)
+			}
+			sb.WriteString(part.Text)
+		}
+		return sb.String(), nil
+	}
+
+	chatParts := make([]Part, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case core.PartTypeText:
+			chatParts = append(chatParts, Part{Type: "text", Text: part.Text})
+		case core.PartTypeImage:
+			if part.Image == nil {
+				return nil, fmt.Errorf("[partsToContent] image part without image")
+			}
+			chatParts = append(chatParts, Part{Type: "image_url", ImageUrl: &ImageUrl{Url: imageToUrl(*part.Image)}})
+		default:
+			return nil, fmt.Errorf("[partsToContent] Unexpected part type: %s", part.Type)
+		}
+	}
+	return chatParts, nil
+}
+
+// imageToUrl returns the image URL, or a base64 data URL for inline images.
+func imageToUrl(image core.Image) string {
+	if image.URL != "" {
+		return image.URL
+	}
+	return "data:" + image.MimeType + ";base64," + base64.StdEncoding.EncodeToString(image.Data)
+}
+
+func imageFromUrl(url string) (core.Image, error) {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !strings.HasPrefix(url, "data:") || !ok || !strings.HasSuffix(meta, ";base64") {
+		return core.Image{URL: url}, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return core.Image{}, fmt.Errorf("[imageFromUrl] Invalid base64 image data: %w", err)
+	}
+	return core.Image{MimeType: strings.TrimSuffix(meta, ";base64"), Data: decoded}, nil
+}
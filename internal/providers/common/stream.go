@@ -0,0 +1,152 @@
+package common
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/http"
+	"github.com/assagman/apc/internal/tools"
+)
+
+// chat completions streams are terminated with this sentinel data payload
+const streamDoneData = "[DONE]"
+
+type StreamToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+type StreamToolCall struct {
+	Index    int                    `json:"index"`
+	Id       string                 `json:"id,omitempty"`
+	Type     string                 `json:"type,omitempty"`
+	Function StreamToolCallFunction `json:"function"`
+}
+
+type StreamDelta struct {
+	Role      string           `json:"role,omitempty"`
+	Content   string           `json:"content,omitempty"`
+	ToolCalls []StreamToolCall `json:"tool_calls,omitempty"`
+}
+
+type StreamChoice struct {
+	Delta        StreamDelta `json:"delta"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type GroqExtension struct {
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+type StreamChunk struct {
+	Choices []StreamChoice  `json:"choices"`
+	Usage   *Usage          `json:"usage,omitempty"`
+	XGroq   *GroqExtension  `json:"x_groq,omitempty"` // groq reports usage here
+	Error   json.RawMessage `json:"error,omitempty"`
+}
+
+// ChatCompletionStream assembles the chunks of a streamed chat completion
+// (OpenAI wire format) into a single assistant message.
+type ChatCompletionStream struct {
+	Name         string
+	text         strings.Builder
+	toolCalls    []tools.ToolCall
+	toolArgs     []string
+	finishReason string
+	usage        *Usage
+}
+
+func NewChatCompletionStream(providerName string) *ChatCompletionStream {
+	return &ChatCompletionStream{Name: providerName}
+}
+
+// Read consumes the SSE body until the stream ends and reports every delta to
+// onEvent.
+func (s *ChatCompletionStream) Read(body io.Reader, onEvent core.StreamHandler) error {
+	return http.ReadSSE(body, func(ev http.SSEEvent) error {
+		if ev.Data == streamDoneData {
+			return nil
+		}
+		var chunk StreamChunk
+		if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+			return &core.DecodeError{Provider: s.Name, Body: []byte(ev.Data), Err: err}
+		}
+		if len(chunk.Error) > 0 {
+			return NewStreamAPIError(s.Name, chunk.Error)
+		}
+		if chunk.Usage != nil {
+			s.usage = chunk.Usage
+		} else if chunk.XGroq != nil && chunk.XGroq.Usage != nil {
+			s.usage = chunk.XGroq.Usage
+		}
+		for _, choice := range chunk.Choices {
+			s.handleDelta(choice.Delta, onEvent)
+			if choice.FinishReason != "" {
+				s.finishReason = choice.FinishReason
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ChatCompletionStream) handleDelta(delta StreamDelta, onEvent core.StreamHandler) {
+	if delta.Content != "" {
+		s.text.WriteString(delta.Content)
+		onEvent(core.StreamEvent{Type: core.StreamEventTextDelta, Text: delta.Content})
+	}
+	for _, tc := range delta.ToolCalls {
+		for len(s.toolCalls) <= tc.Index {
+			s.toolCalls = append(s.toolCalls, tools.ToolCall{Type: "function"})
+			s.toolArgs = append(s.toolArgs, "")
+		}
+		toolCall := &s.toolCalls[tc.Index]
+		if tc.Id != "" {
+			toolCall.Id = tc.Id
+		}
+		if tc.Type != "" {
+			toolCall.Type = tc.Type
+		}
+		toolCall.Function.Name += tc.Function.Name
+		s.toolArgs[tc.Index] += tc.Function.Arguments
+		onEvent(core.StreamEvent{
+			Type:           core.StreamEventToolCallDelta,
+			ToolCallIndex:  tc.Index,
+			ToolCallId:     toolCall.Id,
+			ToolName:       toolCall.Function.Name,
+			ArgumentsDelta: tc.Function.Arguments,
+		})
+	}
+}
+
+// Text returns the accumulated text content.
+func (s *ChatCompletionStream) Text() string { return s.text.String() }
+
+// FinishReason returns the finish reason of the last choice that reported one.
+func (s *ChatCompletionStream) FinishReason() string { return s.finishReason }
+
+// Usage returns the usage reported in the stream, if any.
+func (s *ChatCompletionStream) Usage() *Usage { return s.usage }
+
+// ToolCalls returns the assembled tool calls. Arguments are encoded as a JSON
+// string, the same way non-streamed chat completion responses carry them.
+func (s *ChatCompletionStream) ToolCalls() ([]tools.ToolCall, error) {
+	if len(s.toolCalls) == 0 {
+		return nil, nil
+	}
+	toolCalls := make([]tools.ToolCall, len(s.toolCalls))
+	for i, toolCall := range s.toolCalls {
+		args := s.toolArgs[i]
+		if strings.TrimSpace(args) == "" {
+			args = "{}"
+		}
+		argsBytes, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		toolCall.Function.Arguments = argsBytes
+		toolCalls[i] = toolCall
+	}
+	return toolCalls, nil
+}
@@ -1,19 +1,68 @@
 package common
 
-import "github.com/assagman/apc/internal/tools"
+import (
+	"fmt"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/tools"
+)
+
+type ImageUrl struct {
+	Url string `json:"url"`
+}
+
+type Part struct {
+	Type     string    `json:"type"` // text, image_url
+	Text     string    `json:"text,omitempty"`
+	ImageUrl *ImageUrl `json:"image_url,omitempty"`
+}
 
 type Message struct {
 	Role        string           `json:"role"`
-	Content     string           `json:"content"`
+	Content     any              `json:"content"` // req: string or array, resp: string or null
 	Refusal     string           `json:"refusal,omitempty"`
 	Annotations []string         `json:"anotations,omitempty"`
 	ToolCalls   []tools.ToolCall `json:"tool_calls,omitempty"`   // tool call request returned FROM AI
 	ToolCallId  string           `json:"tool_call_id,omitempty"` // tool call request returned TO AI
 	Name        string           `json:"name,omitempty"`         // tool call request returned TO AI
+	// IsError marks the tool messages of failed tool calls, whose content
+	// starts with toolErrorPrefix. It's not sent, the API has no such field.
+	IsError bool `json:"-"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+func (m *Message) GetContentAsString() (string, error) {
+	if m.Content == nil {
+		return "", fmt.Errorf("[GetContentAsString: Content = nil")
+	}
+	if str, ok := m.Content.(string); ok {
+		return str, nil
+	}
+	return "", fmt.Errorf("[GetContentAsString: string cast failed]")
+}
+
+func (m *Message) GetContentAsArray() ([]Part, error) {
+	if m.Content == nil {
+		return nil, fmt.Errorf("[GetContentAsString: Content = nil")
+	}
+	if parts, ok := m.Content.([]Part); ok {
+		return parts, nil
+	}
+	return nil, fmt.Errorf("[GetContentAsString: []Part cast failed]")
 }
 
-type ChatCompletionRequest struct {
-	Model    string        `json:"model"`
-	Messages []Message     `json:"messages"`
-	Tools    []*tools.Tool `json:"tools"`
+func (u *Usage) ToCore() core.Usage {
+	if u == nil {
+		return core.Usage{}
+	}
+	return core.Usage{InputTokens: u.PromptTokens, OutputTokens: u.CompletionTokens}
 }
@@ -0,0 +1,292 @@
+package providers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/http"
+	"github.com/assagman/apc/internal/providers"
+	"github.com/assagman/apc/internal/tools"
+)
+
+// go test ./internal/providers -run TestConformance -record
+// sends the scenarios to the real APIs, reading the API keys from the
+// environment, and overwrites the cassettes.
+var record = flag.Bool("record", false, "record cassettes against the real provider APIs")
+
+type conformanceProvider struct {
+	name    string
+	model   string
+	baseUrl string
+}
+
+var conformanceProviders = []conformanceProvider{
+	{name: "openai", model: "gpt-4o-mini"},
+	{name: "groq", model: "moonshotai/kimi-k2-instruct"},
+	{name: "cerebras", model: "qwen-3-235b-a22b-instruct-2507"},
+	{name: "openrouter", model: "openai/gpt-4o-mini"},
+	{name: "anthropic", model: "claude-sonnet-4-20250514"},
+	{name: "google", model: "gemini-2.5-flash"},
+	{name: "openai-compatible", model: "qwen3:8b", baseUrl: "http://localhost:11434/v1"},
+}
+
+type scenario struct {
+	name   string
+	prompt string
+	// cities the model is expected to request the weather for, all in one
+	// tool round; none for plain text
+	cities []string
+}
+
+var scenarios = []scenario{
+	{name: "text", prompt: "Say hello in one short sentence."},
+	{name: "single_tool", prompt: "What is the weather in Paris?", cities: []string{"Paris"}},
+	{name: "multi_tool", prompt: "What is the weather in Paris and in Rome? Call the tool for both cities at once.", cities: []string{"Paris", "Rome"}},
+}
+
+var weather = map[string]string{
+	"Paris": "18°C, sunny",
+	"Rome":  "24°C, clear sky",
+}
+
+var weatherTool = tools.Tool{
+	Type: "function",
+	Function: tools.FunctionDefinition{
+		Name:        "get_weather",
+		Description: "Returns the current weather in a city.",
+		Parameters: tools.ToolFunctionParameters{
+			Type: "object",
+			Properties: map[string]tools.Property{
+				"city": {Type: "string", Description: "name of the city"},
+			},
+			Required: []string{"city"},
+		},
+	},
+}
+
+func TestConformance(t *testing.T) {
+	for _, cp := range conformanceProviders {
+		for _, sc := range scenarios {
+			for _, stream := range []bool{false, true} {
+				name := sc.name
+				if stream {
+					name = "stream_" + name
+				}
+				t.Run(cp.name+"/"+name, func(t *testing.T) {
+					mode := http.CassetteReplay
+					if *record {
+						mode = http.CassetteRecord
+					}
+					cassette, err := http.LoadCassette(filepath.Join("testdata", "cassettes", cp.name, name+".json"), mode)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer func() {
+						if err := cassette.Save(); err != nil {
+							t.Error(err)
+						}
+					}()
+
+					provider, err := providers.New(cp.name, core.ProviderConfig{
+						Model:        cp.model,
+						SystemPrompt: "You are a concise assistant.",
+						APCTools:     core.APCTools{Tools: []tools.Tool{weatherTool}},
+						BaseURL:      cp.baseUrl,
+						Transport:    cassette,
+						Retry:        core.RetryPolicy{MaxAttempts: 1},
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+					runScenario(t, provider, sc, stream)
+				})
+			}
+		}
+	}
+}
+
+// streamDeltas collects the events of a streamed response.
+type streamDeltas struct {
+	text string
+	args map[int]string // arguments of every tool call, by index
+}
+
+func (d *streamDeltas) handle(ev core.StreamEvent) {
+	switch ev.Type {
+	case core.StreamEventTextDelta:
+		d.text += ev.Text
+	case core.StreamEventToolCallDelta:
+		d.args[ev.ToolCallIndex] += ev.ArgumentsDelta
+	}
+}
+
+// send sends req, streamed if stream is set, and checks that the streamed
+// deltas add up to the assembled response.
+func send(t *testing.T, provider core.IProvider, req core.GenericRequest, stream bool) core.GenericResponse {
+	ctx := context.Background()
+	if !stream {
+		resp, err := provider.SendRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	deltas := &streamDeltas{args: make(map[int]string)}
+	resp, err := provider.SendStreamRequest(ctx, req, deltas.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toolCalls, err := provider.GetToolCallsFromResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expectedArgs, streamedArgs []string
+	for _, toolCall := range toolCalls {
+		args, err := core.NormalizeToolArguments(toolCall.Function.Arguments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedArgs = append(expectedArgs, compactJson(t, string(args)))
+	}
+	for _, args := range deltas.args {
+		streamedArgs = append(streamedArgs, compactJson(t, args))
+	}
+	slices.Sort(expectedArgs)
+	slices.Sort(streamedArgs)
+	if !slices.Equal(expectedArgs, streamedArgs) {
+		t.Errorf("expected streamed tool call arguments %v, got %v", expectedArgs, streamedArgs)
+	}
+	if len(toolCalls) == 0 {
+		answer, err := provider.GetAnswerFromResponse(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if answer != deltas.text {
+			t.Errorf("expected streamed text %q to make up the answer %q", deltas.text, answer)
+		}
+	}
+	return resp
+}
+
+func compactJson(t *testing.T, data string) string {
+	var out bytes.Buffer
+	if err := json.Compact(&out, []byte(data)); err != nil {
+		t.Fatalf("invalid JSON %q: %v", data, err)
+	}
+	return out.String()
+}
+
+func runScenario(t *testing.T, provider core.IProvider, sc scenario, stream bool) {
+	if err := provider.AppendMessageHistory(provider.ConstructUserPromptMessage(sc.prompt)); err != nil {
+		t.Fatal(err)
+	}
+
+	toolRounds := 0
+	for {
+		req, err := provider.NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := send(t, provider, req, stream)
+		usage, err := provider.GetUsageFromResponse(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if usage.InputTokens == 0 || usage.OutputTokens == 0 {
+			t.Errorf("expected usage to be reported, got %+v", usage)
+		}
+		msg, err := provider.GetMessageFromResponse(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := provider.AppendMessageHistory(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		isToolCall, err := provider.IsToolCall(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isToolCall {
+			answer, err := provider.GetAnswerFromResponse(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if answer == "" {
+				t.Error("expected a text answer")
+			}
+			break
+		}
+
+		toolRounds += 1
+		if toolRounds > 1 {
+			t.Fatal("expected a single tool round")
+		}
+		toolCalls, err := provider.GetToolCallsFromResponse(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cities []string
+		for _, toolCall := range toolCalls {
+			valid, err := provider.IsToolCallValid(toolCall)
+			if err != nil || !valid || toolCall.Function.Name != weatherTool.Function.Name {
+				t.Fatalf("unexpected tool call: %+v", toolCall)
+			}
+			args, err := core.NormalizeToolArguments(toolCall.Function.Arguments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var weatherArgs struct {
+				City string `json:"city"`
+			}
+			if err := json.Unmarshal(args, &weatherArgs); err != nil {
+				t.Fatal(err)
+			}
+			cities = append(cities, weatherArgs.City)
+			if err := provider.AppendMessageHistory(provider.ConstructToolMessage(toolCall, core.ToolResult{Content: weather[weatherArgs.City]})); err != nil {
+				t.Fatal(err)
+			}
+		}
+		slices.Sort(cities)
+		if !slices.Equal(sc.cities, cities) {
+			t.Errorf("expected tool calls for %v, got %v", sc.cities, cities)
+		}
+	}
+	if len(sc.cities) > 0 && toolRounds == 0 {
+		t.Error("expected a tool round")
+	}
+
+	// the history must survive the conversion to provider-neutral messages
+	history, err := provider.ExportHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRoles := []core.Role{core.RoleUser, core.RoleAssistant}
+	if len(sc.cities) > 0 {
+		expectedRoles = []core.Role{core.RoleUser, core.RoleAssistant, core.RoleTool, core.RoleAssistant}
+	}
+	// providers keeping one message per tool result report consecutive tool
+	// messages, count them as a single turn
+	var roles []core.Role
+	toolResults := 0
+	for _, msg := range history {
+		toolResults += len(msg.ToolResults())
+		if len(roles) > 0 && msg.Role == core.RoleTool && roles[len(roles)-1] == core.RoleTool {
+			continue
+		}
+		roles = append(roles, msg.Role)
+	}
+	if !slices.Equal(expectedRoles, roles) {
+		t.Errorf("expected history roles %v, got %v", expectedRoles, roles)
+	}
+	if toolResults != len(sc.cities) {
+		t.Errorf("expected %d tool results, got %d", len(sc.cities), toolResults)
+	}
+}
@@ -1 +1,52 @@
 package providers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/assagman/apc/core"
+)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]core.ProviderFactory)
+)
+
+// Register makes a provider available under name. Registering a name again
+// replaces the previous factory, so built-in providers can be overridden.
+func Register(name string, factory core.ProviderFactory) {
+	if name == "" {
+		panic("[providers.Register] empty provider name")
+	}
+	if factory == nil {
+		panic(fmt.Sprintf("[providers.Register] nil factory for provider `%s`", name))
+	}
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// New creates an instance of the provider registered under name.
+func New(name string, config core.ProviderConfig) (core.IProvider, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unsupported provider: %s (available: %s)", name, strings.Join(List(), ", "))
+	}
+	return factory(config)
+}
+
+// List returns the sorted names of the registered providers.
+func List() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
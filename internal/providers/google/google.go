@@ -2,7 +2,9 @@ package google
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"slices"
@@ -10,10 +12,13 @@ import (
 	// "github.com/assagman/apc/internal/core"
 	"github.com/assagman/apc/core"
 	"github.com/assagman/apc/internal/http"
+	"github.com/assagman/apc/internal/providers"
 	"github.com/assagman/apc/internal/tools"
 )
 
-const chatCompletionRequestUrlTemplate = "https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent"
+const defaultBaseUrl = "https://generativelanguage.googleapis.com/v1beta"
+const chatCompletionRequestPathTemplate = "/models/%s:generateContent"
+const streamChatCompletionRequestPathTemplate = "/models/%s:streamGenerateContent?alt=sse"
 const (
 	roleUser  = "user"
 	roleModel = "model"
@@ -24,12 +29,15 @@ const (
 )
 
 type Provider struct {
-	Name         string
-	Endpoint     string
-	Model        string
-	SystemPrompt string
-	History      []Content
-	Tools        Tools
+	Name           string
+	Endpoint       string
+	StreamEndpoint string
+	Model          string
+	SystemPrompt   string
+	History        []Content
+	Tools          Tools
+	MaxTokens      int
+	HttpClient     *http.BaseHttpClient
 }
 
 type Content struct {
@@ -39,10 +47,22 @@ type Content struct {
 
 type Part struct {
 	Text             string            `json:"text,omitempty"`
+	InlineData       *Blob             `json:"inlineData,omitempty"`
+	FileData         *FileData         `json:"fileData,omitempty"`
 	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
 	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
 }
 
+type Blob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"` // base64
+}
+
+type FileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileUri  string `json:"fileUri"`
+}
+
 type FunctionCall struct {
 	Id        string          `json:"id"`
 	Name      string          `json:"name"`
@@ -59,10 +79,15 @@ type SystemInstruction struct {
 	Parts []Part `json:"parts"`
 }
 
+type GenerationConfig struct {
+	MaxOutputTokens int `json:"maxOutputTokens,omitempty"`
+}
+
 type Request struct {
 	SystemInstruction *SystemInstruction `json:"system_instruction,omitempty"`
 	Contents          []Content          `json:"contents"`
 	Tools             Tools              `json:"tools"`
+	GenerationConfig  *GenerationConfig  `json:"generationConfig,omitempty"`
 }
 
 type FunctionResponse struct {
@@ -76,8 +101,23 @@ type Candidate struct {
 	FinishReason string  `json:"finishReason"`
 }
 
+type UsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount   int `json:"thoughtsTokenCount,omitempty"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
 type Response struct {
-	Candidates []Candidate `json:"candidates"`
+	Candidates    []Candidate    `json:"candidates"`
+	UsageMetadata *UsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+// ErrorBody is the google.rpc.Status error returned by the API.
+type ErrorBody struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"` // e.g. RESOURCE_EXHAUSTED
 }
 
 func CheckModelName(model string) error {
@@ -88,15 +128,22 @@ func CheckModelName(model string) error {
 	return nil
 }
 
+func init() {
+	providers.Register("google", New)
+}
+
 func New(config core.ProviderConfig) (core.IProvider, error) {
 	CheckModelName(config.Model)
 	p := &Provider{
-		Name:         "google",
-		Endpoint:     fmt.Sprintf(chatCompletionRequestUrlTemplate, config.Model),
-		Model:        config.Model,
-		SystemPrompt: config.SystemPrompt,
-		History:      make([]Content, 0),
-		Tools:        Tools{FunctionDeclarations: make([]Tool, 0)},
+		Name:           "google",
+		Endpoint:       http.Endpoint(config.BaseURL, defaultBaseUrl, fmt.Sprintf(chatCompletionRequestPathTemplate, config.Model)),
+		StreamEndpoint: http.Endpoint(config.BaseURL, defaultBaseUrl, fmt.Sprintf(streamChatCompletionRequestPathTemplate, config.Model)),
+		Model:          config.Model,
+		SystemPrompt:   config.SystemPrompt,
+		History:        make([]Content, 0),
+		Tools:          Tools{FunctionDeclarations: make([]Tool, 0)},
+		MaxTokens:      config.Limits.MaxOutputTokens,
+		HttpClient:     http.New(config),
 	}
 	p.Tools = p.GetToolsAdapter(config.APCTools.Tools)
 	return p, nil
@@ -106,10 +153,8 @@ func (p *Provider) SetupChannels(ctx context.Context) {
 
 }
 
-func (p *Provider) GetApiKey() string { return os.Getenv("GEMINI_API_KEY") }
-func (p *Provider) GetEndpoint() string {
-	return fmt.Sprintf(chatCompletionRequestUrlTemplate, p.Model)
-}
+func (p *Provider) GetApiKey() string   { return os.Getenv("GEMINI_API_KEY") }
+func (p *Provider) GetEndpoint() string { return p.Endpoint }
 func (p *Provider) GetHeaders() map[string]string {
 	return map[string]string{
 		"Content-Type":   "application/json",
@@ -131,6 +176,24 @@ func (p *Provider) FinishReasonStop() string { return finishReasonStop }
 
 func (p *Provider) FinishReasonToolCall() string { return finishReasonStop }
 
+func (p *Provider) FinishReasonMaxTokens() string { return finishReasonMaxTokens }
+
+// GetUsageFromResponse counts thinking tokens as output tokens, they are
+// billed as such.
+func (p *Provider) GetUsageFromResponse(resp core.GenericResponse) (core.Usage, error) {
+	response, ok := resp.(Response)
+	if !ok {
+		return core.Usage{}, fmt.Errorf("[GetUsageFromResponse] Failed to cast core.GenericResponse -> %s.Response", p.Name)
+	}
+	if response.UsageMetadata == nil {
+		return core.Usage{}, nil
+	}
+	return core.Usage{
+		InputTokens:  response.UsageMetadata.PromptTokenCount,
+		OutputTokens: response.UsageMetadata.CandidatesTokenCount + response.UsageMetadata.ThoughtsTokenCount,
+	}, nil
+}
+
 func (p *Provider) GetAnswerFromResponse(resp core.GenericResponse) (string, error) {
 	response, ok := resp.(Response)
 	if !ok {
@@ -193,6 +256,211 @@ func (p *Provider) GetMessageHistory() any {
 	return p.History
 }
 
+func (p *Provider) SetMessageHistory(history any) error {
+	messages, ok := history.([]Content)
+	if !ok {
+		return fmt.Errorf("[SetMessageHistory] Failed to cast history -> []%s.Content", p.Name)
+	}
+	p.History = slices.Clone(messages)
+	return nil
+}
+
+// ExportHistory converts the history to core messages. functionResponse
+// parts are split from user contents into core.RoleTool messages. Function
+// calls without an id get a generated one so that their results can be
+// matched on providers that require ids.
+func (p *Provider) ExportHistory() ([]core.Message, error) {
+	messages := make([]core.Message, 0, len(p.History))
+	var pendingCalls []core.ToolCall // calls of the last model content without a result yet
+	for i, content := range p.History {
+		var parts, toolResults []core.Part
+		for j, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				args, err := core.NormalizeToolArguments(part.FunctionCall.Arguments)
+				if err != nil {
+					return nil, fmt.Errorf("[ExportHistory] Tool call `%s`: %w", part.FunctionCall.Name, err)
+				}
+				toolCall := core.ToolCall{
+					Id:        part.FunctionCall.Id,
+					Name:      part.FunctionCall.Name,
+					Arguments: args,
+				}
+				if toolCall.Id == "" {
+					toolCall.Id = fmt.Sprintf("call_%d_%d", i, j)
+				}
+				pendingCalls = append(pendingCalls, toolCall)
+				parts = append(parts, core.ToolCallPart(toolCall))
+			case part.FunctionResponse != nil:
+				toolCallId := part.FunctionResponse.Id
+				for k, pending := range pendingCalls {
+					if (toolCallId != "" && pending.Id == toolCallId) || (toolCallId == "" && pending.Name == part.FunctionResponse.Name) {
+						toolCallId = pending.Id
+						pendingCalls = slices.Delete(pendingCalls, k, k+1)
+						break
+					}
+				}
+				result, isError, err := functionResponseContent(part.FunctionResponse.Response)
+				if err != nil {
+					return nil, err
+				}
+				toolResults = append(toolResults, core.ToolResultPart(core.ToolResult{
+					ToolCallId: toolCallId,
+					Name:       part.FunctionResponse.Name,
+					Content:    result,
+					IsError:    isError,
+				}))
+			case part.InlineData != nil || part.FileData != nil:
+				image := core.Image{}
+				if part.InlineData != nil {
+					data, err := base64.StdEncoding.DecodeString(part.InlineData.Data)
+					if err != nil {
+						return nil, fmt.Errorf("[ExportHistory] Invalid base64 inline data: %w", err)
+					}
+					image = core.Image{MimeType: part.InlineData.MimeType, Data: data}
+				} else {
+					image = core.Image{MimeType: part.FileData.MimeType, URL: part.FileData.FileUri}
+				}
+				if last := len(toolResults) - 1; last >= 0 {
+					// images following a functionResponse are attached to its result
+					toolResults[last].ToolResult.Parts = append(toolResults[last].ToolResult.Parts, core.ImagePart(image))
+				} else {
+					parts = append(parts, core.ImagePart(image))
+				}
+			case part.Text != "":
+				parts = append(parts, core.TextPart(part.Text))
+			}
+		}
+		if len(toolResults) > 0 {
+			messages = append(messages, core.Message{Role: core.RoleTool, Parts: toolResults})
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		switch content.Role {
+		case roleUser:
+			messages = append(messages, core.Message{Role: core.RoleUser, Parts: parts})
+		case roleModel:
+			messages = append(messages, core.Message{Role: core.RoleAssistant, Parts: parts})
+		default:
+			return nil, fmt.Errorf("[ExportHistory] Unexpected role: %s", content.Role)
+		}
+	}
+	return messages, nil
+}
+
+// ImportHistory replaces the history with the given core messages. Tool
+// results are sent as functionResponse parts of a user content; their names
+// are resolved from the matching tool calls when missing.
+func (p *Provider) ImportHistory(messages []core.Message) error {
+	history := make([]Content, 0, len(messages))
+	toolNames := make(map[string]string)
+	for _, msg := range messages {
+		content := Content{Role: roleUser}
+		if msg.Role == core.RoleAssistant {
+			content.Role = roleModel
+		}
+		for _, part := range msg.Parts {
+			switch {
+			case part.Type == core.PartTypeText && msg.Role != core.RoleTool:
+				content.Parts = append(content.Parts, Part{Text: part.Text})
+			case part.Type == core.PartTypeImage && part.Image != nil && msg.Role == core.RoleUser:
+				if part.Image.URL != "" {
+					content.Parts = append(content.Parts, Part{FileData: &FileData{MimeType: part.Image.MimeType, FileUri: part.Image.URL}})
+				} else {
+					content.Parts = append(content.Parts, Part{InlineData: &Blob{
+						MimeType: part.Image.MimeType,
+						Data:     base64.StdEncoding.EncodeToString(part.Image.Data),
+					}})
+				}
+			case part.Type == core.PartTypeToolCall && part.ToolCall != nil && msg.Role == core.RoleAssistant:
+				args, err := core.NormalizeToolArguments(part.ToolCall.Arguments)
+				if err != nil {
+					return fmt.Errorf("[ImportHistory] Tool call `%s`: %w", part.ToolCall.Name, err)
+				}
+				toolNames[part.ToolCall.Id] = part.ToolCall.Name
+				content.Parts = append(content.Parts, Part{FunctionCall: &FunctionCall{
+					Id:        part.ToolCall.Id,
+					Name:      part.ToolCall.Name,
+					Arguments: args,
+				}})
+			case part.Type == core.PartTypeToolResult && part.ToolResult != nil && msg.Role == core.RoleTool:
+				name := part.ToolResult.Name
+				if name == "" {
+					name = toolNames[part.ToolResult.ToolCallId]
+				}
+				content.Parts = append(content.Parts, functionResponseParts(part.ToolResult.ToolCallId, name, *part.ToolResult)...)
+			default:
+				return fmt.Errorf("[ImportHistory] Unexpected `%s` part in `%s` message", part.Type, msg.Role)
+			}
+		}
+		history = append(history, content)
+	}
+	p.History = history
+	return nil
+}
+
+// functionResponseParts returns the functionResponse part of a result,
+// followed by its images. Failures are sent as `error`, anything else as
+// `result`; JSON objects are sent as such rather than as strings.
+func functionResponseParts(id string, name string, result core.ToolResult) []Part {
+	key := "result"
+	if result.IsError {
+		key = "error"
+	}
+	text := result.Text()
+	var value any = text
+	if len(text) > 0 && text[0] == '{' {
+		var object map[string]any
+		if err := json.Unmarshal([]byte(text), &object); err == nil {
+			value = object
+		}
+	}
+	parts := []Part{{FunctionResponse: &FunctionResponse{
+		Id:       id,
+		Name:     name,
+		Response: map[string]any{key: value},
+	}}}
+	for _, image := range result.Images() {
+		if image.URL != "" {
+			parts = append(parts, Part{FileData: &FileData{MimeType: image.MimeType, FileUri: image.URL}})
+		} else {
+			parts = append(parts, Part{InlineData: &Blob{MimeType: image.MimeType, Data: base64.StdEncoding.EncodeToString(image.Data)}})
+		}
+	}
+	return parts
+}
+
+// functionResponseContent returns the `result` or `error` set by
+// ConstructToolMessage and whether it's an error, or the JSON encoded response
+// for anything else.
+func functionResponseContent(response map[string]any) (string, bool, error) {
+	if len(response) == 1 {
+		for key, value := range response {
+			if key != "result" && key != "error" {
+				break
+			}
+			if text, ok := value.(string); ok {
+				return text, key == "error", nil
+			}
+			b, err := json.Marshal(value)
+			if err != nil {
+				return "", false, err
+			}
+			return string(b), key == "error", nil
+		}
+	}
+	b, err := json.Marshal(response)
+	if err != nil {
+		return "", false, err
+	}
+	return string(b), false, nil
+}
+
+func (p *Provider) ResetMessageHistory() {
+	p.History = make([]Content, 0)
+}
+
 func (p *Provider) IsSenderRole(msg core.GenericMessage) (bool, error) {
 	message, ok := msg.(Content)
 	if !ok {
@@ -235,44 +503,62 @@ func (p *Provider) IsToolCallValid(toolCall tools.ToolCall) (bool, error) {
 }
 
 func (p *Provider) NewRequest() (core.GenericRequest, error) {
-	return Request{
+	req := Request{
 		SystemInstruction: p.GetSystemPrompt(),
 		Tools:             p.Tools,
 		Contents:          p.History,
-	}, nil
+	}
+	if p.MaxTokens > 0 {
+		req.GenerationConfig = &GenerationConfig{MaxOutputTokens: p.MaxTokens}
+	}
+	return req, nil
 }
 
 func (p *Provider) GetToolsAdapter(genericTools []tools.Tool) Tools {
-	tools := Tools{}
-	tools.FunctionDeclarations = make([]Tool, 0)
+	adapter := Tools{}
+	adapter.FunctionDeclarations = make([]Tool, 0)
 	for _, fsTool := range genericTools {
-		tools.FunctionDeclarations = append(tools.FunctionDeclarations, Tool{
+		parameters := fsTool.Function.Parameters
+		properties := make(map[string]tools.Property, len(parameters.Properties))
+		for name, property := range parameters.Properties {
+			properties[name] = toSchema(property)
+		}
+		parameters.Properties = properties
+		adapter.FunctionDeclarations = append(adapter.FunctionDeclarations, Tool{
 			Name:        fsTool.Function.Name,
 			Description: fsTool.Function.Description,
-			Parameters:  fsTool.Function.Parameters,
+			Parameters:  parameters,
 		})
 	}
-	return tools
+	return adapter
+}
+
+// toSchema drops what the OpenAPI subset of the API doesn't support: maps are
+// sent as objects without a schema for their values.
+func toSchema(property tools.Property) tools.Property {
+	property.AdditionalProperties = nil
+	if property.Items != nil {
+		items := toSchema(*property.Items)
+		property.Items = &items
+	}
+	if property.Properties != nil {
+		properties := make(map[string]tools.Property, len(property.Properties))
+		for name, nested := range property.Properties {
+			properties[name] = toSchema(nested)
+		}
+		property.Properties = properties
+	}
+	return property
 }
 
 func (p *Provider) ConstructSystemPromptMessage() Content {
 	return Content{} // TODO: No sys msg in anthropic, it's at top level
 }
 
-func (p *Provider) ConstructToolMessage(tooCall tools.ToolCall, toolResult string) core.GenericMessage {
+func (p *Provider) ConstructToolMessage(tooCall tools.ToolCall, toolResult core.ToolResult) core.GenericMessage {
 	return Content{
-		Role: roleUser,
-		Parts: []Part{
-			{
-				FunctionResponse: &FunctionResponse{
-					Id:   tooCall.Id,
-					Name: tooCall.Function.Name,
-					Response: map[string]any{
-						"result": toolResult,
-					},
-				},
-			},
-		},
+		Role:  roleUser,
+		Parts: functionResponseParts(tooCall.Id, tooCall.Function.Name, toolResult),
 	}
 
 }
@@ -294,15 +580,15 @@ func (p *Provider) SendRequest(ctx context.Context, req core.GenericRequest) (co
 		return nil, err
 	}
 
-	c := http.New()
+	c := p.HttpClient
 	respBytes, err := c.Post(ctx, p.Endpoint, p.GetHeaders(), reqBytes)
 	if err != nil {
-		return nil, err
+		return nil, p.wrapAPIError(err)
 	}
 
 	var resp Response
 	if err := json.Unmarshal(respBytes, &resp); err != nil {
-		return nil, err
+		return nil, &core.DecodeError{Provider: p.Name, Body: respBytes, Err: err}
 	}
 
 	return resp, nil
@@ -321,3 +607,97 @@ func (p *Provider) GetSystemPrompt() *SystemInstruction {
 		},
 	}
 }
+
+func (p *Provider) SendStreamRequest(ctx context.Context, req core.GenericRequest, onEvent core.StreamHandler) (core.GenericResponse, error) {
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c := p.HttpClient
+	body, err := c.PostStream(ctx, p.StreamEndpoint, p.GetHeaders(), reqBytes)
+	if err != nil {
+		return nil, p.wrapAPIError(err)
+	}
+	defer body.Close()
+
+	// every chunk is a complete GenerateContentResponse carrying only the new
+	// parts; text parts are merged, function calls always arrive whole
+	candidate := Candidate{Content: Content{Role: roleModel}}
+	var usage *UsageMetadata // cumulative, the last chunk has the totals
+	toolCallIndex := 0
+	err = http.ReadSSE(body, func(ev http.SSEEvent) error {
+		var chunk Response
+		if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+			return &core.DecodeError{Provider: p.Name, Body: []byte(ev.Data), Err: err}
+		}
+		if chunk.UsageMetadata != nil {
+			usage = chunk.UsageMetadata
+		}
+		if len(chunk.Candidates) == 0 {
+			return nil
+		}
+		chunkCandidate := chunk.Candidates[0]
+		for _, part := range chunkCandidate.Content.Parts {
+			if part.FunctionCall != nil {
+				candidate.Content.Parts = append(candidate.Content.Parts, part)
+				onEvent(core.StreamEvent{
+					Type:           core.StreamEventToolCallDelta,
+					ToolCallIndex:  toolCallIndex,
+					ToolCallId:     part.FunctionCall.Id,
+					ToolName:       part.FunctionCall.Name,
+					ArgumentsDelta: string(part.FunctionCall.Arguments),
+				})
+				toolCallIndex += 1
+				continue
+			}
+			if part.Text == "" {
+				continue
+			}
+			last := len(candidate.Content.Parts) - 1
+			if last >= 0 && candidate.Content.Parts[last].FunctionCall == nil {
+				candidate.Content.Parts[last].Text += part.Text
+			} else {
+				candidate.Content.Parts = append(candidate.Content.Parts, part)
+			}
+			onEvent(core.StreamEvent{Type: core.StreamEventTextDelta, Text: part.Text})
+		}
+		if chunkCandidate.FinishReason != "" {
+			candidate.FinishReason = chunkCandidate.FinishReason
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return Response{Candidates: []Candidate{candidate}, UsageMetadata: usage}, nil
+}
+
+// wrapAPIError fills the provider name and the error details of the response
+// body into err if it is a *core.APIError.
+func (p *Provider) wrapAPIError(err error) error {
+	var apiErr *core.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	apiErr.Provider = p.Name
+	// the streaming endpoint wraps the error in an array
+	var body struct {
+		Error *ErrorBody `json:"error"`
+	}
+	var bodies []struct {
+		Error *ErrorBody `json:"error"`
+	}
+	if json.Unmarshal(apiErr.Body, &body) != nil && json.Unmarshal(apiErr.Body, &bodies) == nil && len(bodies) > 0 {
+		body = bodies[0]
+	}
+	if body.Error == nil {
+		return apiErr
+	}
+	apiErr.Code = body.Error.Status
+	if body.Error.Message != "" {
+		apiErr.Message = body.Error.Message
+	}
+	return apiErr
+}
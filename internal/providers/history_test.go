@@ -0,0 +1,154 @@
+package providers_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/providers/anthropic"
+	"github.com/assagman/apc/internal/providers/cerebras"
+	"github.com/assagman/apc/internal/providers/common"
+	"github.com/assagman/apc/internal/providers/google"
+	"github.com/assagman/apc/internal/providers/groq"
+	"github.com/assagman/apc/internal/providers/openai"
+	"github.com/assagman/apc/internal/providers/openaicompat"
+	"github.com/assagman/apc/internal/providers/openrouter"
+)
+
+func conversation(withImage bool) []core.Message {
+	userParts := []core.Part{core.TextPart("what's in the repo?")}
+	if withImage {
+		userParts = append(userParts, core.ImagePart(core.Image{MimeType: "image/png", Data: []byte{0x89, 'P', 'N', 'G'}}))
+	}
+	return []core.Message{
+		{Role: core.RoleUser, Parts: userParts},
+		{Role: core.RoleAssistant, Parts: []core.Part{
+			core.TextPart("Let me look."),
+			core.ToolCallPart(core.ToolCall{Id: "call_1", Name: "ToolTree", Arguments: json.RawMessage(`{"dir":".","maxDepth":1}`)}),
+			core.ToolCallPart(core.ToolCall{Id: "call_2", Name: "ToolGetCurrentWorkingDirectory", Arguments: json.RawMessage(`{}`)}),
+		}},
+		{Role: core.RoleTool, Parts: []core.Part{
+			core.ToolResultPart(core.ToolResult{ToolCallId: "call_1", Name: "ToolTree", Content: "./\n  go.mod\n"}),
+			core.ToolResultPart(core.ToolResult{ToolCallId: "call_2", Name: "ToolGetCurrentWorkingDirectory", Content: "/src"}),
+		}},
+		{Role: core.RoleAssistant, Parts: []core.Part{core.TextPart("A Go module.")}},
+	}
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	constructors := map[string]func(core.ProviderConfig) (core.IProvider, error){
+		"openai":            openai.New,
+		"groq":              groq.New,
+		"cerebras":          cerebras.New,
+		"openrouter":        openrouter.New,
+		"anthropic":         anthropic.New,
+		"google":            google.New,
+		"openai-compatible": openaicompat.New,
+	}
+	for name, newProvider := range constructors {
+		t.Run(name, func(t *testing.T) {
+			provider, err := newProvider(core.ProviderConfig{Model: "model", SystemPrompt: "be brief", BaseURL: "http://localhost:11434/v1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// cerebras only supports string content, openai-compatible uses it for portability
+			expected := conversation(name != "cerebras" && name != "openai-compatible")
+			if err := provider.ImportHistory(expected); err != nil {
+				t.Fatalf("ImportHistory: %v", err)
+			}
+			got, err := provider.ExportHistory()
+			if err != nil {
+				t.Fatalf("ExportHistory: %v", err)
+			}
+			if !reflect.DeepEqual(expected, got) {
+				expectedJson, _ := json.MarshalIndent(expected, "", "  ")
+				gotJson, _ := json.MarshalIndent(got, "", "  ")
+				t.Errorf("round trip mismatch\nexpected: %s\ngot: %s", expectedJson, gotJson)
+			}
+		})
+	}
+}
+
+func TestHistoryAcrossProviders(t *testing.T) {
+	groqProvider, _ := groq.New(core.ProviderConfig{Model: "model"})
+	if err := groqProvider.ImportHistory(conversation(false)); err != nil {
+		t.Fatal(err)
+	}
+	history, err := groqProvider.ExportHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anthropicProvider, _ := anthropic.New(core.ProviderConfig{Model: "model"})
+	if err := anthropicProvider.ImportHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	got, err := anthropicProvider.ExportHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conversation(false), got) {
+		t.Errorf("history changed when moving from groq to anthropic: %+v", got)
+	}
+}
+
+func TestHistoryRoundTrip_ToolResults(t *testing.T) {
+	expected := conversation(false)
+	expected[1].Parts = append(expected[1].Parts, core.ToolCallPart(core.ToolCall{Id: "call_3", Name: "ToolGrepText", Arguments: json.RawMessage(`{"text":"Error:"}`)}))
+	expected[2].Parts = []core.Part{
+		core.ToolResultPart(core.ToolResult{ToolCallId: "call_1", Name: "ToolTree", Content: "permission denied", IsError: true}),
+		core.ToolResultPart(core.ToolResult{ToolCallId: "call_2", Name: "ToolGetCurrentWorkingDirectory", Content: "/src", Parts: []core.Part{
+			core.ImagePart(core.Image{MimeType: "image/png", Data: []byte{0x89, 'P', 'N', 'G'}}),
+		}}),
+		// a successful result that reads like a failure
+		core.ToolResultPart(core.ToolResult{ToolCallId: "call_3", Name: "ToolGrepText", Content: "Error: disk full"}),
+	}
+	constructors := map[string]func(core.ProviderConfig) (core.IProvider, error){
+		"anthropic": anthropic.New,
+		"google":    google.New,
+	}
+	for name, newProvider := range constructors {
+		t.Run(name, func(t *testing.T) {
+			provider, err := newProvider(core.ProviderConfig{Model: "model"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := provider.ImportHistory(expected); err != nil {
+				t.Fatalf("ImportHistory: %v", err)
+			}
+			got, err := provider.ExportHistory()
+			if err != nil {
+				t.Fatalf("ExportHistory: %v", err)
+			}
+			if !reflect.DeepEqual(expected, got) {
+				expectedJson, _ := json.MarshalIndent(expected, "", "  ")
+				gotJson, _ := json.MarshalIndent(got, "", "  ")
+				t.Errorf("round trip mismatch\nexpected: %s\ngot: %s", expectedJson, gotJson)
+			}
+		})
+	}
+
+	// chat completions tool messages carry text only, failures keep their flag
+	provider, _ := openai.New(core.ProviderConfig{Model: "model"})
+	history := expected
+	for range 2 { // a resumed session is exported again
+		if err := provider.ImportHistory(history); err != nil {
+			t.Fatal(err)
+		}
+		got, err := provider.ExportHistory()
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := got[2].ToolResults()
+		if !reflect.DeepEqual(results[0], expected[2].ToolResults()[0]) || results[1].Content != "/src\n[image omitted: images are not supported in tool results]" ||
+			!reflect.DeepEqual(results[2], expected[2].ToolResults()[2]) {
+			t.Errorf("unexpected tool results %+v", results)
+		}
+		history = got
+	}
+	toolMessage := provider.GetMessageHistory().([]common.Message)[3]
+	if parts, _ := toolMessage.GetContentAsArray(); len(parts) != 1 || parts[0].Text != "Error: permission denied" {
+		t.Errorf("unexpected tool message content %+v", toolMessage.Content)
+	}
+}
@@ -0,0 +1,59 @@
+// Package openaicompat implements the chat completions protocol for any
+// server exposing an OpenAI compatible API, e.g. Ollama, vLLM, LM Studio or
+// llama.cpp. The endpoint is given by ProviderConfig.BaseURL.
+package openaicompat
+
+import (
+	"fmt"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/providers"
+	"github.com/assagman/apc/internal/providers/common"
+	"github.com/assagman/apc/internal/tools"
+)
+
+// chatConfig only uses the widely supported request fields; e.g.
+// `max_tokens` instead of `max_completion_tokens`, and string content. The
+// API key is optional, local servers usually don't need one.
+var chatConfig = common.ChatCompletionConfig{
+	Name:            "openai-compatible",
+	StringContent:   true,
+	LegacyMaxTokens: true,
+}
+
+type Provider struct {
+	*common.ChatCompletionProvider
+}
+
+func init() {
+	providers.Register("openai-compatible", New)
+}
+
+func New(config core.ProviderConfig) (core.IProvider, error) {
+	if config.BaseURL == "" {
+		return nil, fmt.Errorf("[openaicompat.New] BaseURL is required, e.g. http://localhost:11434/v1")
+	}
+	if config.Model == "" {
+		return nil, fmt.Errorf("[openaicompat.New] Model is required")
+	}
+	chat := chatConfig
+	chat.APIKeyEnv = config.APIKeyEnv
+	return &Provider{common.NewChatCompletionProvider(chat, config)}, nil
+}
+
+// IsToolCall relies on the tool calls of the message rather than on the
+// finish reason, since some servers report `stop` for tool calls too.
+func (p *Provider) IsToolCall(genericResponse core.GenericResponse) (bool, error) {
+	toolCalls, err := p.GetToolCallsFromResponse(genericResponse)
+	if err != nil {
+		return false, err
+	}
+	return len(toolCalls) > 0, nil
+}
+
+// ConstructToolMessage names the tool too, which some servers require.
+func (p *Provider) ConstructToolMessage(toolCall tools.ToolCall, toolResult core.ToolResult) core.GenericMessage {
+	message := p.ChatCompletionProvider.ConstructToolMessage(toolCall, toolResult).(common.Message)
+	message.Name = toolCall.Function.Name
+	return message
+}
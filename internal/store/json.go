@@ -0,0 +1,102 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/assagman/apc/core"
+)
+
+const jsonExt = ".json"
+
+// JSONFileStore keeps every session in its own JSON document `<id>.json`
+// inside Dir. Each Save rewrites the whole document atomically.
+type JSONFileStore struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+type jsonRecord struct {
+	Id        string         `json:"id"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Messages  []core.Message `json:"messages"`
+}
+
+// NewJSONFileStore creates dir if needed and returns a store backed by it.
+func NewJSONFileStore(dir string) (*JSONFileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("[NewJSONFileStore] failed to create store dir: %w", err)
+	}
+	return &JSONFileStore{Dir: dir}, nil
+}
+
+func (s *JSONFileStore) path(sessionId string) string {
+	return filepath.Join(s.Dir, sessionId+jsonExt)
+}
+
+func (s *JSONFileStore) Save(sessionId string, messages []core.Message) error {
+	if err := checkSessionId(sessionId); err != nil {
+		return err
+	}
+	// not indented: MarshalIndent would also reformat raw tool arguments
+	data, err := json.Marshal(jsonRecord{
+		Id:        sessionId,
+		UpdatedAt: time.Now().UTC(),
+		Messages:  messages,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(s.path(sessionId), data)
+}
+
+func (s *JSONFileStore) Load(sessionId string) ([]core.Message, error) {
+	if err := checkSessionId(sessionId); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path(sessionId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", core.ErrSessionNotFound, sessionId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record jsonRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("[JSONFileStore] failed to decode session `%s`: %w", sessionId, err)
+	}
+	return record.Messages, nil
+}
+
+func (s *JSONFileStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return listIds(s.Dir, jsonExt)
+}
+
+func (s *JSONFileStore) Delete(sessionId string) error {
+	if err := checkSessionId(sessionId); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(sessionId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", core.ErrSessionNotFound, sessionId)
+	}
+	return err
+}
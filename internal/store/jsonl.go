@@ -0,0 +1,144 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/logger"
+)
+
+const jsonlExt = ".jsonl"
+
+// JSONLStore keeps every session in an append-only JSON Lines file
+// `<id>.jsonl` inside Dir, one message per line. Save only appends the
+// messages that are not stored yet; the file is rewritten when the stored
+// history is no longer a prefix of the saved one (e.g. after Session.Reset).
+type JSONLStore struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewJSONLStore creates dir if needed and returns a store backed by it.
+func NewJSONLStore(dir string) (*JSONLStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("[NewJSONLStore] failed to create store dir: %w", err)
+	}
+	return &JSONLStore{Dir: dir}, nil
+}
+
+func (s *JSONLStore) path(sessionId string) string {
+	return filepath.Join(s.Dir, sessionId+jsonlExt)
+}
+
+// readLines returns the complete lines of the session file. A trailing line
+// without newline is the remainder of an interrupted append; it is dropped
+// and reported via partial.
+func (s *JSONLStore) readLines(sessionId string) (lines [][]byte, partial bool, err error) {
+	data, err := os.ReadFile(s.path(sessionId))
+	if err != nil {
+		return nil, false, err
+	}
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			if len(line) > 0 {
+				logger.Warning("[JSONLStore] Dropping incomplete line at the end of session `%s`", sessionId)
+				partial = true
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, partial, nil
+}
+
+func (s *JSONLStore) Save(sessionId string, messages []core.Message) error {
+	if err := checkSessionId(sessionId); err != nil {
+		return err
+	}
+	lines := make([][]byte, len(messages))
+	for i, msg := range messages {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		lines[i] = append(line, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, partial, err := s.readLines(sessionId)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	isPrefix := len(stored) <= len(lines)
+	for i := 0; isPrefix && i < len(stored); i++ {
+		isPrefix = bytes.Equal(stored[i], lines[i])
+	}
+	if !isPrefix || partial || len(stored) == 0 {
+		return writeFileAtomic(s.path(sessionId), bytes.Join(lines, nil))
+	}
+
+	f, err := os.OpenFile(s.path(sessionId), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bytes.Join(lines[len(stored):], nil)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *JSONLStore) Load(sessionId string) ([]core.Message, error) {
+	if err := checkSessionId(sessionId); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines, _, err := s.readLines(sessionId)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", core.ErrSessionNotFound, sessionId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]core.Message, 0, len(lines))
+	for i, line := range lines {
+		var msg core.Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("[JSONLStore] failed to decode line %d of session `%s`: %w", i+1, sessionId, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (s *JSONLStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return listIds(s.Dir, jsonlExt)
+}
+
+func (s *JSONLStore) Delete(sessionId string) error {
+	if err := checkSessionId(sessionId); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(sessionId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", core.ErrSessionNotFound, sessionId)
+	}
+	return err
+}
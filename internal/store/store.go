@@ -0,0 +1,58 @@
+// Package store provides file based core.HistoryStore implementations.
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// checkSessionId rejects ids that can't be used as a file name inside the
+// store directory.
+func checkSessionId(sessionId string) error {
+	if sessionId == "" {
+		return fmt.Errorf("session id must not be empty")
+	}
+	if sessionId != filepath.Base(sessionId) || sessionId == "." || sessionId == ".." || strings.ContainsAny(sessionId, `/\`) {
+		return fmt.Errorf("invalid session id `%s`", sessionId)
+	}
+	return nil
+}
+
+// listIds returns the ids of the files in dir with the given extension.
+func listIds(dir string, ext string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ext {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(entry.Name(), ext))
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// writeFileAtomic replaces path with data so that readers never observe a
+// partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,134 @@
+package store_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/store"
+)
+
+func history() []core.Message {
+	return []core.Message{
+		{Role: core.RoleUser, Parts: []core.Part{core.TextPart("read go.mod")}},
+		{Role: core.RoleAssistant, Parts: []core.Part{
+			core.ToolCallPart(core.ToolCall{Id: "call_1", Name: "ToolReadFile", Arguments: json.RawMessage(`{"filePath":"go.mod"}`)}),
+		}},
+		{Role: core.RoleTool, Parts: []core.Part{
+			core.ToolResultPart(core.ToolResult{ToolCallId: "call_1", Name: "ToolReadFile", Content: "module x"}),
+		}},
+		{Role: core.RoleAssistant, Parts: []core.Part{core.TextPart("It declares module x.")}},
+	}
+}
+
+func testStore(t *testing.T, s core.HistoryStore) {
+	if _, err := s.Load("missing"); !errors.Is(err, core.ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+	if err := s.Save("../escape", history()); err == nil {
+		t.Error("expected error for invalid session id")
+	}
+
+	if err := s.Save("b", history()[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("b", history()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("a", history()[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Load("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history(), got) {
+		t.Errorf("loaded history differs from saved one: %+v", got)
+	}
+
+	// shorter history, e.g. after Session.Reset
+	if err := s.Save("b", history()[:1]); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Load("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history()[:1], got) {
+		t.Errorf("expected history to be replaced, got %+v", got)
+	}
+
+	ids, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"a", "b"}, ids) {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("a"); !errors.Is(err, core.ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestJSONFileStore(t *testing.T) {
+	s, err := store.NewJSONFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+}
+
+func TestJSONLStore(t *testing.T) {
+	s, err := store.NewJSONLStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+}
+
+func TestJSONLStore_IncompleteLine(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewJSONLStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("s", history()[:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash in the middle of an append
+	f, err := os.OpenFile(filepath.Join(dir, "s.jsonl"), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"role":"tool","par`)
+	f.Close()
+
+	got, err := s.Load("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history()[:2], got) {
+		t.Errorf("expected complete lines only, got %+v", got)
+	}
+
+	if err := s.Save("s", history()); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Load("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history(), got) {
+		t.Errorf("expected full history after save, got %+v", got)
+	}
+}
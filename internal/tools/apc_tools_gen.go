@@ -0,0 +1,135 @@
+// Code generated by apc-toolgen; DO NOT EDIT.
+
+package tools
+
+func init() {
+	RegisterMetadata("github.com/assagman/apc/internal/tools", Metadata{
+		Funcs: map[string]FuncDocs{
+			"FS.ToolGetCurrentWorkingDirectory": {
+				Description: "ToolGetCurrentWorkingDirectory returns the current working directory(or so called project directory).\nIt's typically use for when it's asked to do operations regarding local filesystem, file operations,\nstatistics, etc.",
+			},
+			"FS.ToolGrepText": {
+				Description: "ToolGrepText returns ripgrep output for given text in the given dir\n\ntext: pattern to search\nincludeHiddenFiles: bool flag to determine if hidden files are included or not. rg option `-.` is used when enabled\ncaseSensitive: bool flag to determine if search is performed case sensitive or not. rg option `-s` is used when enabled, `-i` otherwise\ndir: relative directory path to CWD, to perform ripgrep in `CWD/dir`.\nmaxCount: optional, maximum number of matching lines per file. rg option `-m`\ncontextLines: optional, number of lines shown before and after each match. rg option `-C`",
+				Params: []ParamDoc{
+					{Name: "text", Description: "pattern to search"},
+					{Name: "includeHiddenFiles", Description: "bool flag to determine if hidden files are included or not. rg option `-.` is used when enabled"},
+					{Name: "caseSensitive", Description: "bool flag to determine if search is performed case sensitive or not. rg option `-s` is used when enabled, `-i` otherwise"},
+					{Name: "dir", Description: "relative directory path to CWD, to perform ripgrep in `CWD/dir`."},
+					{Name: "maxCount", Description: "optional, maximum number of matching lines per file. rg option `-m`"},
+					{Name: "contextLines", Description: "optional, number of lines shown before and after each match. rg option `-C`"},
+				},
+			},
+			"FS.ToolReadFile": {
+				Description: "ToolReadFile returns the contents of the file at the given path, or the\nrequested range of it. The path is treated as relative to the current\nworking directory. Long content is cut, ending with a note on how to read\nthe rest. Binary files are summarized instead.\n\nfilePath: relative path to the CWD\nstartLine: optional, first line to return, starting at 1\nendLine: optional, last line to return, inclusive\noffset: optional, byte offset to start at instead of a line, for files with very long lines\nlineNumbers: optional, prefix every line with its line number",
+				Params: []ParamDoc{
+					{Name: "filePath", Description: "relative path to the CWD"},
+					{Name: "startLine", Description: "optional, first line to return, starting at 1"},
+					{Name: "endLine", Description: "optional, last line to return, inclusive"},
+					{Name: "offset", Description: "optional, byte offset to start at instead of a line, for files with very long lines"},
+					{Name: "lineNumbers", Description: "optional, prefix every line with its line number"},
+				},
+			},
+			"FS.ToolTree": {
+				Description: "ToolTree returns an ASCII tree representation of the directory tree\nrooted at dir (relative to CWD).\n\ndir: relative path to the CWD.\nmaxDepth: positive integer value represents how many level of nested directories included in tree",
+				Params: []ParamDoc{
+					{Name: "dir", Description: "relative path to the CWD."},
+					{Name: "maxDepth", Description: "positive integer value represents how many level of nested directories included in tree"},
+				},
+			},
+			"FSWrite.ToolApplyPatch": {
+				Description: "ToolApplyPatch applies a unified diff, as produced by `diff -u` or `git\ndiff`, to one or more files. Use /dev/null as the old path to create a file\nand as the new path to delete one. Either every file is changed or none.\nReturns the diff of the change.\n\npatch: unified diff with `--- a/path` and `+++ b/path` headers and `@@` hunks\ndryRun: when true, nothing is written and the diff of the change is returned",
+				Params: []ParamDoc{
+					{Name: "patch", Description: "unified diff with `--- a/path` and `+++ b/path` headers and `@@` hunks"},
+					{Name: "dryRun", Description: "when true, nothing is written and the diff of the change is returned"},
+				},
+			},
+			"FSWrite.ToolCreateDirectory": {
+				Description: "ToolCreateDirectory creates the directory at the given path, with its\nmissing parents.\n\ndir: relative path to the CWD\ndryRun: when true, nothing is created and the directories to create are returned",
+				Params: []ParamDoc{
+					{Name: "dir", Description: "relative path to the CWD"},
+					{Name: "dryRun", Description: "when true, nothing is created and the directories to create are returned"},
+				},
+			},
+			"FSWrite.ToolDeletePath": {
+				Description: "ToolDeletePath deletes the file or empty directory at the given path.\nReturns the diff of the change.\n\nfilePath: relative path to the CWD\ndryRun: when true, nothing is deleted and the diff of the change is returned",
+				Params: []ParamDoc{
+					{Name: "filePath", Description: "relative path to the CWD"},
+					{Name: "dryRun", Description: "when true, nothing is deleted and the diff of the change is returned"},
+				},
+			},
+			"FSWrite.ToolEditFile": {
+				Description: "ToolEditFile replaces oldText with newText in the file at the given path.\noldText must match the file exactly, including whitespace, and be unique\nunless replaceAll is set. Returns the diff of the change.\n\nfilePath: relative path to the CWD\noldText: exact text to replace, with enough surrounding lines to be unique\nnewText: replacement text\nreplaceAll: replace every occurrence of oldText instead of a unique one\ndryRun: when true, nothing is written and the diff of the change is returned",
+				Params: []ParamDoc{
+					{Name: "filePath", Description: "relative path to the CWD"},
+					{Name: "oldText", Description: "exact text to replace, with enough surrounding lines to be unique"},
+					{Name: "newText", Description: "replacement text"},
+					{Name: "replaceAll", Description: "replace every occurrence of oldText instead of a unique one"},
+					{Name: "dryRun", Description: "when true, nothing is written and the diff of the change is returned"},
+				},
+			},
+			"FSWrite.ToolMovePath": {
+				Description: "ToolMovePath moves or renames the file or directory at source to\ndestination, which must not exist. Missing parent directories of\ndestination are created.\n\nsource: relative path to the CWD\ndestination: relative path to the CWD\ndryRun: when true, nothing is moved",
+				Params: []ParamDoc{
+					{Name: "source", Description: "relative path to the CWD"},
+					{Name: "destination", Description: "relative path to the CWD"},
+					{Name: "dryRun", Description: "when true, nothing is moved"},
+				},
+			},
+			"FSWrite.ToolUndoLastChange": {
+				Description: "ToolUndoLastChange reverts the most recent change made by the file writing\ntools, e.g. after a wrong edit.",
+			},
+			"FSWrite.ToolWriteFile": {
+				Description: "ToolWriteFile creates the file at the given path, or overwrites it, with the\ngiven content. Missing parent directories are created. Returns the diff of\nthe change.\n\nfilePath: relative path to the CWD\ncontent: the entire new content of the file\ndryRun: when true, nothing is written and the diff of the change is returned",
+				Params: []ParamDoc{
+					{Name: "filePath", Description: "relative path to the CWD"},
+					{Name: "content", Description: "the entire new content of the file"},
+					{Name: "dryRun", Description: "when true, nothing is written and the diff of the change is returned"},
+				},
+			},
+			"Shell.ToolRunCommand": {
+				Description: "ToolRunCommand runs a command in the current working directory and returns\nits combined stdout and stderr, followed by its exit code. The command is\nrun directly, not through a shell: pipes, redirections, globs and\nvariables are not supported. Only some commands are allowed, and paths in\nthe arguments must be inside the current working directory; the error says\nso for the others.\n\ncommand: name of the binary to run, e.g. `go` or `git`\nargs: arguments of the command, e.g. [\"test\", \"./...\"]\ndir: optional, relative directory path to CWD to run the command in",
+				Params: []ParamDoc{
+					{Name: "command", Description: "name of the binary to run, e.g. `go` or `git`"},
+					{Name: "args", Description: "arguments of the command, e.g. [\"test\", \"./...\"]"},
+					{Name: "dir", Description: "optional, relative directory path to CWD to run the command in"},
+				},
+			},
+		},
+		FieldDocs: map[string]string{
+			"FuncDocs.Params":             "Params are in the order of the function parameters, without the\ncontext.Context.",
+			"FunctionInfo.description":    "doc comment above function/method",
+			"FunctionInfo.firstParam":     "index of the first user parameter in the function type",
+			"FunctionInfo.fn":             "the function/method itself",
+			"FunctionInfo.paramInfos":     "per-parameter metadata",
+			"FunctionInfo.paramNames":     "names of *user* parameters (no receiver)",
+			"FunctionInfo.receiver":       "receiver instance (for methods only)",
+			"FunctionInfo.takesCtx":       "whether the first parameter after the receiver is a context.Context",
+			"JournalEntry.Diff":           "unified diff of the change, empty for directories",
+			"JournalEntry.Summary":        "e.g. `edit main.go`",
+			"Metadata.FieldDocs":          "FieldDocs holds the doc comments of struct fields by `Type.Field`. The\nfield docs of a package with metadata are never read from source.",
+			"Metadata.Funcs":              "Funcs holds the docs of functions by name, and of methods by\n`Type.Method`.",
+			"ParamInfo.Optional":          "pointers may be omitted by the model",
+			"ParamInfo.Schema":            "schema derived from the Go type, with the description",
+			"Sandbox.Deny":                "Deny holds .gitignore style patterns of the paths that can't be\naccessed, e.g. `*.pem`, `.git/` or `/config/secrets.yaml`. DefaultDeny\nif nil; append to it to keep its patterns.",
+			"Sandbox.IncludeIgnored":      "IncludeIgnored gives access to the files ignored by .gitignore files,\nwhich are hidden by default.",
+			"Sandbox.MaxFileSize":         "MaxFileSize is the size limit of the files written, edited or searched,\nin bytes; files read by ToolReadFile are only limited by MaxReadSize,\nexcept for UTF-16 ones. DefaultMaxFileSize if zero, negative for no\nlimit.",
+			"Sandbox.MaxReadSize":         "MaxReadSize is the size limit of the content returned by a single\nToolReadFile call, in bytes; the model reads longer files in chunks.\nDefaultMaxReadSize if zero.",
+			"ShellConfig.Allow":           "Allow holds the commands that can be run: a binary name, which may be\na glob like `*`, optionally followed by the leading arguments the\ncommand must start with, e.g. `git diff`. DefaultShellAllow if nil.",
+			"ShellConfig.Deny":            "Deny holds the commands that can't be run even if allowed, in the same\nform as Allow. DefaultShellDeny if nil; append to it to keep its\ncommands.",
+			"ShellConfig.DenyArgs":        "DenyArgs holds glob patterns of the arguments no command can be passed,\ne.g. `--output*`. DefaultShellDenyArgs if nil.",
+			"ShellConfig.Env":             "Env holds extra `KEY=value` variables, set after scrubbing.",
+			"ShellConfig.MaxOutputSize":   "MaxOutputSize is the size limit of the output returned, in bytes; the\nmiddle of longer output is cut. DefaultMaxOutputSize if zero.",
+			"ShellConfig.Scrub":           "Scrub holds glob patterns of the environment variables hidden from the\ncommands, matched case insensitively. DefaultShellScrub if nil.",
+			"ShellConfig.Timeout":         "Timeout is the time limit of a command, after which it's killed.\nDefaultShellTimeout if zero.",
+			"Tool.Type":                   "always = \"function\"",
+			"ToolFunctionParameters.Type": "object",
+			"diffOp.kind":                 "' ', '-' or '+'",
+			"gitignores.files":            "by directory",
+			"grepOptions.maxCount":        "matching lines per file, 0 for no limit",
+			"hunk.oldStart":               "1-based, a hint only",
+			"ignorePattern.anchored":      "matched against the whole relative path, else the name",
+			"readRange.endLine":           "inclusive, 0 for the last line",
+			"readRange.startLine":         "1-based, 0 for the first line",
+		},
+	})
+}
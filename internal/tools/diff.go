@@ -0,0 +1,346 @@
+package tools
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around the changes of a hunk.
+const diffContext = 3
+
+const noNewlineMarker = `\ No newline at end of file`
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// splitLines splits s into lines, each keeping its newline except possibly
+// the last one.
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script turning a into b, using the
+// Myers algorithm.
+func diffLines(a []string, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds v[-d..d] before step d
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = at(prevK)
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[prevY]})
+		} else {
+			ops = append(ops, diffOp{'-', a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// unifiedDiff returns the unified diff turning oldText into newText, or ""
+// if they are equal. Empty names stand for /dev/null.
+func unifiedDiff(oldName string, newName string, oldText string, newText string) string {
+	if oldText == newText && oldName == newName {
+		return ""
+	}
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var sb strings.Builder
+	sb.WriteString("--- " + diffName("a/", oldName) + "\n")
+	sb.WriteString("+++ " + diffName("b/", newName) + "\n")
+	oldLine, newLine := 0, 0 // lines consumed before ops[i]
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+		// the hunk spans the changes separated by at most 2*diffContext lines
+		start := max(0, i-diffContext)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end = min(len(ops), end+diffContext)
+
+		oldStart, newStart := oldLine-(i-start), newLine-(i-start)
+		var oldCount, newCount int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				sb.WriteString("\n" + noNewlineMarker + "\n")
+			}
+		}
+		// continue after the hunk, counting the lines it consumed
+		for _, op := range ops[i:end] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+func diffName(prefix string, name string) string {
+	if name == "" {
+		return "/dev/null"
+	}
+	return prefix + name
+}
+
+// hunkRange formats a hunk range; start is the number of lines before it.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// filePatch is the change of a single file in a unified diff. Empty paths
+// stand for /dev/null.
+type filePatch struct {
+	oldPath string
+	newPath string
+	hunks   []hunk
+}
+
+type hunk struct {
+	oldStart int // 1-based, a hint only
+	oldLines []string
+	newLines []string
+}
+
+// parsePatch parses a unified diff. Hunk line counts are not trusted, a hunk
+// ends at the next hunk or file header, which tolerates hand written patches.
+func parsePatch(patch string) ([]filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var patches []filePatch
+	var current *filePatch
+	var h *hunk
+	var lastOp byte
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			patches = append(patches, filePatch{
+				oldPath: patchPath(line[4:], "a/"),
+				newPath: patchPath(lines[i+1][4:], "b/"),
+			})
+			current = &patches[len(patches)-1]
+			h = nil
+			i++
+		case strings.HasPrefix(line, "@@"):
+			if current == nil {
+				return nil, fmt.Errorf("hunk without file header at line %d", i+1)
+			}
+			start, err := parseHunkStart(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hunk header at line %d: %w", i+1, err)
+			}
+			current.hunks = append(current.hunks, hunk{oldStart: start})
+			h = &current.hunks[len(current.hunks)-1]
+		case h == nil:
+			// text before the first file header, e.g. `diff --git` lines
+		case line == noNewlineMarker:
+			trimLastNewline(h, lastOp)
+		case line == "" || line[0] == ' ':
+			// editors often strip the space of empty context lines
+			text := strings.TrimPrefix(line, " ") + "\n"
+			h.oldLines = append(h.oldLines, text)
+			h.newLines = append(h.newLines, text)
+			lastOp = ' '
+		case line[0] == '-':
+			h.oldLines = append(h.oldLines, line[1:]+"\n")
+			lastOp = '-'
+		case line[0] == '+':
+			h.newLines = append(h.newLines, line[1:]+"\n")
+			lastOp = '+'
+		default:
+			h = nil // trailing text, e.g. the next `diff --git` line
+		}
+	}
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no file headers (`--- a/path` and `+++ b/path`) found in patch")
+	}
+	for _, p := range patches {
+		if p.oldPath == "" && p.newPath == "" {
+			return nil, fmt.Errorf("patch from /dev/null to /dev/null")
+		}
+	}
+	return patches, nil
+}
+
+func patchPath(header string, prefix string) string {
+	path, _, _ := strings.Cut(header, "\t") // drop timestamps
+	path = strings.TrimSpace(path)
+	if path == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(path, prefix)
+}
+
+func parseHunkStart(header string) (int, error) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") {
+		return 0, fmt.Errorf("expected `@@ -start,count +start,count @@`")
+	}
+	start, _, _ := strings.Cut(fields[1][1:], ",")
+	return strconv.Atoi(start)
+}
+
+func trimLastNewline(h *hunk, op byte) {
+	trim := func(lines []string) {
+		if n := len(lines); n > 0 {
+			lines[n-1] = strings.TrimSuffix(lines[n-1], "\n")
+		}
+	}
+	if op != '+' {
+		trim(h.oldLines)
+	}
+	if op != '-' {
+		trim(h.newLines)
+	}
+}
+
+// applyHunks applies the hunks to text. Each hunk is searched for at its
+// start line first, then at increasing distance from it.
+func applyHunks(text string, hunks []hunk) (string, error) {
+	lines := splitLines(text)
+	var out []string
+	pos := 0    // lines of text consumed
+	offset := 0 // shift of the hunks so far relative to their headers
+	for n, h := range hunks {
+		hint := max(pos, h.oldStart-1+offset)
+		if len(h.oldLines) == 0 { // pure insertions come after their start line
+			hint = max(pos, h.oldStart+offset)
+		}
+		at := findLines(lines, h.oldLines, pos, hint)
+		if at < 0 {
+			return "", fmt.Errorf("hunk %d does not apply: its context and removed lines were not found", n+1)
+		}
+		out = append(out, lines[pos:at]...)
+		out = append(out, h.newLines...)
+		offset += len(h.newLines) - len(h.oldLines)
+		pos = at + len(h.oldLines)
+	}
+	out = append(out, lines[pos:]...)
+	return strings.Join(out, ""), nil
+}
+
+// findLines returns the index of the occurrence of want in lines at or after
+// from which is closest to hint, -1 if there is none.
+func findLines(lines []string, want []string, from int, hint int) int {
+	matches := func(at int) bool {
+		if at < from || at+len(want) > len(lines) {
+			return false
+		}
+		for i, line := range want {
+			if lines[at+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+	hint = min(hint, len(lines))
+	for d := 0; hint-d >= from || hint+d <= len(lines); d++ {
+		if matches(hint - d) {
+			return hint - d
+		}
+		if matches(hint + d) {
+			return hint + d
+		}
+	}
+	return -1
+}
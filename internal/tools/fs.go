@@ -4,16 +4,58 @@ import (
 	// "fmt"
 	// "io"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
+	"sync"
 )
 
+// DefaultDeny are the paths the FS tools can't access unless
+// Sandbox.Deny is set.
+var DefaultDeny = []string{".env", ".git/", "*.pem", "*.key", "id_rsa*", "id_ecdsa*", "id_ed25519*"}
+
+// DefaultMaxFileSize is the size limit of the files written or searched by
+// the FS tools unless Sandbox.MaxFileSize is set.
+const DefaultMaxFileSize = 1 << 20
+
+// DefaultMaxReadSize is the size limit of the content returned by
+// ToolReadFile unless Sandbox.MaxReadSize is set.
+const DefaultMaxReadSize = 64 << 10
+
+// Sandbox restricts the files the FS tools can access, on top of keeping them
+// inside the working directory. Symlinks are followed only as long as they
+// stay inside it.
+type Sandbox struct {
+	// Deny holds .gitignore style patterns of the paths that can't be
+	// accessed, e.g. `*.pem`, `.git/` or `/config/secrets.yaml`. DefaultDeny
+	// if nil; append to it to keep its patterns.
+	Deny []string
+	// IncludeIgnored gives access to the files ignored by .gitignore files,
+	// which are hidden by default.
+	IncludeIgnored bool
+	// MaxFileSize is the size limit of the files written, edited or searched,
+	// in bytes; files read by ToolReadFile are only limited by MaxReadSize,
+	// except for UTF-16 ones. DefaultMaxFileSize if zero, negative for no
+	// limit.
+	MaxFileSize int64
+	// MaxReadSize is the size limit of the content returned by a single
+	// ToolReadFile call, in bytes; the model reads longer files in chunks.
+	// DefaultMaxReadSize if zero.
+	MaxReadSize int
+}
+
 type FS struct {
-	WD string
+	WD      string
+	Sandbox Sandbox
+
+	denyOnce   sync.Once
+	deny       ignoreList
+	gitignores gitignores
 }
 
 // ToolGetCurrentWorkingDirectory returns the current working directory(or so called project directory).
@@ -30,94 +72,271 @@ func (fs *FS) ToolGetCurrentWorkingDirectory() (string, error) {
 	return cwd, nil
 }
 
+// root returns the working directory with symlinks evaluated.
+func (fs *FS) root() (string, error) {
+	cwd, err := fs.ToolGetCurrentWorkingDirectory()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cwd: %w", err)
+	}
+	cwd, err = filepath.Abs(cwd)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(cwd)
+}
+
+// resolvePath returns the absolute path of filePath, relative to the working
+// directory, and its cleaned relative form. The directories of the path are
+// resolved; a symlink at the path itself is kept, so that it can be moved or
+// deleted, but its target is checked as well. Paths outside of the working
+// directory, denied by the sandbox or ignored by .gitignore are rejected.
+func (fs *FS) resolvePath(filePath string) (string, string, error) {
+	filePath = strings.TrimSpace(filePath)
+	if filePath == "" {
+		return "", "", fmt.Errorf("path must not be empty")
+	}
+	if filepath.IsAbs(filePath) {
+		return "", "", fmt.Errorf("path `%s` must be relative to CWD", filePath)
+	}
+	rel := filepath.Clean(filePath)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("path `%s` is outside of the project directory", filePath)
+	}
+	root, err := fs.root()
+	if err != nil {
+		return "", "", err
+	}
+	if rel == "." {
+		return root, rel, nil
+	}
+
+	dir, err := evalSymlinks(filepath.Join(root, filepath.Dir(rel)))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve `%s`: %w", filePath, err)
+	}
+	fullPath := filepath.Join(dir, filepath.Base(rel))
+	checked := []string{fullPath}
+	if info, err := os.Lstat(fullPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := filepath.EvalSymlinks(fullPath)
+		if err != nil {
+			return "", "", fmt.Errorf("`%s` is a broken symlink", filePath)
+		}
+		checked = append(checked, target)
+	}
+	info, err := os.Stat(fullPath)
+	if err := fs.checkAccess(root, rel, err == nil && info.IsDir()); err != nil {
+		return "", "", err
+	}
+	for _, path := range checked {
+		pathRel, err := filepath.Rel(root, path)
+		if err != nil || pathRel == ".." || strings.HasPrefix(pathRel, ".."+string(filepath.Separator)) {
+			return "", "", fmt.Errorf("path `%s` resolves outside of the project directory", filePath)
+		}
+		info, err := os.Stat(path)
+		if err := fs.checkAccess(root, pathRel, err == nil && info.IsDir()); err != nil {
+			return "", "", err
+		}
+	}
+	return fullPath, rel, nil
+}
+
+// evalSymlinks evaluates the symlinks of path, which may not exist yet.
+func evalSymlinks(path string) (string, error) {
+	var missing []string
+	for {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{real}, missing...)...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if _, err := os.Lstat(path); err == nil {
+			return "", fmt.Errorf("`%s` is a broken symlink", path)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// checkAccess checks rel, relative to root, and its parent directories
+// against the deny-list and the .gitignore files.
+func (fs *FS) checkAccess(root string, rel string, isDir bool) error {
+	if rel == "." {
+		return nil
+	}
+	deny := fs.denyList()
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		prefixIsDir := i < len(parts)-1 || isDir
+		if _, denied := deny.match(prefix, prefixIsDir); denied {
+			return fmt.Errorf("access to `%s` is denied", rel)
+		}
+		if !fs.Sandbox.IncludeIgnored && fs.gitignores.ignored(root, prefix, prefixIsDir) {
+			return fmt.Errorf("`%s` is ignored by .gitignore", rel)
+		}
+	}
+	return nil
+}
+
+func (fs *FS) denyPatterns() []string {
+	if fs.Sandbox.Deny == nil {
+		return DefaultDeny
+	}
+	return fs.Sandbox.Deny
+}
+
+func (fs *FS) denyList() ignoreList {
+	fs.denyOnce.Do(func() {
+		fs.deny = parseIgnoreList(fs.denyPatterns())
+	})
+	return fs.deny
+}
+
+func (fs *FS) maxFileSize() int64 {
+	if fs.Sandbox.MaxFileSize == 0 {
+		return DefaultMaxFileSize
+	}
+	return fs.Sandbox.MaxFileSize
+}
+
+func (fs *FS) maxReadSize() int {
+	if fs.Sandbox.MaxReadSize <= 0 {
+		return DefaultMaxReadSize
+	}
+	return fs.Sandbox.MaxReadSize
+}
+
+// checkSize checks a file size against the size limit of the sandbox.
+func (fs *FS) checkSize(rel string, size int64) error {
+	if limit := fs.maxFileSize(); limit > 0 && size > limit {
+		return fmt.Errorf("`%s` is %d bytes, over the limit of %d bytes", rel, size, limit)
+	}
+	return nil
+}
+
 // ToolGrepText returns ripgrep output for given text in the given dir
 //
 // text: pattern to search
 // includeHiddenFiles: bool flag to determine if hidden files are included or not. rg option `-.` is used when enabled
-// caseSensitive: bool flag to determine if search is performed dace sensitive or not. rg option `-sis used when enabled
+// caseSensitive: bool flag to determine if search is performed case sensitive or not. rg option `-s` is used when enabled, `-i` otherwise
 // dir: relative directory path to CWD, to perform ripgrep in `CWD/dir`.
-func (fs *FS) ToolGrepText(text string, includeHiddenFiles bool, caseSensitive bool, dir string) (string, error) {
-	var args []string
-
-	// flags
-	if includeHiddenFiles {
-		args = append(args, "-.")
-	}
-	if caseSensitive {
-		args = append(args, "-s")
-	}
-
+// maxCount: optional, maximum number of matching lines per file. rg option `-m`
+// contextLines: optional, number of lines shown before and after each match. rg option `-C`
+func (fs *FS) ToolGrepText(ctx context.Context, text string, includeHiddenFiles bool, caseSensitive bool, dir string, maxCount *int, contextLines *int) (string, error) {
 	if text == "" {
 		return "", fmt.Errorf("Cannot perform rg for empty string")
 	}
 	if text == "*" {
 		return "", fmt.Errorf("Cannot perform rg for everything")
 	}
-	args = append(args, text)
-
-	// final arg is the directory to search
-	cwd, cwdErr := fs.ToolGetCurrentWorkingDirectory()
-	if cwdErr != nil {
-		return "", fmt.Errorf("[ToolGrepText] Failed to get ToolGetCurrentWorkingDirectory output: %v", cwdErr)
-	}
-	dir = strings.TrimSpace(dir)
-	dir = filepath.Clean(dir)
-	if filepath.IsAbs(dir) {
-		return "", fmt.Errorf("[ToolGrepText] dir must be relative to CWD.")
+	opts := grepOptions{pattern: text, includeHidden: includeHiddenFiles, caseSensitive: caseSensitive}
+	if maxCount != nil {
+		opts.maxCount = max(*maxCount, 0)
 	}
-	if dir == "" || dir == "." {
-		dir = cwd
+	if contextLines != nil {
+		opts.contextLines = max(*contextLines, 0)
 	}
-	args = append(args, dir)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	cmd := exec.CommandContext(ctx, "rg", args...) // TODO: CommandContext with timeout
 
-	output, err := cmd.CombinedOutput()
+	if strings.TrimSpace(dir) == "" {
+		dir = "."
+	}
+	dirPath, _, err := fs.resolvePath(dir)
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			// https://manpages.debian.org/unstable/ripgrep/rg.1.en.html#EXIT_STATUS
-			if exitErr.ExitCode() == 1 {
-				return "No match", nil
-			}
-			return "", err
-		}
-		return "", err
+		return "", fmt.Errorf("[ToolGrepText] %w", err)
 	}
 
-	return string(output), nil
+	rgPath, err := exec.LookPath("rg")
+	if err != nil {
+		return fs.grepGo(ctx, opts, dirPath)
+	}
+	return fs.grepRg(ctx, rgPath, opts, dirPath)
 }
 
-// ToolReadFile returns the entire contents of the file at the given path.
-// The path is treated as relative to the current working directory.
+// ToolReadFile returns the contents of the file at the given path, or the
+// requested range of it. The path is treated as relative to the current
+// working directory. Long content is cut, ending with a note on how to read
+// the rest. Binary files are summarized instead.
 //
 // filePath: relative path to the CWD
-func (fs *FS) ToolReadFile(filePath string) (string, error) {
-	filePath = strings.TrimSpace(filePath)
-	if filePath == "" {
-		return "", fmt.Errorf("path must not be empty")
-	}
-	filePath = filepath.Clean(filePath)
-	if filepath.Base(filePath) == ".env" {
-		return "", fmt.Errorf("Reading .env file is forbidden")
+// startLine: optional, first line to return, starting at 1
+// endLine: optional, last line to return, inclusive
+// offset: optional, byte offset to start at instead of a line, for files with very long lines
+// lineNumbers: optional, prefix every line with its line number
+func (fs *FS) ToolReadFile(filePath string, startLine *int, endLine *int, offset *int, lineNumbers *bool) (string, error) {
+	fullPath, rel, err := fs.resolvePath(filePath)
+	if err != nil {
+		return "", fmt.Errorf("[ToolReadFile] %w", err)
 	}
-	cwd, err := fs.ToolGetCurrentWorkingDirectory()
+	info, err := os.Stat(fullPath)
 	if err != nil {
-		return "", fmt.Errorf("[ToolReadFile] failed to get cwd: %w", err)
+		return "", err
 	}
-	fullPath := filepath.Join(cwd, filePath)
-	if !strings.HasPrefix(fullPath, cwd) {
-		return "", fmt.Errorf("reading outside project directory is not allowed")
+	if info.IsDir() {
+		return "", fmt.Errorf("[ToolReadFile] `%s` is a directory", rel)
+	}
+	if offset != nil && (startLine != nil || endLine != nil) {
+		return "", fmt.Errorf("[ToolReadFile] offset can't be combined with startLine or endLine")
 	}
 
-	// TODO: check file size
-
-	data, err := os.ReadFile(fullPath)
+	f, err := os.Open(fullPath)
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+	defer f.Close()
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	head = head[:n]
+	var text *io.SectionReader
+	switch encoding, bom := sniffText(head, int64(n) == info.Size()); encoding {
+	case encodingUTF8:
+		text = io.NewSectionReader(f, int64(bom), info.Size()-int64(bom))
+	case encodingUTF16:
+		// decoded whole, within the size limit
+		if err := fs.checkSize(rel, info.Size()); err != nil {
+			return "", fmt.Errorf("[ToolReadFile] %w", err)
+		}
+		data, err := os.ReadFile(fullPath)
+		if err != nil {
+			return "", err
+		}
+		decoded, ok := decodeUTF16(data)
+		if !ok {
+			return binaryFileSummary(rel, head, info.Size()), nil
+		}
+		text = io.NewSectionReader(strings.NewReader(decoded), 0, int64(len(decoded)))
+	default:
+		return binaryFileSummary(rel, head, info.Size()), nil
+	}
+	r := readRange{lineNumbers: lineNumbers != nil && *lineNumbers, limit: fs.maxReadSize()}
+	if offset != nil {
+		r.byOffset, r.offset = true, *offset
+	}
+	if startLine != nil {
+		r.startLine = *startLine
+	}
+	if endLine != nil {
+		r.endLine = *endLine
+	}
+	content, err := r.read(text)
+	if err != nil {
+		return "", fmt.Errorf("[ToolReadFile] %w", err)
+	}
+	return content, nil
+}
+
+// binaryFileSummary describes a file that isn't shown, from head, its first
+// bytes.
+func binaryFileSummary(rel string, head []byte, size int64) string {
+	return fmt.Sprintf("`%s` is not a text file (%s, %d bytes), its content is not shown.", rel, http.DetectContentType(head), size)
 }
 
 // ToolTree returns an ASCII tree representation of the directory tree
@@ -129,14 +348,20 @@ func (fs *FS) ToolTree(dir string, maxDepth int) (string, error) {
 	if maxDepth < 1 {
 		return "", fmt.Errorf("[ToolTree] maxDepth must be a positive integer")
 	}
-	cwd, err := fs.ToolGetCurrentWorkingDirectory()
+	if strings.TrimSpace(dir) == "" {
+		dir = "."
+	}
+	root, _, err := fs.resolvePath(dir)
 	if err != nil {
-		return "", fmt.Errorf("[ToolTree] failed to get cwd: %w", err)
+		return "", fmt.Errorf("[ToolTree] %w", err)
 	}
-	if filepath.IsAbs(dir) {
-		return "", fmt.Errorf("[ToolTree] dir must be relative to CWD.")
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", err
+	}
+	wd, err := fs.root()
+	if err != nil {
+		return "", fmt.Errorf("[ToolTree] %w", err)
 	}
-	root := filepath.Join(cwd, filepath.Clean(dir))
 
 	var sb strings.Builder
 	err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
@@ -147,6 +372,12 @@ func (fs *FS) ToolTree(dir string, maxDepth int) (string, error) {
 		if rel == "." {
 			rel = ""
 		}
+		if wdRel, _ := filepath.Rel(wd, path); rel != "" && fs.checkAccess(wd, wdRel, d.IsDir()) != nil {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		depth := strings.Count(rel, string(filepath.Separator))
 		if depth > maxDepth {
 			if d.IsDir() && rel != "" {
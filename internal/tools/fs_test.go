@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFS_Sandbox(t *testing.T) {
+	base := t.TempDir()
+	wd := filepath.Join(base, "proj")
+	files := map[string]string{
+		"proj/main.go":               "package main\n",
+		"proj/.gitignore":            "*.log\nbuild/\n",
+		"proj/.env":                  "KEY=secret\n",
+		"proj/certs/server.pem":      "cert",
+		"proj/.git/config":           "[core]\n",
+		"proj/home/.ssh/id_rsa.pub":  "ssh-rsa",
+		"proj/app.log":               "log",
+		"proj/build/out.txt":         "out",
+		"proj/docs/.gitignore":       "*.txt\n!keep.txt\n",
+		"proj/docs/notes.txt":        "notes",
+		"proj/docs/keep.txt":         "keep",
+		"proj/big.txt":               strings.Repeat("x", 100),
+		"proj-secrets/passwords.txt": "hunter2",
+	}
+	for name, content := range files {
+		path := filepath.Join(base, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"proj/outside":   filepath.Join(base, "proj-secrets"),
+		"proj/pw.txt":    filepath.Join(base, "proj-secrets/passwords.txt"),
+		"proj/dotenv":    ".env",
+		"proj/readme.go": "main.go",
+	} {
+		if err := os.Symlink(target, filepath.Join(base, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := &FS{WD: wd, Sandbox: Sandbox{MaxFileSize: 50}}
+
+	for _, path := range []string{
+		"../proj-secrets/passwords.txt",
+		"outside/passwords.txt",
+		"pw.txt",
+		".env",
+		"dotenv",
+		"certs/server.pem",
+		".git/config",
+		"home/.ssh/id_rsa.pub",
+		"app.log",
+		"build/out.txt",
+		"docs/notes.txt",
+	} {
+		if content, err := fs.ToolReadFile(path, nil, nil, nil, nil); err == nil {
+			t.Errorf("expected reading %s to be rejected, got %q", path, content)
+		}
+	}
+	// files over MaxFileSize are read in chunks of MaxReadSize
+	for _, path := range []string{"main.go", "readme.go", "docs/keep.txt", "big.txt"} {
+		if _, err := fs.ToolReadFile(path, nil, nil, nil, nil); err != nil {
+			t.Errorf("expected %s to be readable, got %v", path, err)
+		}
+	}
+
+	tree, err := fs.ToolTree(".", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{".git/", "server.pem", "app.log", "build/", "notes.txt", ".env\n"} {
+		if strings.Contains(tree, name) {
+			t.Errorf("expected %s to be hidden from the tree:\n%s", name, tree)
+		}
+	}
+	if !strings.Contains(tree, "keep.txt") {
+		t.Errorf("expected keep.txt in the tree:\n%s", tree)
+	}
+
+	fs = &FS{WD: wd, Sandbox: Sandbox{Deny: []string{"*.go"}, IncludeIgnored: true}}
+	if _, err := fs.ToolReadFile("main.go", nil, nil, nil, nil); err == nil {
+		t.Error("expected the custom deny-list to apply")
+	}
+	if _, err := fs.ToolReadFile("app.log", nil, nil, nil, nil); err != nil {
+		t.Errorf("expected ignored files to be readable, got %v", err)
+	}
+}
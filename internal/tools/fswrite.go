@@ -0,0 +1,528 @@
+package tools
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FSWrite holds the tools changing files in the working directory of FS,
+// with the same path restrictions as the read-only tools. Every change is
+// recorded in Journal, if set, so that it can be rolled back.
+type FSWrite struct {
+	FS      *FS
+	Journal *Journal
+}
+
+// Journal records the changes made by the FSWrite tools. It's safe for
+// concurrent use.
+type Journal struct {
+	mu      sync.Mutex
+	entries []JournalEntry
+}
+
+// JournalEntry is a change made by an FSWrite tool.
+type JournalEntry struct {
+	Time    time.Time
+	Summary string // e.g. `edit main.go`
+	Diff    string // unified diff of the change, empty for directories
+	undo    func() error
+}
+
+func (j *Journal) record(summary string, diff string, undo func() error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, JournalEntry{Time: time.Now(), Summary: summary, Diff: diff, undo: undo})
+}
+
+// Entries returns the recorded changes, oldest first.
+func (j *Journal) Entries() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return slices.Clone(j.entries)
+}
+
+// Undo reverts the most recent change and removes it from the journal. A
+// change that fails to be reverted stays in the journal.
+func (j *Journal) Undo() (JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) == 0 {
+		return JournalEntry{}, fmt.Errorf("no changes to undo")
+	}
+	entry := j.entries[len(j.entries)-1]
+	if err := entry.undo(); err != nil {
+		return entry, fmt.Errorf("failed to undo `%s`: %w", entry.Summary, err)
+	}
+	j.entries = j.entries[:len(j.entries)-1]
+	return entry, nil
+}
+
+// Rollback reverts every recorded change, most recent first. It stops at the
+// first change that fails to be reverted, which stays in the journal with
+// the ones before it.
+func (j *Journal) Rollback() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for len(j.entries) > 0 {
+		entry := j.entries[len(j.entries)-1]
+		if err := entry.undo(); err != nil {
+			return fmt.Errorf("failed to undo `%s`: %w", entry.Summary, err)
+		}
+		j.entries = j.entries[:len(j.entries)-1]
+	}
+	return nil
+}
+
+// ToolWriteFile creates the file at the given path, or overwrites it, with the
+// given content. Missing parent directories are created. Returns the diff of
+// the change.
+//
+// filePath: relative path to the CWD
+// content: the entire new content of the file
+// dryRun: when true, nothing is written and the diff of the change is returned
+func (w *FSWrite) ToolWriteFile(filePath string, content string, dryRun bool) (string, error) {
+	path, rel, err := w.FS.resolvePath(filePath)
+	if err != nil {
+		return "", fmt.Errorf("[ToolWriteFile] %w", err)
+	}
+	before, err := w.FS.readFileState(path, rel)
+	if err != nil {
+		return "", fmt.Errorf("[ToolWriteFile] %w", err)
+	}
+	changes := []fileChange{{rel: rel, before: before, after: &content}}
+	return w.commit("write "+rel, changes, diffOf(changes), dryRun)
+}
+
+// ToolEditFile replaces oldText with newText in the file at the given path.
+// oldText must match the file exactly, including whitespace, and be unique
+// unless replaceAll is set. Returns the diff of the change.
+//
+// filePath: relative path to the CWD
+// oldText: exact text to replace, with enough surrounding lines to be unique
+// newText: replacement text
+// replaceAll: replace every occurrence of oldText instead of a unique one
+// dryRun: when true, nothing is written and the diff of the change is returned
+func (w *FSWrite) ToolEditFile(filePath string, oldText string, newText string, replaceAll bool, dryRun bool) (string, error) {
+	if oldText == "" {
+		return "", fmt.Errorf("[ToolEditFile] oldText must not be empty, use ToolWriteFile to create files")
+	}
+	path, rel, err := w.FS.resolvePath(filePath)
+	if err != nil {
+		return "", fmt.Errorf("[ToolEditFile] %w", err)
+	}
+	before, err := w.FS.readFileState(path, rel)
+	if err != nil {
+		return "", fmt.Errorf("[ToolEditFile] %w", err)
+	}
+	if !before.exists {
+		return "", fmt.Errorf("[ToolEditFile] `%s` does not exist", rel)
+	}
+	content := string(before.content)
+	count := strings.Count(content, oldText)
+	if count == 0 {
+		return "", fmt.Errorf("[ToolEditFile] oldText not found in `%s`", rel)
+	}
+	if count > 1 && !replaceAll {
+		return "", fmt.Errorf("[ToolEditFile] oldText found %d times in `%s`, include more surrounding lines to make it unique or set replaceAll", count, rel)
+	}
+	after := strings.ReplaceAll(content, oldText, newText)
+	changes := []fileChange{{rel: rel, before: before, after: &after}}
+	return w.commit("edit "+rel, changes, diffOf(changes), dryRun)
+}
+
+// ToolApplyPatch applies a unified diff, as produced by `diff -u` or `git
+// diff`, to one or more files. Use /dev/null as the old path to create a file
+// and as the new path to delete one. Either every file is changed or none.
+// Returns the diff of the change.
+//
+// patch: unified diff with `--- a/path` and `+++ b/path` headers and `@@` hunks
+// dryRun: when true, nothing is written and the diff of the change is returned
+func (w *FSWrite) ToolApplyPatch(patch string, dryRun bool) (string, error) {
+	filePatches, err := parsePatch(patch)
+	if err != nil {
+		return "", fmt.Errorf("[ToolApplyPatch] %w", err)
+	}
+	var changes []fileChange
+	var diff strings.Builder
+	var names []string
+	seen := make(map[string]bool)
+	for _, filePatch := range filePatches {
+		source, sourceRel, err := w.patchFileState(filePatch.oldPath)
+		if err != nil {
+			return "", fmt.Errorf("[ToolApplyPatch] %w", err)
+		}
+		target, targetRel, err := w.patchFileState(filePatch.newPath)
+		if err != nil {
+			return "", fmt.Errorf("[ToolApplyPatch] %w", err)
+		}
+		renamed := sourceRel != "" && targetRel != "" && sourceRel != targetRel
+		switch {
+		case sourceRel != "" && !source.exists:
+			return "", fmt.Errorf("[ToolApplyPatch] `%s` does not exist", sourceRel)
+		case (sourceRel == "" || renamed) && target.exists:
+			return "", fmt.Errorf("[ToolApplyPatch] `%s` already exists", targetRel)
+		}
+		for _, rel := range slices.Compact([]string{sourceRel, targetRel}) {
+			if rel != "" && seen[rel] {
+				return "", fmt.Errorf("[ToolApplyPatch] `%s` is changed more than once", rel)
+			}
+			seen[rel] = true
+		}
+
+		newText, err := applyHunks(string(source.content), filePatch.hunks)
+		if err != nil {
+			return "", fmt.Errorf("[ToolApplyPatch] `%s`: %w", cmp.Or(sourceRel, targetRel), err)
+		}
+		switch {
+		case targetRel == "":
+			changes = append(changes, fileChange{rel: sourceRel, before: source})
+		case renamed:
+			changes = append(changes, fileChange{rel: sourceRel, before: source}, fileChange{rel: targetRel, before: target, after: &newText})
+		default:
+			changes = append(changes, fileChange{rel: targetRel, before: target, after: &newText})
+		}
+		diff.WriteString(unifiedDiff(sourceRel, targetRel, string(source.content), newText))
+		names = append(names, cmp.Or(targetRel, sourceRel))
+	}
+	return w.commit("apply patch to "+strings.Join(names, ", "), changes, diff.String(), dryRun)
+}
+
+// patchFileState reads the file at a path of a patch, which is empty for
+// /dev/null.
+func (w *FSWrite) patchFileState(filePath string) (fileState, string, error) {
+	if filePath == "" {
+		return fileState{}, "", nil
+	}
+	path, rel, err := w.FS.resolvePath(filePath)
+	if err != nil {
+		return fileState{}, "", err
+	}
+	state, err := w.FS.readFileState(path, rel)
+	return state, rel, err
+}
+
+// ToolCreateDirectory creates the directory at the given path, with its
+// missing parents.
+//
+// dir: relative path to the CWD
+// dryRun: when true, nothing is created and the directories to create are returned
+func (w *FSWrite) ToolCreateDirectory(dir string, dryRun bool) (string, error) {
+	path, rel, err := w.FS.resolvePath(dir)
+	if err != nil {
+		return "", fmt.Errorf("[ToolCreateDirectory] %w", err)
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return fmt.Sprintf("Directory `%s` already exists.", rel), nil
+	}
+	missing, err := missingDirs(path)
+	if err != nil {
+		return "", fmt.Errorf("[ToolCreateDirectory] %w", err)
+	}
+	summary := "create directory " + rel
+	// the missing directories are the innermost ones of rel
+	var parents []string
+	for parent := filepath.Dir(rel); len(parents) < len(missing)-1; parent = filepath.Dir(parent) {
+		parents = append(parents, parent)
+	}
+	if len(parents) > 0 {
+		slices.Reverse(parents)
+		summary += " with its missing parents " + strings.Join(parents, ", ")
+	}
+	if dryRun {
+		return fmt.Sprintf("Dry run, nothing was changed. Would %s.", summary), nil
+	}
+
+	created, err := mkdirAll(path)
+	if err != nil {
+		return "", fmt.Errorf("[ToolCreateDirectory] %w", err)
+	}
+	w.record(summary, "", func() error { return removeDirs(created) })
+	return fmt.Sprintf("Done: %s.", summary), nil
+}
+
+// ToolMovePath moves or renames the file or directory at source to
+// destination, which must not exist. Missing parent directories of
+// destination are created.
+//
+// source: relative path to the CWD
+// destination: relative path to the CWD
+// dryRun: when true, nothing is moved
+func (w *FSWrite) ToolMovePath(source string, destination string, dryRun bool) (string, error) {
+	sourcePath, sourceRel, err := w.FS.resolvePath(source)
+	if err != nil {
+		return "", fmt.Errorf("[ToolMovePath] %w", err)
+	}
+	destPath, destRel, err := w.FS.resolvePath(destination)
+	if err != nil {
+		return "", fmt.Errorf("[ToolMovePath] %w", err)
+	}
+	if sourceRel == "." {
+		return "", fmt.Errorf("[ToolMovePath] cannot move the project directory")
+	}
+	if _, err := os.Lstat(sourcePath); err != nil {
+		return "", fmt.Errorf("[ToolMovePath] %w", err)
+	}
+	if _, err := os.Lstat(destPath); err == nil {
+		return "", fmt.Errorf("[ToolMovePath] `%s` already exists", destRel)
+	}
+	summary := fmt.Sprintf("move %s to %s", sourceRel, destRel)
+	if dryRun {
+		return fmt.Sprintf("Dry run, nothing was changed. Would %s.", summary), nil
+	}
+
+	created, err := mkdirAll(filepath.Dir(destPath))
+	if err != nil {
+		return "", fmt.Errorf("[ToolMovePath] %w", err)
+	}
+	if err := os.Rename(sourcePath, destPath); err != nil {
+		return "", fmt.Errorf("[ToolMovePath] %w", errors.Join(err, removeDirs(created)))
+	}
+	diff := fmt.Sprintf("rename from %s\nrename to %s\n", sourceRel, destRel)
+	w.record(summary, diff, func() error {
+		if err := os.Rename(destPath, sourcePath); err != nil {
+			return err
+		}
+		return removeDirs(created)
+	})
+	return fmt.Sprintf("Done: %s.", summary), nil
+}
+
+// ToolDeletePath deletes the file or empty directory at the given path.
+// Returns the diff of the change.
+//
+// filePath: relative path to the CWD
+// dryRun: when true, nothing is deleted and the diff of the change is returned
+func (w *FSWrite) ToolDeletePath(filePath string, dryRun bool) (string, error) {
+	path, rel, err := w.FS.resolvePath(filePath)
+	if err != nil {
+		return "", fmt.Errorf("[ToolDeletePath] %w", err)
+	}
+	if rel == "." {
+		return "", fmt.Errorf("[ToolDeletePath] cannot delete the project directory")
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("[ToolDeletePath] %w", err)
+	}
+	if !info.IsDir() {
+		before, err := w.FS.readFileState(path, rel)
+		if err != nil {
+			return "", fmt.Errorf("[ToolDeletePath] %w", err)
+		}
+		changes := []fileChange{{rel: rel, before: before}}
+		return w.commit("delete "+rel, changes, diffOf(changes), dryRun)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", fmt.Errorf("[ToolDeletePath] %w", err)
+	}
+	if len(entries) > 0 {
+		return "", fmt.Errorf("[ToolDeletePath] directory `%s` is not empty, delete its contents first", rel)
+	}
+	summary := "delete directory " + rel
+	if dryRun {
+		return fmt.Sprintf("Dry run, nothing was changed. Would %s.", summary), nil
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("[ToolDeletePath] %w", err)
+	}
+	w.record(summary, "", func() error { return os.Mkdir(path, info.Mode().Perm()) })
+	return fmt.Sprintf("Done: %s.", summary), nil
+}
+
+// ToolUndoLastChange reverts the most recent change made by the file writing
+// tools, e.g. after a wrong edit.
+func (w *FSWrite) ToolUndoLastChange() (string, error) {
+	if w.Journal == nil {
+		return "", fmt.Errorf("[ToolUndoLastChange] changes are not recorded")
+	}
+	entry, err := w.Journal.Undo()
+	if err != nil {
+		return "", fmt.Errorf("[ToolUndoLastChange] %w", err)
+	}
+	return fmt.Sprintf("Reverted: %s.", entry.Summary), nil
+}
+
+func (w *FSWrite) record(summary string, diff string, undo func() error) {
+	if w.Journal != nil {
+		w.Journal.record(summary, diff, undo)
+	}
+}
+
+// commit applies the changes, unless dryRun, and reports diff to the model.
+func (w *FSWrite) commit(summary string, changes []fileChange, diff string, dryRun bool) (string, error) {
+	if diff == "" {
+		return "No changes, the content is already as requested.", nil
+	}
+	for _, c := range changes {
+		if c.after != nil {
+			if err := w.FS.checkSize(c.rel, int64(len(*c.after))); err != nil {
+				return "", err
+			}
+		}
+	}
+	if dryRun {
+		return "Dry run, nothing was changed. Diff:\n" + diff, nil
+	}
+	undo, err := applyChanges(changes)
+	if err != nil {
+		return "", err
+	}
+	w.record(summary, diff, undo)
+	return fmt.Sprintf("Done: %s. Diff:\n%s", summary, diff), nil
+}
+
+// fileState is the state of a file before a change, restored on undo.
+type fileState struct {
+	path    string
+	exists  bool
+	content []byte
+	mode    os.FileMode
+}
+
+// readFileState reads the file at path. Only regular files within the size
+// limit and missing files can be changed.
+func (fs *FS) readFileState(path string, rel string) (fileState, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fileState{path: path}, nil
+	}
+	if err != nil {
+		return fileState{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return fileState{}, fmt.Errorf("`%s` is not a regular file", rel)
+	}
+	if err := fs.checkSize(rel, info.Size()); err != nil {
+		return fileState{}, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{path: path, exists: true, content: content, mode: info.Mode().Perm()}, nil
+}
+
+func (s fileState) restore() error {
+	if !s.exists {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, s.content, s.mode)
+}
+
+// fileChange sets the content of a file, a nil after deletes it.
+type fileChange struct {
+	rel    string
+	before fileState
+	after  *string
+}
+
+func diffOf(changes []fileChange) string {
+	var sb strings.Builder
+	for _, c := range changes {
+		oldName, newName := "", ""
+		if c.before.exists {
+			oldName = c.rel
+		}
+		after := ""
+		if c.after != nil {
+			newName, after = c.rel, *c.after
+		}
+		sb.WriteString(unifiedDiff(oldName, newName, string(c.before.content), after))
+	}
+	return sb.String()
+}
+
+// applyChanges makes the changes in order and returns the function reverting
+// them. If a change fails, the ones made so far are reverted.
+func applyChanges(changes []fileChange) (func() error, error) {
+	var created []string
+	done := 0
+	undo := func() error {
+		var errs []error
+		for i := done - 1; i >= 0; i-- {
+			errs = append(errs, changes[i].before.restore())
+		}
+		errs = append(errs, removeDirs(created))
+		return errors.Join(errs...)
+	}
+	for _, c := range changes {
+		var err error
+		if c.after == nil {
+			err = os.Remove(c.before.path)
+		} else {
+			var dirs []string
+			dirs, err = mkdirAll(filepath.Dir(c.before.path))
+			created = append(created, dirs...)
+			if err == nil {
+				mode := c.before.mode
+				if !c.before.exists {
+					mode = 0o644
+				}
+				err = os.WriteFile(c.before.path, []byte(*c.after), mode)
+			}
+		}
+		done++ // a failed write may have changed the file too
+		if err != nil {
+			return nil, errors.Join(err, undo())
+		}
+	}
+	return undo, nil
+}
+
+// mkdirAll creates dir and its missing parents, returning the created
+// directories, outermost first.
+func mkdirAll(dir string) ([]string, error) {
+	missing, err := missingDirs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range missing {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			return nil, errors.Join(err, removeDirs(missing[:i]))
+		}
+	}
+	return missing, nil
+}
+
+// missingDirs returns dir and its parents that don't exist, outermost first.
+func missingDirs(dir string) ([]string, error) {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	slices.Reverse(missing)
+	return missing, nil
+}
+
+// removeDirs removes directories created by mkdirAll, innermost first.
+func removeDirs(dirs []string) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Remove(dirs[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
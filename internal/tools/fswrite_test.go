@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff_ApplyPatch(t *testing.T) {
+	oldText := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	newText := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn"
+	diff := unifiedDiff("x.txt", "x.txt", oldText, newText)
+	expected := "--- a/x.txt\n+++ b/x.txt\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -11,3 +11,4 @@\n k\n l\n m\n+n\n\\ No newline at end of file\n"
+	if diff != expected {
+		t.Fatalf("unexpected diff:\n%s", diff)
+	}
+
+	patches, err := parsePatch(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hunks are found when the file moved since the diff was made
+	patched, err := applyHunks("new\nfirst\nline\n"+oldText, patches[0].hunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched != "new\nfirst\nline\n"+newText {
+		t.Errorf("unexpected patched text:\n%s", patched)
+	}
+	if _, err := applyHunks("a\nc\n", patches[0].hunks); err == nil {
+		t.Error("expected a hunk without matching context to fail")
+	}
+}
+
+func TestFSWrite(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readFile := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		return string(data)
+	}
+	writeFile("main.go", "package main\n\nfunc main() {}\n")
+	w := &FSWrite{FS: &FS{WD: dir}, Journal: &Journal{}}
+
+	for _, path := range []string{"../outside.txt", "/etc/passwd", "sub/../../outside.txt", ".env"} {
+		if _, err := w.ToolWriteFile(path, "x", false); err == nil {
+			t.Errorf("expected writing %s to be rejected", path)
+		}
+	}
+
+	out, err := w.ToolEditFile("main.go", "func main() {}", "func main() {\n\tprintln(1)\n}", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "+\tprintln(1)") || readFile("main.go") != "package main\n\nfunc main() {}\n" {
+		t.Errorf("expected a dry run diff without changes, got %q", out)
+	}
+	out, err = w.ToolCreateDirectory("pkg/util", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "Dry run, nothing was changed. Would create directory pkg/util with its missing parents pkg." {
+		t.Errorf("unexpected dry run result %q", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pkg")); !os.IsNotExist(err) {
+		t.Errorf("expected the dry run not to create directories, got %v", err)
+	}
+	if _, err := w.ToolEditFile("main.go", "main", "x", false, false); err == nil {
+		t.Error("expected an ambiguous edit to fail")
+	}
+	steps := []func() (string, error){
+		func() (string, error) {
+			return w.ToolEditFile("main.go", "func main() {}", "func main() {\n\tprintln(1)\n}", false, false)
+		},
+		func() (string, error) { return w.ToolCreateDirectory("pkg/util", false) },
+		func() (string, error) { return w.ToolWriteFile("pkg/util/util.go", "package util\n", false) },
+		func() (string, error) {
+			return w.ToolApplyPatch("--- a/pkg/util/util.go\n+++ b/pkg/util/helpers.go\n@@ -1 +1,3 @@\n package util\n+\n+func Help() {}\n"+
+				"--- /dev/null\n+++ b/README.md\n@@ -0,0 +1 @@\n+# x\n", false)
+		},
+		func() (string, error) { return w.ToolMovePath("README.md", "docs/README.md", false) },
+		func() (string, error) { return w.ToolDeletePath("docs/README.md", false) },
+		func() (string, error) { return w.ToolDeletePath("docs", false) },
+	}
+	for i, step := range steps {
+		if _, err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if got := readFile("pkg/util/helpers.go"); got != "package util\n\nfunc Help() {}\n" {
+		t.Errorf("unexpected patched file: %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pkg/util/util.go")); !os.IsNotExist(err) {
+		t.Errorf("expected the renamed file to be gone, got %v", err)
+	}
+	if n := len(w.Journal.Entries()); n != len(steps) {
+		t.Errorf("expected %d journal entries, got %d", len(steps), n)
+	}
+
+	if out, err := w.ToolUndoLastChange(); err != nil || out != "Reverted: delete directory docs." {
+		t.Errorf("unexpected undo result %q, %v", out, err)
+	}
+	if err := w.Journal.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || readFile("main.go") != "package main\n\nfunc main() {}\n" {
+		t.Errorf("expected the rollback to restore the initial tree, got %v", entries)
+	}
+}
@@ -3,11 +3,15 @@
 package tools
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"go/ast"
 	"reflect"
-	"strconv"
+	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/assagman/apc/internal/logger"
 	"golang.org/x/tools/go/packages"
@@ -15,8 +19,8 @@ import (
 
 // ParamInfo holds JSON-schema style information for one parameter.
 type ParamInfo struct {
-	TypeName    string
-	Description string
+	Schema   Property // schema derived from the Go type, with the description
+	Optional bool     // pointers may be omitted by the model
 }
 
 // FunctionInfo stores everything needed to execute a registered function/method.
@@ -26,13 +30,45 @@ type FunctionInfo struct {
 	paramNames  []string             // names of *user* parameters (no receiver)
 	description string               // doc comment above function/method
 	paramInfos  map[string]ParamInfo // per-parameter metadata
+	firstParam  int                  // index of the first user parameter in the function type
+	takesCtx    bool                 // whether the first parameter after the receiver is a context.Context
 }
 
-// FunctionRegistry keeps a map of registered functions/methods.
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// FunctionRegistry keeps a map of registered functions/methods, by the tool
+// name exposed to the model.
 type FunctionRegistry struct {
+	mu        sync.RWMutex
 	functions map[string]FunctionInfo
 }
 
+// NamespaceSeparator joins a namespace and a tool name, see QualifiedName.
+// Most APIs don't allow dots in tool names.
+const NamespaceSeparator = "_"
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// QualifiedName returns the name of a tool in namespace, e.g. fs_ToolReadFile.
+func QualifiedName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + NamespaceSeparator + name
+}
+
+// checkName returns an error if name isn't a valid tool name or is taken.
+// Callers hold fr.mu.
+func (fr *FunctionRegistry) checkName(name string) error {
+	if !toolNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid tool name `%s`, expected at most 64 letters, digits, `_` or `-`", name)
+	}
+	if _, ok := fr.functions[name]; ok {
+		return fmt.Errorf("tool `%s` is already registered", name)
+	}
+	return nil
+}
+
 // NewFunctionRegistry returns an empty registry.
 func NewFunctionRegistry() *FunctionRegistry {
 	return &FunctionRegistry{functions: make(map[string]FunctionInfo)}
@@ -40,14 +76,56 @@ func NewFunctionRegistry() *FunctionRegistry {
 
 // ---------- registration helpers ------------------------------------------
 
-// RegisterFunction registers a standalone function.
-func (fr *FunctionRegistry) RegisterFunction(name string, fn any) error {
-	return fr.register(name, fn, nil, nil)
+// ParamDoc names and describes a parameter.
+type ParamDoc struct {
+	Name        string
+	Description string
+}
+
+// FuncDocs supplies the description and parameters of a function manually,
+// instead of reading them from its source.
+type FuncDocs struct {
+	Description string
+	// Params are in the order of the function parameters, without the
+	// context.Context.
+	Params []ParamDoc
+}
+
+// RegisterFunction registers a standalone function. Parameter names and
+// descriptions come from docs if given, otherwise from the declaration of
+// the function, or the literal of a closure, if its source is at hand.
+func (fr *FunctionRegistry) RegisterFunction(name string, fn any, docs FuncDocs) error {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func {
+		return fmt.Errorf("%s is not a function", name)
+	}
+	if len(docs.Params) == 0 {
+		pkgPath, funcName := splitFuncName(runtime.FuncForPC(fv.Pointer()).Name())
+		if generated, ok := lookupFuncDocs(pkgPath, funcName); ok {
+			docs.Params = generated.Params
+			if docs.Description == "" {
+				docs.Description = generated.Description
+			}
+		} else if funcType, doc := findFuncSource(fv); funcType != nil {
+			source := FuncDocsFromSource(funcType, doc)
+			docs.Params = source.Params
+			if docs.Description == "" {
+				docs.Description = source.Description
+			}
+		}
+	}
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if err := fr.checkName(name); err != nil {
+		return err
+	}
+	return fr.register(name, fn, nil, docs)
 }
 
-// RegisterMethods registers every exported method on 'instance'.
+// RegisterMethods registers every exported method on 'instance', named
+// QualifiedName(namespace, method), and returns the registered names.
 // Works for both value and pointer receivers.
-func (fr *FunctionRegistry) RegisterMethods(instance any) ([]string, error) {
+func (fr *FunctionRegistry) RegisterMethods(instance any, namespace string) ([]string, error) {
 	val := reflect.ValueOf(instance)
 	typ := val.Type()
 	if typ.Kind() == reflect.Pointer {
@@ -56,93 +134,184 @@ func (fr *FunctionRegistry) RegisterMethods(instance any) ([]string, error) {
 	if typ.Kind() != reflect.Struct {
 		return nil, fmt.Errorf("RegisterMethods: expected *struct or struct, got %s", typ.Kind())
 	}
-
-	// 1. Load package that contains the type.
-	cfg := packages.Config{
-		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes,
-	}
-	pkgs, err := packages.Load(&cfg, typ.PkgPath())
+	fr.mu.RLock()
+	err := fr.checkMethodNames(instance, namespace)
+	fr.mu.RUnlock()
 	if err != nil {
-		return nil, fmt.Errorf("packages.Load: %w", err)
-	}
-	if len(pkgs) != 1 {
-		return nil, fmt.Errorf("expected 1 package for %s, got %d", typ.PkgPath(), len(pkgs))
+		return nil, err
 	}
-	pkg := pkgs[0]
 
-	// 2. Build map: methodName -> *ast.FuncDecl
+	// 0. Prefer the metadata generated by apc-toolgen.
+	if methodDocs, ok := generatedMethodDocs(instance, typ); ok {
+		names, err := fr.registerMethodSet(instance, namespace, methodDocs)
+		if err == nil {
+			return names, nil
+		}
+		logger.Warning("[RegisterMethods] Generated metadata of %s doesn't match: %v", typ.Name(), err)
+	}
 
-	scope := pkg.Types.Scope().Lookup(typ.Name())
-	if scope == nil {
-		return nil, fmt.Errorf("type `%s` not found in package `%s`", typ.Name(), pkg)
+	// 1. Otherwise read the docs from the source, without holding fr.mu as
+	// loading it is slow.
+	methodDocs, err := sourceMethodDocs(typ)
+	if err != nil {
+		return nil, err
 	}
-	// named := scope.Type().(*types.Named)
+	return fr.registerMethodSet(instance, namespace, methodDocs)
+}
 
-	methodDecls := make(map[string]*ast.FuncDecl)
-	for _, file := range pkg.Syntax {
-		for _, d := range file.Decls {
-			fn, ok := d.(*ast.FuncDecl)
-			if ok && fn.Name.IsExported() && fn.Recv != nil {
-				if recvType(fn.Recv) == "*"+typ.Name() || recvType(fn.Recv) == typ.Name() {
-					methodDecls[fn.Name.Name] = fn
-				}
-			}
+// checkMethodNames returns an error if the name of any exported method of
+// instance isn't valid or is taken. Callers hold fr.mu.
+func (fr *FunctionRegistry) checkMethodNames(instance any, namespace string) error {
+	instanceType := reflect.TypeOf(instance)
+	for i := range instanceType.NumMethod() {
+		if err := fr.checkName(QualifiedName(namespace, instanceType.Method(i).Name)); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// registerMethodSet registers the exported methods of instance with their
+// docs in methodDocs, by method name, skipping those without. Method sets are
+// registered entirely or not at all.
+func (fr *FunctionRegistry) registerMethodSet(instance any, namespace string, methodDocs map[string]FuncDocs) ([]string, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if err := fr.checkMethodNames(instance, namespace); err != nil {
+		return nil, err
+	}
 
-	// 3. Register each method found via reflection.
+	val := reflect.ValueOf(instance)
+	instanceType := reflect.TypeOf(instance)
 	var methodNames []string
-	logger.Debug("%d", reflect.TypeOf(instance).NumMethod())
-	for i := 0; i < reflect.TypeOf(instance).NumMethod(); i++ {
-		method := reflect.TypeOf(instance).Method(i)
-		decl, ok := methodDecls[method.Name]
+	for i := range instanceType.NumMethod() {
+		method := instanceType.Method(i)
+		docs, ok := methodDocs[method.Name]
 		if !ok {
-			logger.Warning("AST is missing")
 			// AST missing for this method (shouldn’t happen in normal builds)
+			logger.Warning("[RegisterMethods] No declaration found for %s", method.Name)
 			continue
 		}
-		if err := fr.register(method.Name, method.Func.Interface(), decl, &val); err != nil {
+		name := QualifiedName(namespace, method.Name)
+		if err := fr.register(name, method.Func.Interface(), &val, docs); err != nil {
+			for _, registered := range methodNames {
+				delete(fr.functions, registered)
+			}
 			return nil, fmt.Errorf("method %s: %w", method.Name, err)
 		}
-		methodNames = append(methodNames, method.Name)
+		methodNames = append(methodNames, name)
 	}
 	logger.PrintV(methodNames)
 	return methodNames, nil
 }
 
+// generatedMethodDocs returns the docs of the exported methods of instance
+// from the metadata generated for its type, if there is metadata for every
+// one.
+func generatedMethodDocs(instance any, typ reflect.Type) (map[string]FuncDocs, bool) {
+	instanceType := reflect.TypeOf(instance)
+	methodDocs := make(map[string]FuncDocs, instanceType.NumMethod())
+	for i := range instanceType.NumMethod() {
+		name := instanceType.Method(i).Name
+		docs, ok := lookupFuncDocs(typ.PkgPath(), typ.Name()+"."+name)
+		if !ok {
+			return nil, false
+		}
+		methodDocs[name] = docs
+	}
+	return methodDocs, true
+}
+
+// loadPackages is packages.Load, replaced in tests.
+var loadPackages = packages.Load
+
+// sourceMethodDocs returns the docs of the exported methods declared on typ,
+// read from the source of its package.
+func sourceMethodDocs(typ reflect.Type) (map[string]FuncDocs, error) {
+	cfg := packages.Config{
+		// no NeedTypes: type checking would need every dependency loaded too
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax,
+	}
+	pkgs, err := loadPackages(&cfg, typ.PkgPath())
+	if err != nil {
+		return nil, fmt.Errorf("packages.Load: %w; without the source, generate the tool metadata with apc-toolgen", err)
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected 1 package for %s, got %d", typ.PkgPath(), len(pkgs))
+	}
+	pkg := pkgs[0]
+
+	typeFound := false
+	methodDocs := make(map[string]FuncDocs)
+	for _, file := range pkg.Syntax {
+		for _, d := range file.Decls {
+			switch decl := d.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if ts, ok := spec.(*ast.TypeSpec); ok && ts.Name.Name == typ.Name() {
+						typeFound = true
+					}
+				}
+			case *ast.FuncDecl:
+				if decl.Name.IsExported() && decl.Recv != nil {
+					if recvType(decl.Recv) == "*"+typ.Name() || recvType(decl.Recv) == typ.Name() {
+						methodDocs[decl.Name.Name] = FuncDocsFromSource(decl.Type, decl.Doc.Text())
+					}
+				}
+			}
+		}
+	}
+	if !typeFound {
+		return nil, fmt.Errorf("type `%s` not found in package `%s`", typ.Name(), pkg)
+	}
+	return methodDocs, nil
+}
+
 // register is the internal helper for both standalone functions and methods.
-func (fr *FunctionRegistry) register(name string, fn any, decl *ast.FuncDecl, receiver *reflect.Value) error {
+// Callers hold fr.mu and have checked the name.
+func (fr *FunctionRegistry) register(name string, fn any, receiver *reflect.Value, docs FuncDocs) error {
 	fv := reflect.ValueOf(fn)
 	if fv.Kind() != reflect.Func {
 		return fmt.Errorf("%s is not a function", name)
 	}
 	ft := fv.Type()
 
-	// Collect parameter information.
+	// method.Func takes the receiver as its first parameter
+	firstParam := 0
+	if receiver != nil {
+		firstParam = 1
+	}
+	// a leading context.Context is passed by ExecFunc, not by the model
+	takesCtx := ft.NumIn() > firstParam && ft.In(firstParam) == contextType
+	if takesCtx {
+		firstParam += 1
+	}
+
+	numParams := ft.NumIn() - firstParam
+	desc := docs.Description
+
+	// Collect parameter names and descriptions: given by docs, or generated
+	// without docs, e.g. if the source isn't at hand.
 	var paramNames []string
-	paramInfos := make(map[string]ParamInfo)
-	if decl != nil { // method
-		for _, field := range decl.Type.Params.List {
-			for _, ident := range field.Names {
-				paramNames = append(paramNames, ident.Name)
-				paramInfos[ident.Name] = ParamInfo{
-					TypeName: mapGoTypeToJSONSchema(field.Type.(*ast.Ident).Name),
-				}
-			}
+	paramDocs := make(map[string]string)
+	if len(docs.Params) > 0 {
+		if len(docs.Params) != numParams {
+			return fmt.Errorf("%s: expected %d parameter docs, got %d", name, numParams, len(docs.Params))
 		}
-	} else { // standalone function – simple reflection fallback
-		for i := 0; i < ft.NumIn(); i++ {
-			argName := fmt.Sprintf("arg%d", i)
-			paramNames = append(paramNames, argName)
-			paramInfos[argName] = ParamInfo{
-				TypeName: mapGoTypeToJSONSchema(ft.In(i).String()),
-			}
+		for _, param := range docs.Params {
+			paramNames = append(paramNames, param.Name)
+			paramDocs[param.Name] = param.Description
+		}
+	} else {
+		for i := range numParams {
+			paramNames = append(paramNames, fmt.Sprintf("arg%d", i))
 		}
 	}
 
-	desc := ""
-	if decl != nil && decl.Doc != nil {
-		desc = strings.TrimSpace(decl.Doc.Text())
+	paramInfos := make(map[string]ParamInfo)
+	for i, paramName := range paramNames {
+		schema, optional := paramSchema(ft.In(firstParam+i), paramDocs[paramName])
+		paramInfos[paramName] = ParamInfo{Schema: schema, Optional: optional}
 	}
 
 	receiverVal := reflect.Value{} // zero for standalone functions
@@ -156,6 +325,8 @@ func (fr *FunctionRegistry) register(name string, fn any, decl *ast.FuncDecl, re
 		paramNames:  paramNames,
 		description: desc,
 		paramInfos:  paramInfos,
+		firstParam:  firstParam,
+		takesCtx:    takesCtx,
 	}
 	return nil
 }
@@ -163,9 +334,14 @@ func (fr *FunctionRegistry) register(name string, fn any, decl *ast.FuncDecl, re
 // ---------- execution -------------------------------------------------------
 
 // ExecFunc executes a registered function/method with named parameters.
-// Methods receive their receiver automatically.
-func (fr *FunctionRegistry) ExecFunc(funcName string, args map[string]any) (any, error) {
+// Methods receive their receiver automatically, functions taking a
+// context.Context receive ctx. ExecFunc returns the cause of ctx once it's
+// done, even if the function ignores ctx and keeps running. A panic of the
+// function is returned as an error.
+func (fr *FunctionRegistry) ExecFunc(ctx context.Context, funcName string, args map[string]any) (any, error) {
+	fr.mu.RLock()
 	info, ok := fr.functions[funcName]
+	fr.mu.RUnlock()
 	if !ok {
 		return nil, fmt.Errorf("function %s not found", funcName)
 	}
@@ -179,41 +355,65 @@ func (fr *FunctionRegistry) ExecFunc(funcName string, args map[string]any) (any,
 	}
 
 	// Prepare only the user parameters (excluding receiver).
-	prepared, err := prepareArguments(ft, info.paramNames, args)
+	prepared, err := prepareArguments(ft, info.firstParam, info.paramNames, args)
 	if err != nil {
 		return nil, err
 	}
 
-	// Prepend receiver for methods.
-	callArgs := make([]reflect.Value, 0, len(prepared)+1)
+	// Prepend receiver for methods, then the context.
+	callArgs := make([]reflect.Value, 0, len(prepared)+2)
 	if info.receiver.IsValid() {
 		callArgs = append(callArgs, info.receiver)
 	}
+	if info.takesCtx {
+		callArgs = append(callArgs, reflect.ValueOf(&ctx).Elem())
+	}
 	callArgs = append(callArgs, prepared...)
 
-	results := reflect.ValueOf(info.fn).Call(callArgs)
-	if !results[1].IsNil() {
-		return nil, results[1].Interface().(error)
+	type result struct {
+		value any
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: fmt.Errorf("tool %s panicked: %v", funcName, r)}
+			}
+		}()
+		results := reflect.ValueOf(info.fn).Call(callArgs)
+		if !results[1].IsNil() {
+			done <- result{err: results[1].Interface().(error)}
+			return
+		}
+		done <- result{value: results[0].Interface()}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	case r := <-done:
+		return r.value, r.err
 	}
-	return results[0].Interface(), nil
 }
 
 // prepareArguments converts the user-provided map into a reflect.Value slice.
-func prepareArguments(fnType reflect.Type, paramNames []string, args map[string]any) ([]reflect.Value, error) {
+// firstParam is the index of the first user parameter in fnType.
+func prepareArguments(fnType reflect.Type, firstParam int, paramNames []string, args map[string]any) ([]reflect.Value, error) {
 	out := make([]reflect.Value, len(paramNames))
 	for i, name := range paramNames {
+		// Receiver and context are handled by ExecFunc, so we skip them.
+		argType := fnType.In(firstParam + i)
+
 		val, ok := args[name]
 		if !ok {
+			// optional parameters are nil pointers if omitted
+			if argType.Kind() == reflect.Pointer {
+				out[i] = reflect.Zero(argType)
+				continue
+			}
 			return nil, fmt.Errorf("missing argument %q", name)
 		}
 
-		var (
-			argType reflect.Type
-			err     error
-		)
-		// Receiver (index 0) is handled by ExecFunc, so we always skip it.
-		argType = fnType.In(i + 1)
-
 		converted, err := convertArg(val, argType)
 		if err != nil {
 			return nil, fmt.Errorf("argument %q: %w", name, err)
@@ -223,59 +423,49 @@ func prepareArguments(fnType reflect.Type, paramNames []string, args map[string]
 	return out, nil
 }
 
-// convertArg converts an interface{} value to the required reflect.Value.
+// convertArg converts a decoded JSON value to the required reflect.Value, the
+// way encoding/json would decode it into the target type. Models often quote
+// numbers and booleans, or send numbers for strings; both are accepted.
 func convertArg(v any, target reflect.Type) (reflect.Value, error) {
 	switch target.Kind() {
 	case reflect.String:
-		return reflect.ValueOf(fmt.Sprint(v)), nil
-	case reflect.Int:
-		s := fmt.Sprint(v)
-		i, err := strconv.Atoi(s)
-		if err != nil {
-			return reflect.Value{}, fmt.Errorf("cannot parse %q as int", s)
+		if _, ok := v.(string); !ok && v != nil {
+			v = fmt.Sprint(v)
 		}
-		return reflect.ValueOf(i), nil
-	case reflect.Float64:
-		s := fmt.Sprint(v)
-		f, err := strconv.ParseFloat(s, 64)
-		if err != nil {
-			return reflect.Value{}, fmt.Errorf("cannot parse %q as float", s)
-		}
-		return reflect.ValueOf(f), nil
-	case reflect.Bool:
-		switch t := v.(type) {
-		case bool:
-			return reflect.ValueOf(t), nil
-		case string:
-			b, err := strconv.ParseBool(t)
-			if err != nil {
-				return reflect.Value{}, fmt.Errorf("cannot parse %q as bool", t)
+	case reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if s, ok := v.(string); ok {
+			out := reflect.New(target)
+			if err := json.Unmarshal([]byte(strings.TrimSpace(s)), out.Interface()); err != nil {
+				return reflect.Value{}, fmt.Errorf("cannot parse %q as %s", s, target)
 			}
-			return reflect.ValueOf(b), nil
-		default:
-			return reflect.Value{}, fmt.Errorf("unsupported bool value type %T", v)
+			return out.Elem(), nil
 		}
-	default:
-		return reflect.Value{}, fmt.Errorf("unsupported type %s", target)
 	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	out := reflect.New(target)
+	if err := json.Unmarshal(data, out.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("cannot decode %s as %s: %w", data, target, err)
+	}
+	return out.Elem(), nil
 }
 
 // ---------- misc -----------------------------------------------------------
 
-// mapGoTypeToJSONSchema maps Go type strings to JSON schema types.
-func mapGoTypeToJSONSchema(goType string) string {
-	switch goType {
-	case "string":
-		return "string"
-	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
-		return "integer"
-	case "float32", "float64":
-		return "number"
-	case "bool":
-		return "boolean"
-	default:
-		return "string"
+// splitFuncName splits the qualified name of a function as reported by the
+// runtime, e.g. github.com/x/y.Func, into its package path and name.
+func splitFuncName(qualified string) (string, string) {
+	slash := strings.LastIndex(qualified, "/")
+	dot := strings.Index(qualified[slash+1:], ".")
+	if dot < 0 {
+		return "", qualified
 	}
+	return qualified[:slash+1+dot], qualified[slash+1+dot+1:]
 }
 
 // recvType returns the string form of a method receiver.
@@ -293,5 +483,3 @@ func recvType(r *ast.FieldList) string {
 	}
 	return ""
 }
-
-var funcRegistry = NewFunctionRegistry()
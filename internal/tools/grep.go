@@ -0,0 +1,185 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// grepOptions are the options of ToolGrepText, shared by rg and the Go
+// fallback.
+type grepOptions struct {
+	pattern       string
+	includeHidden bool
+	caseSensitive bool
+	maxCount      int // matching lines per file, 0 for no limit
+	contextLines  int
+}
+
+// grepRg searches dirPath with ripgrep.
+func (fs *FS) grepRg(ctx context.Context, rgPath string, opts grepOptions, dirPath string) (string, error) {
+	var args []string
+
+	// flags
+	if opts.includeHidden {
+		args = append(args, "-.")
+	}
+	if opts.caseSensitive {
+		args = append(args, "-s")
+	} else {
+		args = append(args, "-i")
+	}
+	if opts.maxCount > 0 {
+		args = append(args, "-m", strconv.Itoa(opts.maxCount))
+	}
+	if opts.contextLines > 0 {
+		args = append(args, "-C", strconv.Itoa(opts.contextLines))
+	}
+
+	// sandbox
+	for _, pattern := range fs.denyPatterns() {
+		// a negated glob would make rg search only the files matching it
+		if !strings.HasPrefix(pattern, "!") {
+			args = append(args, "--glob", "!"+pattern)
+		}
+	}
+	if fs.Sandbox.IncludeIgnored {
+		args = append(args, "--no-ignore")
+	} else {
+		args = append(args, "--no-require-git")
+	}
+	if limit := fs.maxFileSize(); limit > 0 {
+		args = append(args, "--max-filesize", strconv.FormatInt(limit, 10))
+	}
+
+	// final arg is the directory to search
+	args = append(args, "--", opts.pattern, dirPath)
+
+	cmd := exec.CommandContext(ctx, rgPath, args...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			// https://manpages.debian.org/unstable/ripgrep/rg.1.en.html#EXIT_STATUS
+			if exitErr.ExitCode() == 1 {
+				return "No match", nil
+			}
+			return "", err
+		}
+		return "", err
+	}
+
+	return string(output), nil
+}
+
+// grepGo searches dirPath like grepRg does when ripgrep isn't installed, with
+// the same output as rg writing to a pipe: `path:line` for matching lines,
+// `path-line` for context lines and `--` between non adjacent groups.
+// Hidden, denied, ignored, binary and too large files are skipped, symlinks
+// are not followed.
+func (fs *FS) grepGo(ctx context.Context, opts grepOptions, dirPath string) (string, error) {
+	expr := opts.pattern
+	if !opts.caseSensitive {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", fmt.Errorf("[ToolGrepText] invalid regex `%s`: %w", opts.pattern, err)
+	}
+	root, err := fs.root()
+	if err != nil {
+		return "", fmt.Errorf("[ToolGrepText] %w", err)
+	}
+	walkRoot, err := filepath.EvalSymlinks(dirPath)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	err = filepath.WalkDir(walkRoot, func(path string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return nil // unreadable entries are skipped, like rg does
+		}
+		if path != walkRoot {
+			rel, _ := filepath.Rel(root, path)
+			hidden := !opts.includeHidden && strings.HasPrefix(d.Name(), ".")
+			if hidden || fs.checkAccess(root, rel, d.IsDir()) != nil {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || fs.checkSize(path, info.Size()) != nil {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(data, 0) >= 0 { // binary
+			return nil
+		}
+		rel, _ := filepath.Rel(walkRoot, path)
+		grepLines(&out, filepath.Join(dirPath, rel), data, re, opts)
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", context.Cause(ctx)
+		}
+		return "", err
+	}
+	if out.Len() == 0 {
+		return "No match", nil
+	}
+	return out.String(), nil
+}
+
+// grepLines writes the matching lines of a file, with their context, to out.
+func grepLines(out *strings.Builder, name string, data []byte, re *regexp.Regexp, opts grepOptions) {
+	lines := strings.Split(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var matches []int
+	for i, line := range lines {
+		if re.MatchString(line) {
+			matches = append(matches, i)
+			if opts.maxCount > 0 && len(matches) == opts.maxCount {
+				break
+			}
+		}
+	}
+
+	printed := -1 // last printed line
+	for n, match := range matches {
+		start := max(match-opts.contextLines, printed+1)
+		if opts.contextLines > 0 && start > printed+1 && (printed >= 0 || out.Len() > 0) {
+			out.WriteString("--\n")
+		}
+		end := min(match+opts.contextLines, len(lines)-1)
+		// stop the trailing context at the next match, printed by its group
+		if n+1 < len(matches) {
+			end = min(end, matches[n+1]-1)
+		}
+		for i := start; i <= end; i++ {
+			sep := "-"
+			if i == match {
+				sep = ":"
+			}
+			out.WriteString(name + sep + lines[i] + "\n")
+		}
+		printed = end
+	}
+}
@@ -0,0 +1,89 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGrepGo(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt":         "one\nTODO first\nthree\nfour\nfive\nsix\ntodo second\neight\n",
+		"sub/b.txt":     "nothing here\n",
+		".hidden.txt":   "TODO hidden\n",
+		"ignored.log":   "TODO ignored\n",
+		".gitignore":    "*.log\n",
+		"bin.dat":       "TODO\x00binary",
+		"certs/key.pem": "TODO secret",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := &FS{WD: dir}
+	root, err := fs.root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := filepath.Join(root, "a.txt")
+
+	for _, tc := range []struct {
+		name     string
+		opts     grepOptions
+		expected string
+	}{
+		{
+			name:     "case insensitive",
+			opts:     grepOptions{pattern: "todo"},
+			expected: a + ":TODO first\n" + a + ":todo second\n",
+		},
+		{
+			name:     "case sensitive",
+			opts:     grepOptions{pattern: "todo", caseSensitive: true},
+			expected: a + ":todo second\n",
+		},
+		{
+			name:     "max count",
+			opts:     grepOptions{pattern: "todo", maxCount: 1},
+			expected: a + ":TODO first\n",
+		},
+		{
+			name: "context",
+			opts: grepOptions{pattern: "todo", contextLines: 1},
+			expected: a + "-one\n" + a + ":TODO first\n" + a + "-three\n" +
+				"--\n" + a + "-six\n" + a + ":todo second\n" + a + "-eight\n",
+		},
+		{
+			name:     "regex",
+			opts:     grepOptions{pattern: `^f\w+e$`, caseSensitive: true},
+			expected: a + ":five\n",
+		},
+		{
+			name:     "hidden",
+			opts:     grepOptions{pattern: "hidden", includeHidden: true},
+			expected: filepath.Join(root, ".hidden.txt") + ":TODO hidden\n",
+		},
+		{
+			name:     "no match",
+			opts:     grepOptions{pattern: "ignored|secret|binary"},
+			expected: "No match",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := fs.grepGo(context.Background(), tc.opts, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, got)
+			}
+		})
+	}
+}
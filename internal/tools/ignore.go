@@ -0,0 +1,157 @@
+package tools
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ignorePattern is a single .gitignore style pattern.
+type ignorePattern struct {
+	glob     string
+	negate   bool
+	dirOnly  bool
+	anchored bool // matched against the whole relative path, else the name
+}
+
+// ignoreList is a list of .gitignore style patterns relative to a directory,
+// the last matching pattern decides.
+type ignoreList []ignorePattern
+
+func parseIgnoreList(lines []string) ignoreList {
+	var list ignoreList
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if p.negate = strings.HasPrefix(line, "!"); p.negate {
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`) // escaped leading ! or #
+		if p.dirOnly = strings.HasSuffix(line, "/"); p.dirOnly {
+			line = strings.TrimRight(line, "/")
+		}
+		p.anchored = strings.Contains(line, "/")
+		p.glob = strings.TrimPrefix(line, "/")
+		if p.glob != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// match reports whether a pattern matches rel, a slash separated path
+// relative to the directory of the list, and if so whether it's excluded.
+func (l ignoreList) match(rel string, isDir bool) (matched bool, excluded bool) {
+	for _, p := range l {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		name := rel
+		if !p.anchored {
+			name = path.Base(rel)
+		}
+		if matchGlob(p.glob, name) {
+			matched, excluded = true, !p.negate
+		}
+	}
+	return matched, excluded
+}
+
+// matchGlob matches a slash separated name against a glob where `**`
+// segments match any number of path segments.
+func matchGlob(glob string, name string) bool {
+	return matchSegments(strings.Split(glob, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(globs []string, names []string) bool {
+	for len(globs) > 0 {
+		if globs[0] == "**" {
+			for i := 0; i <= len(names); i++ {
+				if matchSegments(globs[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, err := path.Match(globs[0], names[0]); err != nil || !ok {
+			return false
+		}
+		globs, names = globs[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// gitignoreFile is a parsed .gitignore file.
+type gitignoreFile struct {
+	modTime time.Time
+	list    ignoreList
+}
+
+// gitignores caches the .gitignore files of a tree, reloading the ones that
+// changed.
+type gitignores struct {
+	mu    sync.Mutex
+	files map[string]gitignoreFile // by directory
+}
+
+// load returns the patterns of the .gitignore file in dir, nil if there is
+// none.
+func (g *gitignores) load(dir string) ignoreList {
+	filePath := filepath.Join(dir, ".gitignore")
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if cached, ok := g.files[dir]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.list
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if g.files == nil {
+		g.files = make(map[string]gitignoreFile)
+	}
+	list := parseIgnoreList(lines)
+	g.files[dir] = gitignoreFile{modTime: info.ModTime(), list: list}
+	return list
+}
+
+// ignored reports whether rel, a slash separated path relative to root, is
+// ignored by the .gitignore files of root and of the directories above rel.
+// Deeper files take precedence. Ancestors of rel are not checked.
+func (g *gitignores) ignored(root string, rel string, isDir bool) bool {
+	ignored := false
+	dir := ""
+	for {
+		if list := g.load(filepath.Join(root, filepath.FromSlash(dir))); list != nil {
+			sub := strings.TrimPrefix(rel, dir+"/")
+			if matched, excluded := list.match(sub, isDir); matched {
+				ignored = excluded
+			}
+		}
+		next, _, ok := strings.Cut(strings.TrimPrefix(rel, dir+"/"), "/")
+		if !ok {
+			return ignored
+		}
+		dir = path.Join(dir, next)
+	}
+}
@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"go/ast"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Metadata is the source information of the tools of a package, generated at
+// build time by cmd/apc-toolgen, so registration doesn't need the source at
+// runtime.
+type Metadata struct {
+	// Funcs holds the docs of functions by name, and of methods by
+	// `Type.Method`.
+	Funcs map[string]FuncDocs
+	// FieldDocs holds the doc comments of struct fields by `Type.Field`. The
+	// field docs of a package with metadata are never read from source.
+	FieldDocs map[string]string
+}
+
+var (
+	metadataMu sync.RWMutex
+	// package path -> metadata
+	metadata = make(map[string]Metadata)
+)
+
+// RegisterMetadata makes the generated metadata of the package at pkgPath
+// available to the registry, which prefers it over reading the source. The
+// metadata of several calls for a package, e.g. from several generated files,
+// is merged.
+func RegisterMetadata(pkgPath string, md Metadata) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	merged := metadata[pkgPath]
+	if merged.Funcs == nil {
+		merged.Funcs = make(map[string]FuncDocs)
+	}
+	if merged.FieldDocs == nil {
+		merged.FieldDocs = make(map[string]string)
+	}
+	maps.Copy(merged.Funcs, md.Funcs)
+	maps.Copy(merged.FieldDocs, md.FieldDocs)
+	metadata[pkgPath] = merged
+}
+
+func lookupFuncDocs(pkgPath string, name string) (FuncDocs, bool) {
+	metadataMu.RLock()
+	defer metadataMu.RUnlock()
+	docs, ok := metadata[pkgPath].Funcs[name]
+	return docs, ok
+}
+
+func lookupFieldDocs(pkgPath string) (map[string]string, bool) {
+	metadataMu.RLock()
+	defer metadataMu.RUnlock()
+	md, ok := metadata[pkgPath]
+	return md.FieldDocs, ok
+}
+
+// FuncDocsFromSource returns the docs of a function declared with funcType
+// and the doc comment doc: the parameter names, without a leading
+// context.Context, described by `name: description` lines of doc. Unnamed
+// parameters have no docs, they're named arg0, arg1... on registration.
+func FuncDocsFromSource(funcType *ast.FuncType, doc string) FuncDocs {
+	docs := FuncDocs{Description: strings.TrimSpace(doc)}
+	var paramNames []string
+	for i, field := range funcType.Params.List {
+		if i == 0 && isContextType(field.Type) && len(field.Names) <= 1 {
+			continue
+		}
+		if len(field.Names) == 0 {
+			return docs
+		}
+		for _, ident := range field.Names {
+			paramNames = append(paramNames, ident.Name)
+		}
+	}
+	if slices.Contains(paramNames, "_") {
+		return docs
+	}
+	paramDocs := parseParamDocs(docs.Description, paramNames)
+	for _, name := range paramNames {
+		docs.Params = append(docs.Params, ParamDoc{Name: name, Description: paramDocs[name]})
+	}
+	return docs
+}
+
+func isContextType(expr ast.Expr) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "context" && sel.Sel.Name == "Context"
+}
@@ -0,0 +1,80 @@
+package tools
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// metadataBox lives in a test file, which isn't loaded from source at
+// runtime, so its tools need generated metadata.
+type metadataBox struct{}
+
+func (m *metadataBox) Greet(name string) (string, error) {
+	return "hello " + name, nil
+}
+
+func TestRegisterMethods_Metadata(t *testing.T) {
+	fr := NewFunctionRegistry()
+	if _, err := RegisterMethods(fr, &metadataBox{}, ""); err == nil {
+		t.Fatal("expected an error without source and metadata")
+	}
+
+	RegisterMetadata(reflect.TypeOf(metadataBox{}).PkgPath(), Metadata{
+		Funcs: map[string]FuncDocs{
+			"metadataBox.Greet": {
+				Description: "Greet greets.\n\nname: who to greet",
+				Params:      []ParamDoc{{Name: "name", Description: "who to greet"}},
+			},
+		},
+	})
+	tools, err := RegisterMethods(fr, &metadataBox{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tools) != 1 || tools[0].Function.Description != "Greet greets.\n\nname: who to greet" ||
+		tools[0].Function.Parameters.Properties["name"].Description != "who to greet" {
+		t.Errorf("unexpected tools: %+v", tools)
+	}
+}
+
+func TestBuiltinTools_Metadata(t *testing.T) {
+	load := loadPackages
+	t.Cleanup(func() { loadPackages = load })
+	loadPackages = func(_ *packages.Config, patterns ...string) ([]*packages.Package, error) {
+		t.Errorf("unexpected source load of %v", patterns)
+		return nil, errors.New("no source")
+	}
+
+	fr := NewFunctionRegistry()
+	dir := t.TempDir()
+	fsTools, err := GetFsTools(fr, dir, Sandbox{}, "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTools, _, err := GetFsWriteTools(fr, dir, Sandbox{}, "fswrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shellTools, err := GetShellTools(fr, dir, Sandbox{}, ShellConfig{}, "shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		tools    []Tool
+		instance any
+	}{
+		{fsTools, &FS{}},
+		{writeTools, &FSWrite{}},
+		{shellTools, &Shell{}},
+	} {
+		if n := reflect.TypeOf(tc.instance).NumMethod(); len(tc.tools) != n {
+			t.Errorf("expected %d tools for %T, got %d", n, tc.instance, len(tc.tools))
+		}
+	}
+	if desc := fr.functions["fs_ToolReadFile"].paramInfos["filePath"].Schema.Description; desc != "relative path to the CWD" {
+		t.Errorf("unexpected description of filePath: %q", desc)
+	}
+}
@@ -0,0 +1,262 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// sniffSize is the size of the start of a file looked at to tell text from
+// binary data.
+const sniffSize = 8 << 10
+
+// textEncoding is how the content of a file is decoded.
+type textEncoding int
+
+const (
+	encodingBinary textEncoding = iota
+	encodingUTF8
+	encodingUTF16
+)
+
+// sniffText tells the encoding of a file from head, its first bytes, and the
+// length of its byte order mark. UTF-16 needs a byte order mark; data with
+// NUL bytes or invalid UTF-8 is binary. Only head is checked, so that large
+// files don't have to be read whole.
+func sniffText(head []byte, complete bool) (textEncoding, int) {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}), bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return encodingUTF16, 2
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return sniffUTF8(head[3:], complete), 3
+	}
+	return sniffUTF8(head, complete), 0
+}
+
+func sniffUTF8(head []byte, complete bool) textEncoding {
+	if !complete {
+		// the last rune may be cut by the end of head
+		for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.FullRune(head[max(len(head)-utf8.UTFMax, 0):]); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+	if bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(head) {
+		return encodingBinary
+	}
+	return encodingUTF8
+}
+
+// decodeUTF16 decodes data, UTF-16 with a byte order mark, to UTF-8. It
+// reports false for an odd number of bytes.
+func decodeUTF16(data []byte) (string, bool) {
+	order := binary.ByteOrder(binary.LittleEndian)
+	if bytes.HasPrefix(data, []byte{0xFE, 0xFF}) {
+		order = binary.BigEndian
+	}
+	data = data[2:]
+	if len(data)%2 != 0 {
+		return "", false
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(units)), true
+}
+
+// readRange is the part of a text returned by ToolReadFile, either a range
+// of lines or the bytes from an offset on, cut to limit bytes. The text is
+// read as a stream, so only the part returned is held in memory.
+type readRange struct {
+	startLine   int // 1-based, 0 for the first line
+	endLine     int // inclusive, 0 for the last line
+	byOffset    bool
+	offset      int
+	lineNumbers bool
+	limit       int
+}
+
+func (r readRange) read(text *io.SectionReader) (string, error) {
+	if r.byOffset {
+		return r.readBytes(text)
+	}
+	return r.readLines(text)
+}
+
+func (r readRange) readLines(text *io.SectionReader) (string, error) {
+	if r.startLine < 0 || r.endLine < 0 {
+		return "", fmt.Errorf("startLine and endLine must be positive")
+	}
+	start := max(r.startLine, 1)
+	br := bufio.NewReader(text)
+
+	var sb strings.Builder
+	lines := 0     // read so far
+	offset := 0    // of the next line
+	lastShown := 0 // once the limit is hit, the last line shown; 0 otherwise
+	for {
+		if lastShown == 0 && r.endLine > 0 && lines >= max(r.endLine, start) {
+			break
+		}
+		// only lines shown are kept, and not past the limit
+		keep := 0
+		if lines+1 >= start && lastShown == 0 {
+			keep = r.limit + utf8.UTFMax
+		}
+		line, length, err := readLine(br, keep)
+		if err != nil {
+			return "", err
+		}
+		if length == 0 {
+			break
+		}
+		lines++
+		if keep == 0 {
+			offset += length
+			continue
+		}
+		if length <= len(line) {
+			formatted := r.format(lines, line)
+			if sb.Len()+len(formatted) <= r.limit {
+				sb.WriteString(formatted)
+				offset += length
+				continue
+			}
+		}
+		if sb.Len() > 0 {
+			// the lines left are counted for the note
+			lastShown = lines - 1
+			continue
+		}
+		// a single line over the limit is read on by offset
+		cut := runeStart(line, r.limit)
+		sb.WriteString(r.format(lines, line[:cut]))
+		return truncated(sb.String(), fmt.Sprintf("line %d is cut after %d bytes. Call ToolReadFile with offset %d to read on", lines, cut, offset+cut)), nil
+	}
+
+	if lines == 0 && start == 1 {
+		return "", nil
+	}
+	if start > lines {
+		return "", fmt.Errorf("startLine %d is past the end of the file, which has %d lines", start, lines)
+	}
+	if r.endLine > 0 && r.endLine < start {
+		return "", fmt.Errorf("endLine %d is before startLine %d", r.endLine, start)
+	}
+	if lastShown > 0 {
+		return truncated(sb.String(), fmt.Sprintf("showing lines %d-%d of %d. Call ToolReadFile with startLine %d to read on", start, lastShown, lines, lastShown+1)), nil
+	}
+	return sb.String(), nil
+}
+
+// readLine reads the next line of br, with its newline, and returns its
+// first keep bytes and its length. The length is 0 at the end of the text.
+func readLine(br *bufio.Reader, keep int) (string, int, error) {
+	var sb strings.Builder
+	length := 0
+	for {
+		chunk, err := br.ReadSlice('\n')
+		length += len(chunk)
+		if n := min(len(chunk), keep-sb.Len()); n > 0 {
+			sb.Write(chunk[:n])
+		}
+		switch {
+		case err == nil || errors.Is(err, io.EOF):
+			return sb.String(), length, nil
+		case !errors.Is(err, bufio.ErrBufferFull):
+			return "", 0, err
+		}
+	}
+}
+
+func (r readRange) readBytes(text *io.SectionReader) (string, error) {
+	size := int(text.Size())
+	if r.offset < 0 || r.offset > size {
+		return "", fmt.Errorf("offset %d is out of range, the file has %d bytes", r.offset, size)
+	}
+	// the limit and a rune on both ends
+	buf := make([]byte, min(r.limit+2*utf8.UTFMax, size-r.offset))
+	if _, err := text.ReadAt(buf, int64(r.offset)); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	start := r.offset
+	for start-r.offset < len(buf) && !utf8.RuneStart(buf[start-r.offset]) {
+		start++
+	}
+	rest := string(buf[start-r.offset:])
+	end := start + len(rest)
+	if size-start > r.limit {
+		end = start + runeStart(rest, r.limit)
+	}
+
+	chunk := rest[:end-start]
+	if r.lineNumbers {
+		newlines, err := countNewlines(io.NewSectionReader(text, 0, int64(start)))
+		if err != nil {
+			return "", err
+		}
+		var sb strings.Builder
+		for i, line := range splitLines(chunk) {
+			sb.WriteString(r.format(newlines+1+i, line))
+		}
+		chunk = sb.String()
+	}
+	if end < size {
+		return truncated(chunk, fmt.Sprintf("showing bytes %d-%d of %d. Call ToolReadFile with offset %d to read on", start, end-1, size, end)), nil
+	}
+	return chunk, nil
+}
+
+// countNewlines returns the number of newlines read from r.
+func countNewlines(r io.Reader) (int, error) {
+	buf := make([]byte, 32<<10)
+	count := 0
+	for {
+		n, err := r.Read(buf)
+		count += bytes.Count(buf[:n], []byte{'\n'})
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r readRange) format(n int, line string) string {
+	if r.lineNumbers {
+		return fmt.Sprintf("%6d\t%s", n, line)
+	}
+	return line
+}
+
+// runeStart returns the largest index of s up to limit at which a rune
+// starts, or the end of the first rune if that's past limit.
+func runeStart(s string, limit int) int {
+	if limit >= len(s) {
+		return len(s)
+	}
+	i := max(limit, 0)
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	if i == 0 {
+		_, size := utf8.DecodeRuneInString(s)
+		return size
+	}
+	return i
+}
+
+// truncated appends the truncation marker to content.
+func truncated(content string, note string) string {
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + "[truncated: " + note + "]"
+}
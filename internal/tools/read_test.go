@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToolReadFile_Ranges(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lines.txt": "l1\nl2\nl3\nl4\nl5\nl6\n",
+		"long.txt":  strings.Repeat("é", 20) + "\nend\n",
+		"image.png": "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"utf16.txt": "\xff\xfeh\x00i\x00\n\x00",
+		"huge.txt":  strings.Repeat("x", 5000) + "\nend\n",
+	}
+	var big strings.Builder
+	for i := range 100 {
+		fmt.Fprintf(&big, "line %03d\n", i+1)
+	}
+	files["big.txt"] = big.String()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// files over MaxFileSize are read in chunks too
+	fs := &FS{WD: dir, Sandbox: Sandbox{MaxReadSize: 12, MaxFileSize: 64}}
+	ptr := func(n int) *int { return &n }
+	yes := true
+
+	for _, tc := range []struct {
+		name        string
+		file        string
+		startLine   *int
+		endLine     *int
+		offset      *int
+		lineNumbers *bool
+		expected    string
+	}{
+		{name: "whole file cut at the limit", file: "lines.txt", expected: "l1\nl2\nl3\nl4\n[truncated: showing lines 1-4 of 6. Call ToolReadFile with startLine 5 to read on]"},
+		{name: "line range", file: "lines.txt", startLine: ptr(5), endLine: ptr(9), expected: "l5\nl6\n"},
+		{name: "line numbers", file: "lines.txt", startLine: ptr(2), endLine: ptr(2), lineNumbers: &yes, expected: "     2\tl2\n"},
+		{name: "offset", file: "lines.txt", offset: ptr(9), expected: "l4\nl5\nl6\n"},
+		{name: "offset cut", file: "lines.txt", offset: ptr(3), expected: "l2\nl3\nl4\nl5\n[truncated: showing bytes 3-14 of 18. Call ToolReadFile with offset 15 to read on]"},
+		{name: "long line", file: "long.txt", expected: "éééééé\n[truncated: line 1 is cut after 12 bytes. Call ToolReadFile with offset 12 to read on]"},
+		{name: "offset inside a rune", file: "long.txt", offset: ptr(37), expected: "é\nend\n"},
+		{name: "binary", file: "image.png", expected: "`image.png` is not a text file (image/png, 16 bytes), its content is not shown."},
+		{name: "utf-16", file: "utf16.txt", expected: "hi\n"},
+		{name: "large file line range", file: "big.txt", startLine: ptr(99), expected: "line 099\n[truncated: showing lines 99-99 of 100. Call ToolReadFile with startLine 100 to read on]"},
+		{name: "large file offset", file: "big.txt", offset: ptr(891), lineNumbers: &yes, expected: "   100\tline 100\n"},
+		{name: "line past the read buffer", file: "huge.txt", startLine: ptr(2), expected: "end\n"},
+		{name: "offset past the read buffer", file: "huge.txt", offset: ptr(4998), expected: "xx\nend\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := fs.ToolReadFile(tc.file, tc.startLine, tc.endLine, tc.offset, tc.lineNumbers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	if _, err := fs.ToolReadFile("lines.txt", ptr(7), nil, nil, nil); err == nil {
+		t.Error("expected a start line past the end to fail")
+	}
+	if _, err := fs.ToolReadFile("lines.txt", ptr(1), nil, ptr(0), nil); err == nil {
+		t.Error("expected offset and startLine to be exclusive")
+	}
+}
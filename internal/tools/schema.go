@@ -0,0 +1,238 @@
+package tools
+
+import (
+	"go/ast"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// enumAnnotation marks the allowed values in a doc comment, e.g.
+// `unit: temperature unit [enum: celsius, fahrenheit]`.
+var enumAnnotation = regexp.MustCompile(`\s*\[enum:\s*([^\]]*)\]`)
+
+// parseEnum strips the enum annotation from desc and returns its values.
+func parseEnum(desc string) (string, []string) {
+	match := enumAnnotation.FindStringSubmatch(desc)
+	if match == nil {
+		return desc, nil
+	}
+	return strings.TrimSpace(enumAnnotation.ReplaceAllString(desc, "")), splitEnum(match[1])
+}
+
+func splitEnum(values string) []string {
+	var enum []string
+	for _, value := range strings.Split(values, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			enum = append(enum, value)
+		}
+	}
+	return enum
+}
+
+// parseParamDocs returns the parameter descriptions of a doc comment, given as
+// `name: description` lines.
+func parseParamDocs(doc string, paramNames []string) map[string]string {
+	descs := make(map[string]string)
+	for _, line := range strings.Split(doc, "\n") {
+		name, desc, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok && slices.Contains(paramNames, name) {
+			descs[name] = strings.TrimSpace(desc)
+		}
+	}
+	return descs
+}
+
+// paramSchema returns the schema of a parameter and whether it's optional,
+// which is the case for pointers.
+func paramSchema(t reflect.Type, desc string) (Property, bool) {
+	desc, enum := parseEnum(desc)
+	schema := schemaFor(t, map[reflect.Type]bool{})
+	schema.Description = desc
+	setEnum(&schema, enum)
+	return schema, t.Kind() == reflect.Pointer
+}
+
+// setEnum restricts the values of schema to enum, or those of its items for
+// arrays.
+func setEnum(schema *Property, enum []string) {
+	if enum == nil {
+		return
+	}
+	if schema.Type == "array" && schema.Items != nil {
+		schema.Items.Enum = enum
+		return
+	}
+	schema.Enum = enum
+}
+
+// schemaFor derives the JSON schema of t, matching how encoding/json decodes
+// into it. seen guards against recursive types.
+func schemaFor(t reflect.Type, seen map[reflect.Type]bool) Property {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem(), seen)
+	case reflect.String:
+		return Property{Type: "string"}
+	case reflect.Bool:
+		return Property{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Property{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Property{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 { // []byte is base64 encoded
+			return Property{Type: "string"}
+		}
+		items := schemaFor(t.Elem(), seen)
+		return Property{Type: "array", Items: &items}
+	case reflect.Map:
+		values := schemaFor(t.Elem(), seen)
+		return Property{Type: "object", AdditionalProperties: &values}
+	case reflect.Struct:
+		if seen[t] {
+			return Property{Type: "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		schema := Property{Type: "object", Properties: make(map[string]Property)}
+		addFields(&schema, t, seen)
+		return schema
+	default: // interfaces accept any value
+		return Property{}
+	}
+}
+
+// addFields adds the exported fields of the struct t to schema, flattening
+// embedded structs like encoding/json does.
+func addFields(schema *Property, t reflect.Type, seen map[reflect.Type]bool) {
+	docs := structFieldDocs(t)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addFields(schema, embedded, seen)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := schemaFor(field.Type, seen)
+		desc, enum := parseEnum(docs[field.Name])
+		prop.Description = desc
+		if tagEnum := field.Tag.Get("enum"); tagEnum != "" {
+			enum = splitEnum(tagEnum)
+		}
+		setEnum(&prop, enum)
+		schema.Properties[name] = prop
+
+		optional := field.Type.Kind() == reflect.Pointer || strings.Contains(","+opts+",", ",omitempty,")
+		if !optional {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+var (
+	fieldDocsMu sync.Mutex
+	// package path -> "Type.Field" -> doc comment
+	fieldDocs = make(map[string]map[string]string)
+)
+
+// structFieldDocs returns the doc comments of the fields of t by field name.
+// They are taken from the generated metadata of its package. Only packages
+// without metadata are read from source, loaded once; types without either
+// have no descriptions.
+func structFieldDocs(t reflect.Type) map[string]string {
+	if t.PkgPath() == "" || t.Name() == "" {
+		return nil
+	}
+	if generated, ok := lookupFieldDocs(t.PkgPath()); ok {
+		return fieldDocsOf(generated, t.Name())
+	}
+	fieldDocsMu.Lock()
+	defer fieldDocsMu.Unlock()
+	docs, ok := fieldDocs[t.PkgPath()]
+	if !ok {
+		docs = loadFieldDocs(t.PkgPath())
+		fieldDocs[t.PkgPath()] = docs
+	}
+	return fieldDocsOf(docs, t.Name())
+}
+
+// fieldDocsOf picks the docs of the fields of typeName from the docs of a
+// package.
+func fieldDocsOf(docs map[string]string, typeName string) map[string]string {
+	byField := make(map[string]string)
+	for key, doc := range docs {
+		if field, ok := strings.CutPrefix(key, typeName+"."); ok {
+			byField[field] = doc
+		}
+	}
+	return byField
+}
+
+func loadFieldDocs(pkgPath string) map[string]string {
+	// the test variant of the package holds the types declared in its
+	// _test.go files
+	cfg := packages.Config{Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax, Tests: true}
+	pkgs, err := loadPackages(&cfg, pkgPath)
+	if err != nil {
+		return map[string]string{}
+	}
+	var files []*ast.File
+	for _, pkg := range pkgs {
+		if pkg.PkgPath == pkgPath {
+			files = append(files, pkg.Syntax...)
+		}
+	}
+	return FieldDocsFromSource(files)
+}
+
+// FieldDocsFromSource returns the doc comments of the struct fields declared
+// in files by `Type.Field`.
+func FieldDocsFromSource(files []*ast.File) map[string]string {
+	docs := make(map[string]string)
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			ts, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				return true
+			}
+			for _, field := range st.Fields.List {
+				doc := field.Doc.Text()
+				if doc == "" {
+					doc = field.Comment.Text()
+				}
+				for _, ident := range field.Names {
+					docs[ts.Name.Name+"."+ident.Name] = strings.TrimSpace(doc)
+				}
+			}
+			return true
+		})
+	}
+	return docs
+}
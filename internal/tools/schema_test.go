@@ -0,0 +1,154 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"image"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+type forecastOptions struct {
+	Unit string `json:"unit" enum:"celsius,fahrenheit"`
+	// Days to forecast, 1 by default.
+	Days   *int `json:"days"`
+	Hourly bool `json:"hourly,omitempty"` // one forecast per hour
+	// Fields to include. [enum: wind, rain]
+	Fields []string `json:"fields"`
+	Kinds  []string `json:"kinds,omitempty" enum:"min,max"`
+	Ignore string   `json:"-"`
+	Location
+}
+
+type Location struct {
+	City string
+	Tags map[string]float64 `json:"tags"`
+}
+
+func TestRegisterTool_Schema(t *testing.T) {
+	// the generated metadata of the package doesn't cover test files, register
+	// the field docs of the types above like apc-toolgen would
+	file, err := parser.ParseFile(token.NewFileSet(), "schema_test.go", nil, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterMetadata(reflect.TypeOf(Location{}).PkgPath(), Metadata{FieldDocs: FieldDocsFromSource([]*ast.File{file})})
+	load := loadPackages
+	t.Cleanup(func() { loadPackages = load })
+	loadPackages = func(_ *packages.Config, patterns ...string) ([]*packages.Package, error) {
+		t.Errorf("unexpected source load of %v", patterns)
+		return nil, errors.New("no source")
+	}
+
+	fr := NewFunctionRegistry()
+	tool, err := RegisterTool(fr, "Forecast", func(ctx context.Context, options forecastOptions, cities []Location, limit *int) (string, error) {
+		data, err := json.Marshal(map[string]any{"options": options, "cities": cities, "limit": limit})
+		return string(data), err
+	}, FuncDocs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := json.Marshal(tool.Function.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := `{"type":"object","properties":{"City":{"type":"string"},"tags":{"type":"object","additionalProperties":{"type":"number"}}},"required":["City","tags"]}`
+	expected := `{"type":"object","properties":{` +
+		`"cities":{"type":"array","items":` + location + `},` +
+		`"limit":{"type":"integer"},` +
+		`"options":{"type":"object","properties":{"City":{"type":"string"},"days":{"type":"integer","description":"Days to forecast, 1 by default."},"fields":{"type":"array","description":"Fields to include.","items":{"type":"string","enum":["wind","rain"]}},"hourly":{"type":"boolean","description":"one forecast per hour"},"kinds":{"type":"array","items":{"type":"string","enum":["min","max"]}},"tags":{"type":"object","additionalProperties":{"type":"number"}},"unit":{"type":"string","enum":["celsius","fahrenheit"]}},"required":["unit","fields","City","tags"]}},` +
+		`"required":["options","cities"]}`
+	if string(schema) != expected {
+		t.Errorf("unexpected schema:\n%s\nexpected:\n%s", schema, expected)
+	}
+
+	units, _ := paramSchema(reflect.TypeOf([]string{}), "units to convert to [enum: celsius, kelvin]")
+	if units.Description != "units to convert to" || units.Enum != nil || !reflect.DeepEqual(units.Items.Enum, []string{"celsius", "kelvin"}) {
+		t.Errorf("expected the enum on the items, got %+v", units)
+	}
+
+	var args map[string]any
+	if err := json.Unmarshal([]byte(`{
+		"options": {"unit": "celsius", "days": 3, "fields": ["wind"], "City": "Paris", "tags": {"eu": 1}},
+		"cities": [{"City": "Rome", "tags": {}}]
+	}`), &args); err != nil {
+		t.Fatal(err)
+	}
+	// the omitted pointer is nil
+	result, err := ExecTool(context.Background(), fr, "Forecast", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedResult := `{"cities":[{"City":"Rome","tags":{}}],"limit":null,"options":{"unit":"celsius","days":3,"fields":["wind"],"City":"Paris","tags":{"eu":1}}}`
+	if result != expectedResult {
+		t.Errorf("unexpected result:\n%s\nexpected:\n%s", result, expectedResult)
+	}
+}
+
+// pointSource stands in for the source of the image package, which has no
+// generated metadata.
+const pointSource = `package image
+
+type Point struct {
+	// X grows to the right.
+	X int
+	Y int // Y grows downwards.
+}
+`
+
+func TestStructFieldDocs_Source(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "point.go", pointSource, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := loadPackages
+	t.Cleanup(func() { loadPackages = load })
+	pkgPath := reflect.TypeOf(image.Point{}).PkgPath()
+	forget := func() {
+		fieldDocsMu.Lock()
+		defer fieldDocsMu.Unlock()
+		delete(fieldDocs, pkgPath)
+	}
+	forget()
+	t.Cleanup(forget)
+	loads := 0
+	loadPackages = func(_ *packages.Config, patterns ...string) ([]*packages.Package, error) {
+		loads += 1
+		return []*packages.Package{{PkgPath: patterns[0], Syntax: []*ast.File{file}}}, nil
+	}
+
+	expected := map[string]string{"X": "X grows to the right.", "Y": "Y grows downwards."}
+	for range 2 {
+		if docs := structFieldDocs(reflect.TypeOf(image.Point{})); !reflect.DeepEqual(expected, docs) {
+			t.Errorf("unexpected field docs: %v", docs)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected the source to be loaded once, got %d loads", loads)
+	}
+}
+
+func TestConvertArg_Lenient(t *testing.T) {
+	for _, tc := range []struct {
+		value    any
+		expected any
+	}{
+		{"42", 42},
+		{float64(7), int64(7)},
+		{"true", true},
+		{"2.5", 2.5},
+		{float64(12), "12"},
+	} {
+		out, err := convertArg(tc.value, reflect.TypeOf(tc.expected))
+		if err != nil || out.Interface() != tc.expected {
+			t.Errorf("convertArg(%#v) = %v, %v, expected %#v", tc.value, out, err, tc.expected)
+		}
+	}
+}
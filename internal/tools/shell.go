@@ -0,0 +1,312 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/assagman/apc/internal/environ"
+)
+
+// DefaultShellAllow are the commands the Shell tools can run unless
+// ShellConfig.Allow is set. `go test` runs the tests of the project, which
+// are arbitrary code; leave it out if the model can write files.
+var DefaultShellAllow = []string{
+	"go build", "go vet", "go test", "gofmt",
+	"git status", "git diff", "git log", "git show", "git blame",
+}
+
+// DefaultShellDeny are the commands the Shell tools can't run unless
+// ShellConfig.Deny is set: shells and other binaries running arbitrary
+// commands, privilege escalation, deletion and network access.
+var DefaultShellDeny = []string{
+	"sh", "bash", "zsh", "dash", "fish", "env", "xargs", "nohup",
+	"sudo", "su", "doas", "rm", "curl", "wget", "ssh", "scp", "git push",
+}
+
+// DefaultShellDenyArgs are the arguments no command can be passed unless
+// ShellConfig.DenyArgs is set: flags reading or writing files out of the
+// sandbox, like `git diff --no-index` or `--output`, and flags running other
+// programs, like `go test -exec`.
+var DefaultShellDenyArgs = []string{
+	"--no-index", "--output*",
+	"-exec", "-exec=*", "--exec", "--exec=*",
+	"-toolexec*", "--toolexec*", "-vettool*", "--vettool*",
+}
+
+// DefaultShellScrub are the environment variables hidden from the commands
+// unless ShellConfig.Scrub is set. Variables set by LoadEnv are always
+// hidden.
+var DefaultShellScrub = []string{"*_API_KEY", "*_KEY", "*TOKEN*", "*SECRET*", "*PASSWORD*", "*CREDENTIALS*"}
+
+// DefaultShellTimeout is the time limit of a command unless
+// ShellConfig.Timeout is set.
+const DefaultShellTimeout = 2 * time.Minute
+
+// DefaultMaxOutputSize is the size limit of the output returned by
+// ToolRunCommand unless ShellConfig.MaxOutputSize is set.
+const DefaultMaxOutputSize = 32 << 10
+
+// ShellConfig restricts the commands the Shell tools can run. Commands are
+// run directly, without a shell, so pipes, redirections and globs have no
+// effect. Arguments naming paths, including the values of `-flag=value` and
+// the paths of git `rev:path` arguments, are checked against the FS sandbox;
+// other ways for a command to reach files, like its config, are not.
+type ShellConfig struct {
+	// Allow holds the commands that can be run: a binary name, which may be
+	// a glob like `*`, optionally followed by the leading arguments the
+	// command must start with, e.g. `git diff`. DefaultShellAllow if nil.
+	Allow []string
+	// Deny holds the commands that can't be run even if allowed, in the same
+	// form as Allow. DefaultShellDeny if nil; append to it to keep its
+	// commands.
+	Deny []string
+	// DenyArgs holds glob patterns of the arguments no command can be passed,
+	// e.g. `--output*`. DefaultShellDenyArgs if nil.
+	DenyArgs []string
+	// Scrub holds glob patterns of the environment variables hidden from the
+	// commands, matched case insensitively. DefaultShellScrub if nil.
+	Scrub []string
+	// Env holds extra `KEY=value` variables, set after scrubbing.
+	Env []string
+	// Timeout is the time limit of a command, after which it's killed.
+	// DefaultShellTimeout if zero.
+	Timeout time.Duration
+	// MaxOutputSize is the size limit of the output returned, in bytes; the
+	// middle of longer output is cut. DefaultMaxOutputSize if zero.
+	MaxOutputSize int
+}
+
+// Shell holds the tools running commands in the working directory of FS.
+type Shell struct {
+	FS     *FS
+	Config ShellConfig
+}
+
+// ToolRunCommand runs a command in the current working directory and returns
+// its combined stdout and stderr, followed by its exit code. The command is
+// run directly, not through a shell: pipes, redirections, globs and
+// variables are not supported. Only some commands are allowed, and paths in
+// the arguments must be inside the current working directory; the error says
+// so for the others.
+//
+// command: name of the binary to run, e.g. `go` or `git`
+// args: arguments of the command, e.g. ["test", "./..."]
+// dir: optional, relative directory path to CWD to run the command in
+func (sh *Shell) ToolRunCommand(ctx context.Context, command string, args []string, dir *string) (string, error) {
+	if strings.TrimSpace(command) == "" {
+		return "", fmt.Errorf("[ToolRunCommand] empty command")
+	}
+	if strings.ContainsRune(command, '/') || strings.ContainsRune(command, os.PathSeparator) {
+		return "", fmt.Errorf("[ToolRunCommand] `%s` must be a binary name, not a path", command)
+	}
+	if err := sh.checkCommand(command, args); err != nil {
+		return "", fmt.Errorf("[ToolRunCommand] %w", err)
+	}
+	if err := sh.checkArgs(args); err != nil {
+		return "", fmt.Errorf("[ToolRunCommand] %w", err)
+	}
+
+	dirName := "."
+	if dir != nil && strings.TrimSpace(*dir) != "" {
+		dirName = *dir
+	}
+	workDir, _, err := sh.FS.resolvePath(dirName)
+	if err != nil {
+		return "", fmt.Errorf("[ToolRunCommand] %w", err)
+	}
+	if info, err := os.Stat(workDir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("[ToolRunCommand] `%s` is not a directory", dirName)
+	}
+	binary, err := exec.LookPath(command)
+	if err != nil {
+		return "", fmt.Errorf("[ToolRunCommand] %w", err)
+	}
+
+	timeout := sh.Config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultShellTimeout
+	}
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output := &cappedOutput{limit: sh.maxOutputSize()}
+	cmd := exec.CommandContext(cmdCtx, binary, args...)
+	cmd.Dir = workDir
+	cmd.Env = sh.commandEnv()
+	cmd.Stdout = output
+	cmd.Stderr = output
+	killProcessGroup(cmd)
+	// children still holding the pipes don't keep the call waiting
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return "", context.Cause(ctx)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(output.String())
+	if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+		sb.WriteString("\n")
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case cmdCtx.Err() != nil:
+		fmt.Fprintf(&sb, "[killed: timed out after %s]", timeout)
+	case err == nil:
+		sb.WriteString("[exit code 0]")
+	case errors.As(err, &exitErr):
+		fmt.Fprintf(&sb, "[exit code %d]", exitErr.ExitCode())
+	default:
+		return "", fmt.Errorf("[ToolRunCommand] %w", err)
+	}
+	return sb.String(), nil
+}
+
+// checkCommand returns an error unless the command is allowed and not
+// denied.
+func (sh *Shell) checkCommand(command string, args []string) error {
+	allow := sh.Config.Allow
+	if allow == nil {
+		allow = DefaultShellAllow
+	}
+	deny := sh.Config.Deny
+	if deny == nil {
+		deny = DefaultShellDeny
+	}
+	for _, rule := range deny {
+		if matchCommand(rule, command, args) {
+			return fmt.Errorf("`%s` is denied", rule)
+		}
+	}
+	for _, rule := range allow {
+		if matchCommand(rule, command, args) {
+			return nil
+		}
+	}
+	return fmt.Errorf("`%s` is not allowed, allowed commands are: %s", command, strings.Join(allow, ", "))
+}
+
+// checkArgs returns an error if an argument is denied, or names a path out of
+// the working directory or denied by the FS sandbox, e.g. `.env` in
+// `git show HEAD:.env`.
+func (sh *Shell) checkArgs(args []string) error {
+	denyArgs := sh.Config.DenyArgs
+	if denyArgs == nil {
+		denyArgs = DefaultShellDenyArgs
+	}
+	for _, arg := range args {
+		for _, pattern := range denyArgs {
+			if ok, _ := path.Match(pattern, arg); ok {
+				return fmt.Errorf("argument `%s` is denied", arg)
+			}
+		}
+		for _, argPath := range argPaths(arg) {
+			if _, _, err := sh.FS.resolvePath(argPath); err != nil {
+				return fmt.Errorf("argument `%s`: %w", arg, err)
+			}
+		}
+	}
+	return nil
+}
+
+// argPaths returns the paths arg may name: itself, or the value of a
+// `-flag=value`, and the path of a git `rev:path`.
+func argPaths(arg string) []string {
+	if strings.HasPrefix(arg, "-") {
+		_, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil
+		}
+		arg = value
+	}
+	var paths []string
+	if strings.TrimSpace(arg) != "" {
+		paths = append(paths, arg)
+	}
+	if _, revPath, ok := strings.Cut(arg, ":"); ok && strings.TrimSpace(revPath) != "" {
+		paths = append(paths, revPath)
+	}
+	return paths
+}
+
+// matchCommand reports whether the command matches rule, a binary name glob
+// followed by leading arguments.
+func matchCommand(rule string, command string, args []string) bool {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(fields[0], command); !ok {
+		return false
+	}
+	return len(args) >= len(fields)-1 && slices.Equal(fields[1:], args[:len(fields)-1])
+}
+
+// commandEnv returns the environment of the commands: the one of the process
+// without the scrubbed variables and those set by LoadEnv, plus Config.Env.
+func (sh *Shell) commandEnv() []string {
+	scrub := sh.Config.Scrub
+	if scrub == nil {
+		scrub = DefaultShellScrub
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if environ.Loaded(key) || slices.ContainsFunc(scrub, func(pattern string) bool {
+			ok, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(key))
+			return ok
+		}) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env, sh.Config.Env...)
+}
+
+func (sh *Shell) maxOutputSize() int {
+	if sh.Config.MaxOutputSize > 0 {
+		return sh.Config.MaxOutputSize
+	}
+	return DefaultMaxOutputSize
+}
+
+// cappedOutput keeps the first and last limit/2 bytes written to it, as the
+// start and the end of the output of a command matter most.
+type cappedOutput struct {
+	limit int
+	head  []byte
+	tail  []byte
+	total int
+}
+
+func (o *cappedOutput) Write(p []byte) (int, error) {
+	o.total += len(p)
+	rest := p
+	if n := min(o.limit/2-len(o.head), len(rest)); n > 0 {
+		o.head = append(o.head, rest[:n]...)
+		rest = rest[n:]
+	}
+	o.tail = append(o.tail, rest...)
+	if keep := o.limit - o.limit/2; len(o.tail) > keep {
+		o.tail = append(o.tail[:0], o.tail[len(o.tail)-keep:]...)
+	}
+	return len(p), nil
+}
+
+func (o *cappedOutput) String() string {
+	omitted := o.total - len(o.head) - len(o.tail)
+	if omitted == 0 {
+		return strings.ToValidUTF8(string(o.head)+string(o.tail), "�")
+	}
+	head := strings.ToValidUTF8(string(o.head), "�")
+	tail := strings.ToValidUTF8(string(o.tail), "�")
+	return truncated(head, fmt.Sprintf("%d bytes of output omitted", omitted)) + "\n" + tail
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package tools
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as is: cancelling it kills the command only,
+// not its children.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,134 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShell_ToolRunCommand(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APC_TEST_API_KEY", "secret")
+	t.Setenv("APC_TEST_VISIBLE", "visible")
+	ctx := context.Background()
+	sh := &Shell{FS: &FS{WD: dir}, Config: ShellConfig{
+		Allow:         []string{"sh", "pwd", "git diff"},
+		Deny:          []string{"sh -x"},
+		Env:           []string{"APC_TEST_EXTRA=extra"},
+		Timeout:       200 * time.Millisecond,
+		MaxOutputSize: 20,
+	}}
+	sub := "sub"
+
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		dir      *string
+		expected string
+	}{
+		{name: "exit code", args: []string{"-c", "echo hi; exit 3"}, expected: "hi\n[exit code 3]"},
+		{name: "environment", args: []string{"-c", `echo "$APC_TEST_API_KEY|$APC_TEST_VISIBLE"`}, expected: "|visible\n[exit code 0]"},
+		{name: "extra environment", args: []string{"-c", `echo "$APC_TEST_EXTRA"`}, expected: "extra\n[exit code 0]"},
+		{name: "dir", args: []string{"-c", "basename $(pwd)"}, dir: &sub, expected: "sub\n[exit code 0]"},
+		{
+			name:     "output cap",
+			args:     []string{"-c", "echo 0123456789abcdefghijklmnopqrstuvwxyz"},
+			expected: "0123456789\n[truncated: 17 bytes of output omitted]\nrstuvwxyz\n[exit code 0]",
+		},
+		{name: "timeout", args: []string{"-c", "sleep 5"}, expected: "[killed: timed out after 200ms]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := sh.ToolRunCommand(ctx, "sh", tc.args, tc.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		command string
+		args    []string
+	}{
+		{command: "/bin/sh", args: []string{"-c", "true"}},
+		{command: "sh", args: []string{"-x", "-c", "true"}},
+		{command: "git", args: []string{"push"}},
+		{command: "ls"},
+	} {
+		if _, err := sh.ToolRunCommand(ctx, tc.command, tc.args, nil); err == nil {
+			t.Errorf("expected `%s %s` to be rejected", tc.command, strings.Join(tc.args, " "))
+		}
+	}
+	outside := ".."
+	if _, err := sh.ToolRunCommand(ctx, "pwd", nil, &outside); err == nil {
+		t.Error("expected a directory outside of the working directory to be rejected")
+	}
+}
+
+func TestShell_DefaultConfig(t *testing.T) {
+	sh := &Shell{FS: &FS{WD: t.TempDir()}}
+	for _, args := range [][]string{
+		{"go", "run", "main.go"},
+		{"go", "generate", "./..."},
+		{"go", "env", "-w", "GOFLAGS=-exec=sh"},
+		{"go", "test", "-exec", "sh", "./..."},
+		{"go", "test", "--exec=sh", "./..."},
+		{"go", "build", "-toolexec=sh", "./..."},
+		{"go", "vet", "-vettool", "sh", "./..."},
+		{"go", "test", "-coverprofile=/tmp/cover.out", "./..."},
+		{"go", "build", "-o", "../outside", "."},
+		{"git", "diff", "--no-index", "/dev/null", "/etc/passwd"},
+		{"git", "diff", "--no-index", "a.txt", "b.txt"},
+		{"git", "diff", "--output=diff.txt"},
+		{"git", "show", "HEAD:.env"},
+		{"git", "show", "HEAD~1:.git/config"},
+		{"git", "log", "--", "../outside"},
+	} {
+		if _, err := sh.ToolRunCommand(context.Background(), args[0], args[1:], nil); err == nil {
+			t.Errorf("expected `%s` to be rejected", strings.Join(args, " "))
+		}
+	}
+
+	for _, args := range [][]string{
+		{"go", "test", "-run", "TestShell", "-count=1", "./..."},
+		{"gofmt", "-l", "."},
+		{"git", "log", "--format=%h:%s", "-n", "5", "--", "main.go"},
+		{"git", "show", "HEAD~1:main.go"},
+	} {
+		if err := sh.checkCommand(args[0], args[1:]); err != nil {
+			t.Errorf("expected `%s` to be allowed: %v", strings.Join(args, " "), err)
+		} else if err := sh.checkArgs(args[1:]); err != nil {
+			t.Errorf("expected `%s` to be allowed: %v", strings.Join(args, " "), err)
+		}
+	}
+}
+
+func TestShell_TimeoutKillsChildren(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	dir := t.TempDir()
+	sh := &Shell{FS: &FS{WD: dir}, Config: ShellConfig{Allow: []string{"sh"}, Deny: []string{}, Timeout: 200 * time.Millisecond}}
+	got, err := sh.ToolRunCommand(context.Background(), "sh", []string{"-c", "(sleep 1; touch late) & wait"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "[killed: timed out after 200ms]" {
+		t.Errorf("unexpected output %q", got)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(filepath.Join(dir, "late")); !os.IsNotExist(err) {
+		t.Errorf("expected the child of the command to be killed, got %v", err)
+	}
+}
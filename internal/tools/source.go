@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+type sourceFile struct {
+	fset *token.FileSet
+	file *ast.File
+}
+
+var (
+	sourceFilesMu sync.Mutex
+	// file path -> parsed file, nil if it couldn't be parsed
+	sourceFiles = make(map[string]*sourceFile)
+)
+
+// findFuncSource locates the declaration of a standalone function, or the
+// literal of a closure, from its entry PC. It returns the function type and
+// the doc comment, or nil if the source isn't available, e.g. in binaries
+// running without their source tree.
+func findFuncSource(fv reflect.Value) (*ast.FuncType, string) {
+	fn := runtime.FuncForPC(fv.Pointer())
+	if fn == nil {
+		return nil, ""
+	}
+	path, line := fn.FileLine(fn.Entry())
+	src := parseSourceFile(path)
+	if src == nil {
+		return nil, ""
+	}
+
+	// Func for functions, Func.func1 for closures
+	_, name := splitFuncName(fn.Name())
+	if !strings.Contains(name, ".") {
+		for _, d := range src.file.Decls {
+			if decl, ok := d.(*ast.FuncDecl); ok && decl.Recv == nil && decl.Name.Name == name {
+				return decl.Type, strings.TrimSpace(decl.Doc.Text())
+			}
+		}
+		return nil, ""
+	}
+
+	var funcType *ast.FuncType
+	ast.Inspect(src.file, func(n ast.Node) bool {
+		if funcType != nil {
+			return false
+		}
+		if lit, ok := n.(*ast.FuncLit); ok && src.fset.Position(lit.Pos()).Line == line {
+			funcType = lit.Type
+		}
+		return true
+	})
+	return funcType, ""
+}
+
+func parseSourceFile(path string) *sourceFile {
+	sourceFilesMu.Lock()
+	defer sourceFilesMu.Unlock()
+	if src, ok := sourceFiles[path]; ok {
+		return src
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	var src *sourceFile
+	if err == nil {
+		src = &sourceFile{fset: fset, file: file}
+	}
+	sourceFiles[path] = src
+	return src
+}
@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// lookupWeather returns the current weather in a city.
+//
+// city: name of the city
+// unit: temperature unit [enum: celsius, fahrenheit]
+func lookupWeather(ctx context.Context, city string, unit string) (string, error) {
+	return city + " in " + unit, nil
+}
+
+func TestRegisterTool_Source(t *testing.T) {
+	fr := NewFunctionRegistry()
+	for _, tc := range []struct {
+		name     string
+		fn       any
+		docs     FuncDocs
+		expected string
+	}{
+		{
+			name:     "LookupWeather",
+			fn:       lookupWeather,
+			expected: `{"name":"LookupWeather","description":"lookupWeather returns the current weather in a city.\n\ncity: name of the city\nunit: temperature unit [enum: celsius, fahrenheit]","parameters":{"type":"object","properties":{"city":{"type":"string","description":"name of the city"},"unit":{"type":"string","description":"temperature unit","enum":["celsius","fahrenheit"]}},"required":["city","unit"]}}`,
+		},
+		{
+			name:     "ClosureWeather",
+			fn:       func(city string, days int) (string, error) { return city, nil },
+			expected: `{"name":"ClosureWeather","description":"","parameters":{"type":"object","properties":{"city":{"type":"string"},"days":{"type":"integer"}},"required":["city","days"]}}`,
+		},
+		{
+			name: "ManualWeather",
+			fn:   func(_ string, _ int) (string, error) { return "", nil },
+			docs: FuncDocs{
+				Description: "Returns the forecast.",
+				Params:      []ParamDoc{{Name: "city", Description: "name of the city"}, {Name: "days", Description: "number of days"}},
+			},
+			expected: `{"name":"ManualWeather","description":"Returns the forecast.","parameters":{"type":"object","properties":{"city":{"type":"string","description":"name of the city"},"days":{"type":"integer","description":"number of days"}},"required":["city","days"]}}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tool, err := RegisterTool(fr, tc.name, tc.fn, tc.docs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			definition, err := json.Marshal(tool.Function)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(definition) != tc.expected {
+				t.Errorf("unexpected tool:\n%s\nexpected:\n%s", definition, tc.expected)
+			}
+		})
+	}
+
+	result, err := ExecTool(context.Background(), fr, "LookupWeather", map[string]any{"city": "Paris", "unit": "celsius"})
+	if err != nil || result != "Paris in celsius" {
+		t.Errorf("unexpected result: %v, %v", result, err)
+	}
+}
+
+type staleBox struct{}
+
+func (b *staleBox) First(text string) (string, error)  { return text, nil }
+func (b *staleBox) Second(text string) (string, error) { return text, nil }
+
+// staleBoxSource doesn't match the compiled staleBox: Second gained a
+// parameter since.
+const staleBoxSource = `package tools
+
+type staleBox struct{}
+
+// First returns text.
+func (b *staleBox) First(text string) (string, error) { return text, nil }
+
+// Second returns text.
+func (b *staleBox) Second(text string, count int) (string, error) { return text, nil }
+`
+
+func TestRegisterMethods_Rollback(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "stale.go", staleBoxSource, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := loadPackages
+	t.Cleanup(func() { loadPackages = load })
+	loadPackages = func(_ *packages.Config, patterns ...string) ([]*packages.Package, error) {
+		return []*packages.Package{{PkgPath: patterns[0], Syntax: []*ast.File{file}}}, nil
+	}
+
+	fr := NewFunctionRegistry()
+	if _, err := RegisterMethods(fr, &staleBox{}, "stale"); err == nil {
+		t.Fatal("expected an error for the stale source of Second")
+	}
+	if len(fr.functions) != 0 {
+		t.Errorf("expected no tools left registered, got %d", len(fr.functions))
+	}
+}
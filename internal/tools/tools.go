@@ -1,11 +1,17 @@
 package tools
 
+//go:generate go run github.com/assagman/apc/cmd/apc-toolgen -type FS,FSWrite,Shell
+
 import (
+	"context"
+
 	"github.com/assagman/apc/internal/logger"
 )
 
-func ConstructToolStruct(toolName string) Tool {
-	fnInfo := funcRegistry.functions[toolName]
+func ConstructToolStruct(fr *FunctionRegistry, toolName string) Tool {
+	fr.mu.RLock()
+	fnInfo := fr.functions[toolName]
+	fr.mu.RUnlock()
 	description := fnInfo.description
 
 	// Populate parameters from extracted info
@@ -13,11 +19,10 @@ func ConstructToolStruct(toolName string) Tool {
 	required := make([]string, 0)
 	for _, paramName := range fnInfo.paramNames {
 		info := fnInfo.paramInfos[paramName]
-		properties[paramName] = Property{
-			Type:        info.TypeName,
-			Description: info.Description,
+		properties[paramName] = info.Schema
+		if !info.Optional {
+			required = append(required, paramName)
 		}
-		required = append(required, paramName) // Assume all required
 	}
 
 	return Tool{
@@ -34,16 +39,16 @@ func ConstructToolStruct(toolName string) Tool {
 	}
 }
 
-func RegisterTool(funcName string, fn any) (Tool, error) {
-	if err := funcRegistry.RegisterFunction(funcName, fn); err != nil {
+func RegisterTool(fr *FunctionRegistry, funcName string, fn any, docs FuncDocs) (Tool, error) {
+	if err := fr.RegisterFunction(funcName, fn, docs); err != nil {
 		return Tool{}, err
 	}
 
-	return ConstructToolStruct(funcName), nil
+	return ConstructToolStruct(fr, funcName), nil
 }
 
-func RegisterMethods(inst any) ([]Tool, error) {
-	methods, err := funcRegistry.RegisterMethods(inst)
+func RegisterMethods(fr *FunctionRegistry, inst any, namespace string) ([]Tool, error) {
+	methods, err := fr.RegisterMethods(inst, namespace)
 	if err != nil {
 		return nil, err
 	} else {
@@ -52,43 +57,60 @@ func RegisterMethods(inst any) ([]Tool, error) {
 	logger.PrintV(methods)
 	var tools []Tool
 	for _, name := range methods {
-		tools = append(tools, ConstructToolStruct(name))
+		tools = append(tools, ConstructToolStruct(fr, name))
 	}
 	return tools, nil
 }
 
-func GetFsTools(path string) ([]Tool, error) {
+func GetFsTools(fr *FunctionRegistry, path string, sandbox Sandbox, namespace string) ([]Tool, error) {
 	var tools []Tool
-	fs := &FS{WD: path}
-	methods, err := funcRegistry.RegisterMethods(fs)
+	fs := &FS{WD: path, Sandbox: sandbox}
+	methods, err := fr.RegisterMethods(fs, namespace)
 	if err != nil {
-		logger.Warning("%+w", err)
-	} else {
-		logger.Info("registry successfull")
+		return nil, err
+	}
+	logger.Info("registry successfull")
+
+	for _, name := range methods {
+		tools = append(tools, ConstructToolStruct(fr, name))
+	}
+	return tools, nil
+}
+
+// GetFsWriteTools registers the FSWrite tools working in path. Their changes
+// are recorded in the returned journal.
+func GetFsWriteTools(fr *FunctionRegistry, path string, sandbox Sandbox, namespace string) ([]Tool, *Journal, error) {
+	fsWrite := &FSWrite{FS: &FS{WD: path, Sandbox: sandbox}, Journal: &Journal{}}
+	methods, err := fr.RegisterMethods(fsWrite, namespace)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	var tools []Tool
 	for _, name := range methods {
-		tools = append(tools, ConstructToolStruct(name))
+		tools = append(tools, ConstructToolStruct(fr, name))
 	}
+	return tools, fsWrite.Journal, nil
+}
 
-	// var funcMap = map[string]any{
-	// 	"ToolGetCurrentWorkingDirectory": ToolGetCurrentWorkingDirectory,
-	// 	"ToolGrepText":                   ToolGrepText,
-	// 	"ToolReadFile":                   ToolReadFile,
-	// 	"ToolTree":                       ToolTree,
-	// }
-	// for funcName, fn := range funcMap {
-	// 	tool, err := RegisterTool(funcName, fn)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	tools = append(tools, tool)
-	// }
+// GetShellTools registers the Shell tools running commands in path, as
+// restricted by config. Paths given to them are checked against sandbox.
+func GetShellTools(fr *FunctionRegistry, path string, sandbox Sandbox, config ShellConfig, namespace string) ([]Tool, error) {
+	shell := &Shell{FS: &FS{WD: path, Sandbox: sandbox}, Config: config}
+	methods, err := fr.RegisterMethods(shell, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var tools []Tool
+	for _, name := range methods {
+		tools = append(tools, ConstructToolStruct(fr, name))
+	}
 	return tools, nil
 }
 
-func ExecTool(funcName string, args map[string]any) (any, error) {
+func ExecTool(ctx context.Context, fr *FunctionRegistry, funcName string, args map[string]any) (any, error) {
 	logger.Debug(funcName)
 	logger.PrintV(args)
-	return funcRegistry.ExecFunc(funcName, args)
+	return fr.ExecFunc(ctx, funcName, args)
 }
@@ -14,10 +14,16 @@ type ToolCall struct {
 	Function Function `json:"function"`
 }
 
+// Property is the JSON schema of a parameter. Objects have Properties, or
+// AdditionalProperties for maps; arrays have Items.
 type Property struct {
-	Type        string `json:"type,omitempty"`
-	Description string `json:"description,omitempty"`
-	// Enum        []string `json:"enum,omitempty"`
+	Type                 string              `json:"type,omitempty"`
+	Description          string              `json:"description,omitempty"`
+	Enum                 []string            `json:"enum,omitempty"`
+	Items                *Property           `json:"items,omitempty"`
+	Properties           map[string]Property `json:"properties,omitempty"`
+	Required             []string            `json:"required,omitempty"`
+	AdditionalProperties *Property           `json:"additionalProperties,omitempty"`
 }
 
 type ToolFunctionParameters struct {
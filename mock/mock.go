@@ -0,0 +1,376 @@
+// Package mock provides a deterministic provider for testing agent flows
+// offline. A Script replays scripted responses in order and records every
+// request it receives:
+//
+//	script := mock.NewScript(
+//		mock.ToolCalls(mock.Call("ToolReadFile", map[string]any{"filePath": "go.mod"})),
+//		mock.Text("It declares module x."),
+//	)
+//	apc.RegisterProvider("mock", script.Factory())
+//	client, _ := apc.New("mock", core.ProviderConfig{Model: "test"})
+//	answer, _ := client.Complete(ctx, "what does go.mod declare?")
+//	requests := script.Requests()
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/assagman/apc/core"
+)
+
+const (
+	FinishReasonStop      = "stop"
+	FinishReasonToolCalls = "tool_calls"
+	FinishReasonMaxTokens = "length"
+)
+
+// Response is a single scripted model response.
+type Response struct {
+	Text      string
+	ToolCalls []core.ToolCall
+	// FinishReason defaults to FinishReasonToolCalls if ToolCalls is set,
+	// FinishReasonStop otherwise.
+	FinishReason string
+	Usage        core.Usage
+	// Err is returned by SendRequest instead of the response.
+	Err error
+}
+
+// Text returns a response answering with text.
+func Text(text string) Response {
+	return Response{Text: text}
+}
+
+// ToolCalls returns a response requesting the given tool calls.
+func ToolCalls(calls ...core.ToolCall) Response {
+	return Response{ToolCalls: calls}
+}
+
+// Error returns a response failing the request with err.
+func Error(err error) Response {
+	return Response{Err: err}
+}
+
+// Call builds a tool call with args encoded as JSON. The id defaults to
+// `call_<n>` with n counting the calls of the script.
+func Call(name string, args any) core.ToolCall {
+	argsBytes, err := json.Marshal(args)
+	if err != nil {
+		panic(fmt.Sprintf("[mock.Call] Failed to encode arguments of `%s`: %v", name, err))
+	}
+	return core.ToolCall{Name: name, Arguments: argsBytes}
+}
+
+// Request is a request received by the provider.
+type Request struct {
+	Model        string
+	SystemPrompt string
+	// Messages is the history sent with the request, excluding the system
+	// prompt.
+	Messages        []core.Message
+	Tools           []string // names of the available tools
+	MaxOutputTokens int
+	Stream          bool
+}
+
+// Script is a sequence of responses shared by every provider created by its
+// Factory, e.g. by forked sessions.
+type Script struct {
+	mu        sync.Mutex
+	responses []Response
+	next      int
+	callCount int
+	requests  []Request
+}
+
+func NewScript(responses ...Response) *Script {
+	return &Script{responses: responses}
+}
+
+// Add appends responses to the script.
+func (s *Script) Add(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, responses...)
+}
+
+// Requests returns the requests received so far.
+func (s *Script) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// Remaining returns the number of responses not yet replayed.
+func (s *Script) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.responses) - s.next
+}
+
+// Factory returns a factory to register the script with apc.RegisterProvider.
+func (s *Script) Factory() core.ProviderFactory {
+	return func(config core.ProviderConfig) (core.IProvider, error) {
+		return New(s, config), nil
+	}
+}
+
+// reply records the request and returns the next response.
+func (s *Script) reply(req Request) (response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+	if s.next >= len(s.responses) {
+		return response{}, fmt.Errorf("[mock] Script exhausted after %d response(s)", len(s.responses))
+	}
+	scripted := s.responses[s.next]
+	s.next += 1
+	if scripted.Err != nil {
+		return response{}, scripted.Err
+	}
+
+	resp := response{
+		Message:      core.Message{Role: core.RoleAssistant},
+		FinishReason: scripted.FinishReason,
+		Usage:        scripted.Usage,
+	}
+	if scripted.Text != "" {
+		resp.Message.Parts = append(resp.Message.Parts, core.TextPart(scripted.Text))
+	}
+	for _, call := range scripted.ToolCalls {
+		s.callCount += 1
+		if call.Id == "" {
+			call.Id = fmt.Sprintf("call_%d", s.callCount)
+		}
+		resp.Message.Parts = append(resp.Message.Parts, core.ToolCallPart(call))
+	}
+	if resp.FinishReason == "" {
+		resp.FinishReason = FinishReasonStop
+		if len(scripted.ToolCalls) > 0 {
+			resp.FinishReason = FinishReasonToolCalls
+		}
+	}
+	return resp, nil
+}
+
+type response struct {
+	Message      core.Message
+	FinishReason string
+	Usage        core.Usage
+}
+
+// Provider implements core.IProvider on top of a Script, keeping the history
+// as provider-neutral messages.
+type Provider struct {
+	Name         string
+	Model        string
+	SystemPrompt string
+	Tools        []core.Tool
+	MaxTokens    int
+	History      []core.Message
+	script       *Script
+}
+
+func New(script *Script, config core.ProviderConfig) *Provider {
+	return &Provider{
+		Name:         "mock",
+		Model:        config.Model,
+		SystemPrompt: config.SystemPrompt,
+		Tools:        config.APCTools.Tools,
+		MaxTokens:    config.Limits.MaxOutputTokens,
+		History:      make([]core.Message, 0),
+		script:       script,
+	}
+}
+
+func (p *Provider) GetApiKey() string { return "" }
+
+func (p *Provider) GetEndpoint() string { return "" }
+
+func (p *Provider) GetHeaders() map[string]string { return map[string]string{} }
+
+func (p *Provider) ConstructUserPromptMessage(prompt string) core.GenericMessage {
+	return core.Message{Role: core.RoleUser, Parts: []core.Part{core.TextPart(prompt)}}
+}
+
+func (p *Provider) ConstructToolMessage(toolCall core.ProviderToolCall, toolResult core.ToolResult) core.GenericMessage {
+	toolResult.ToolCallId = toolCall.Id
+	toolResult.Name = toolCall.Function.Name
+	return core.Message{Role: core.RoleTool, Parts: []core.Part{core.ToolResultPart(toolResult)}}
+}
+
+func (p *Provider) AppendMessageHistory(msg core.GenericMessage) error {
+	message, ok := msg.(core.Message)
+	if !ok {
+		return fmt.Errorf("[AppendMessageHistory] Failed to cast core.GenericMessage -> core.Message")
+	}
+	// results of one tool round share a message, like in exported histories
+	last := len(p.History) - 1
+	if message.Role == core.RoleTool && last >= 0 && p.History[last].Role == core.RoleTool {
+		p.History[last].Parts = append(slices.Clone(p.History[last].Parts), message.Parts...)
+		return nil
+	}
+	p.History = append(p.History, message)
+	return nil
+}
+
+func (p *Provider) GetMessageHistory() any {
+	return p.History
+}
+
+func (p *Provider) SetMessageHistory(history any) error {
+	messages, ok := history.([]core.Message)
+	if !ok {
+		return fmt.Errorf("[SetMessageHistory] Failed to cast history -> []core.Message")
+	}
+	p.History = slices.Clone(messages)
+	return nil
+}
+
+func (p *Provider) ResetMessageHistory() {
+	p.History = make([]core.Message, 0)
+}
+
+func (p *Provider) ExportHistory() ([]core.Message, error) {
+	return slices.Clone(p.History), nil
+}
+
+func (p *Provider) ImportHistory(messages []core.Message) error {
+	p.History = slices.Clone(messages)
+	return nil
+}
+
+func (p *Provider) NewRequest() (core.GenericRequest, error) {
+	toolNames := make([]string, 0, len(p.Tools))
+	for _, tool := range p.Tools {
+		toolNames = append(toolNames, tool.Function.Name)
+	}
+	return Request{
+		Model:           p.Model,
+		SystemPrompt:    p.SystemPrompt,
+		Messages:        slices.Clone(p.History),
+		Tools:           toolNames,
+		MaxOutputTokens: p.MaxTokens,
+	}, nil
+}
+
+func (p *Provider) SendRequest(ctx context.Context, genericRequest core.GenericRequest) (core.GenericResponse, error) {
+	req, ok := genericRequest.(Request)
+	if !ok {
+		return nil, fmt.Errorf("[SendRequest] Failed to cast core.GenericRequest -> mock.Request")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.script.reply(req)
+}
+
+func (p *Provider) SendStreamRequest(ctx context.Context, genericRequest core.GenericRequest, onEvent core.StreamHandler) (core.GenericResponse, error) {
+	req, ok := genericRequest.(Request)
+	if !ok {
+		return nil, fmt.Errorf("[SendStreamRequest] Failed to cast core.GenericRequest -> mock.Request")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	req.Stream = true
+	resp, err := p.script.reply(req)
+	if err != nil {
+		return nil, err
+	}
+	if text := resp.Message.Text(); text != "" {
+		onEvent(core.StreamEvent{Type: core.StreamEventTextDelta, Text: text})
+	}
+	for i, call := range resp.Message.ToolCalls() {
+		onEvent(core.StreamEvent{
+			Type:           core.StreamEventToolCallDelta,
+			ToolCallIndex:  i,
+			ToolCallId:     call.Id,
+			ToolName:       call.Name,
+			ArgumentsDelta: string(call.Arguments),
+		})
+	}
+	return resp, nil
+}
+
+func (p *Provider) IsSenderRole(genericMessage core.GenericMessage) (bool, error) {
+	message, ok := genericMessage.(core.Message)
+	if !ok {
+		return false, fmt.Errorf("[IsSenderRole] Failed to cast core.GenericMessage -> core.Message")
+	}
+	return message.Role == core.RoleUser || message.Role == core.RoleTool, nil
+}
+
+func (p *Provider) GetMessageFromResponse(genericResponse core.GenericResponse) (core.GenericMessage, error) {
+	resp, ok := genericResponse.(response)
+	if !ok {
+		return nil, fmt.Errorf("[GetMessageFromResponse] Failed to cast core.GenericResponse -> mock.response")
+	}
+	return resp.Message, nil
+}
+
+func (p *Provider) GetFinishReasonFromResponse(genericResponse core.GenericResponse) (string, error) {
+	resp, ok := genericResponse.(response)
+	if !ok {
+		return "", fmt.Errorf("[GetFinishReasonFromResponse] Failed to cast core.GenericResponse -> mock.response")
+	}
+	return resp.FinishReason, nil
+}
+
+func (p *Provider) GetAnswerFromResponse(genericResponse core.GenericResponse) (string, error) {
+	resp, ok := genericResponse.(response)
+	if !ok {
+		return "", fmt.Errorf("[GetAnswerFromResponse] Failed to cast core.GenericResponse -> mock.response")
+	}
+	return resp.Message.Text(), nil
+}
+
+func (p *Provider) GetToolCallsFromResponse(genericResponse core.GenericResponse) ([]core.ProviderToolCall, error) {
+	resp, ok := genericResponse.(response)
+	if !ok {
+		return nil, fmt.Errorf("[GetToolCallsFromResponse] Failed to cast core.GenericResponse -> mock.response")
+	}
+	var toolCalls []core.ProviderToolCall
+	for _, call := range resp.Message.ToolCalls() {
+		args, err := core.NormalizeToolArguments(call.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		toolCalls = append(toolCalls, core.ProviderToolCall{
+			Id:       call.Id,
+			Type:     "function",
+			Function: core.Function{Name: call.Name, Arguments: args},
+		})
+	}
+	return toolCalls, nil
+}
+
+func (p *Provider) FinishReasonStop() string { return FinishReasonStop }
+
+func (p *Provider) FinishReasonToolCall() string { return FinishReasonToolCalls }
+
+func (p *Provider) FinishReasonMaxTokens() string { return FinishReasonMaxTokens }
+
+func (p *Provider) GetUsageFromResponse(genericResponse core.GenericResponse) (core.Usage, error) {
+	resp, ok := genericResponse.(response)
+	if !ok {
+		return core.Usage{}, fmt.Errorf("[GetUsageFromResponse] Failed to cast core.GenericResponse -> mock.response")
+	}
+	return resp.Usage, nil
+}
+
+func (p *Provider) IsToolCall(genericResponse core.GenericResponse) (bool, error) {
+	resp, ok := genericResponse.(response)
+	if !ok {
+		return false, fmt.Errorf("[IsToolCall] Failed to cast core.GenericResponse -> mock.response")
+	}
+	return len(resp.Message.ToolCalls()) > 0, nil
+}
+
+func (p *Provider) IsToolCallValid(toolCall core.ProviderToolCall) (bool, error) {
+	return toolCall.Type == "function", nil
+}
@@ -0,0 +1,52 @@
+package apc
+
+import (
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/store"
+)
+
+// Option customizes an APC created with New.
+type Option func(*options)
+
+type options struct {
+	historyStore core.HistoryStore
+	sessionId    string
+	toolApprover core.ToolApprover
+}
+
+// WithHistoryStore persists the history of every session of the APC to store
+// after each Send/Complete.
+func WithHistoryStore(historyStore core.HistoryStore) Option {
+	return func(o *options) {
+		o.historyStore = historyStore
+	}
+}
+
+// WithSessionId sets the id of the default session. If the history store
+// already has a history with this id, the conversation is resumed.
+func WithSessionId(sessionId string) Option {
+	return func(o *options) {
+		o.sessionId = sessionId
+	}
+}
+
+// WithToolApprover requires approver to approve every tool call before it
+// runs, unless the tool has its own approver or ProviderConfig.APCTools sets
+// one. It also applies after SwitchProvider.
+func WithToolApprover(approver core.ToolApprover) Option {
+	return func(o *options) {
+		o.toolApprover = approver
+	}
+}
+
+// NewJSONFileStore returns a HistoryStore keeping every session as a JSON
+// document in dir.
+func NewJSONFileStore(dir string) (core.HistoryStore, error) {
+	return store.NewJSONFileStore(dir)
+}
+
+// NewJSONLStore returns a HistoryStore keeping every session as an
+// append-only JSON Lines file in dir.
+func NewJSONLStore(dir string) (core.HistoryStore, error) {
+	return store.NewJSONLStore(dir)
+}
@@ -0,0 +1,158 @@
+package apc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/assagman/apc"
+	"github.com/assagman/apc/core"
+)
+
+// upperProvider is a provider written against the public API only, as one in
+// another module would be. It asks for the tool `upper` once, then answers
+// with its result.
+type upperProvider struct {
+	tools   []core.Tool
+	history []core.Message
+}
+
+type upperResponse struct {
+	message      core.Message
+	finishReason string
+}
+
+func (p *upperProvider) GetApiKey() string             { return "" }
+func (p *upperProvider) GetEndpoint() string           { return "" }
+func (p *upperProvider) GetHeaders() map[string]string { return nil }
+func (p *upperProvider) FinishReasonStop() string      { return "stop" }
+func (p *upperProvider) FinishReasonToolCall() string  { return "tool_calls" }
+func (p *upperProvider) FinishReasonMaxTokens() string { return "length" }
+func (p *upperProvider) GetMessageHistory() any        { return p.history }
+func (p *upperProvider) ResetMessageHistory()          { p.history = nil }
+func (p *upperProvider) ExportHistory() ([]core.Message, error) {
+	return slices.Clone(p.history), nil
+}
+
+func (p *upperProvider) ImportHistory(messages []core.Message) error {
+	p.history = slices.Clone(messages)
+	return nil
+}
+
+func (p *upperProvider) SetMessageHistory(history any) error {
+	messages, ok := history.([]core.Message)
+	if !ok {
+		return fmt.Errorf("unexpected history %T", history)
+	}
+	p.history = slices.Clone(messages)
+	return nil
+}
+
+func (p *upperProvider) ConstructUserPromptMessage(prompt string) core.GenericMessage {
+	return core.Message{Role: core.RoleUser, Parts: []core.Part{core.TextPart(prompt)}}
+}
+
+func (p *upperProvider) ConstructToolMessage(toolCall core.ProviderToolCall, toolResult core.ToolResult) core.GenericMessage {
+	toolResult.ToolCallId = toolCall.Id
+	return core.Message{Role: core.RoleTool, Parts: []core.Part{core.ToolResultPart(toolResult)}}
+}
+
+func (p *upperProvider) AppendMessageHistory(msg core.GenericMessage) error {
+	message, ok := msg.(core.Message)
+	if !ok {
+		return fmt.Errorf("unexpected message %T", msg)
+	}
+	p.history = append(p.history, message)
+	return nil
+}
+
+func (p *upperProvider) IsSenderRole(msg core.GenericMessage) (bool, error) {
+	return msg.(core.Message).Role != core.RoleAssistant, nil
+}
+
+func (p *upperProvider) NewRequest() (core.GenericRequest, error) {
+	return slices.Clone(p.history), nil
+}
+
+func (p *upperProvider) SendRequest(ctx context.Context, req core.GenericRequest) (core.GenericResponse, error) {
+	history := req.([]core.Message)
+	last := history[len(history)-1]
+	if results := last.ToolResults(); len(results) > 0 {
+		return upperResponse{
+			message:      core.Message{Role: core.RoleAssistant, Parts: []core.Part{core.TextPart(results[0].Text())}},
+			finishReason: p.FinishReasonStop(),
+		}, nil
+	}
+	args, _ := json.Marshal(map[string]string{"text": last.Text()})
+	call := core.ToolCall{Id: "call_1", Name: p.tools[0].Function.Name, Arguments: args}
+	return upperResponse{
+		message:      core.Message{Role: core.RoleAssistant, Parts: []core.Part{core.ToolCallPart(call)}},
+		finishReason: p.FinishReasonToolCall(),
+	}, nil
+}
+
+func (p *upperProvider) SendStreamRequest(ctx context.Context, req core.GenericRequest, onEvent core.StreamHandler) (core.GenericResponse, error) {
+	return p.SendRequest(ctx, req)
+}
+
+func (p *upperProvider) GetMessageFromResponse(resp core.GenericResponse) (core.GenericMessage, error) {
+	return resp.(upperResponse).message, nil
+}
+
+func (p *upperProvider) GetFinishReasonFromResponse(resp core.GenericResponse) (string, error) {
+	return resp.(upperResponse).finishReason, nil
+}
+
+func (p *upperProvider) GetAnswerFromResponse(resp core.GenericResponse) (string, error) {
+	return resp.(upperResponse).message.Text(), nil
+}
+
+func (p *upperProvider) GetUsageFromResponse(resp core.GenericResponse) (core.Usage, error) {
+	return core.Usage{}, nil
+}
+
+func (p *upperProvider) IsToolCall(resp core.GenericResponse) (bool, error) {
+	return resp.(upperResponse).finishReason == p.FinishReasonToolCall(), nil
+}
+
+func (p *upperProvider) GetToolCallsFromResponse(resp core.GenericResponse) ([]core.ProviderToolCall, error) {
+	var toolCalls []core.ProviderToolCall
+	for _, call := range resp.(upperResponse).message.ToolCalls() {
+		toolCalls = append(toolCalls, core.ProviderToolCall{
+			Id:       call.Id,
+			Type:     "function",
+			Function: core.Function{Name: call.Name, Arguments: call.Arguments},
+		})
+	}
+	return toolCalls, nil
+}
+
+func (p *upperProvider) IsToolCallValid(toolCall core.ProviderToolCall) (bool, error) {
+	return toolCall.Type == "function", nil
+}
+
+func TestRegisterProvider_External(t *testing.T) {
+	var apcTools core.APCTools
+	upper := func(text string) (string, error) { return strings.ToUpper(text), nil }
+	if err := apcTools.RegisterTool("upper", upper, core.WithDescription("Upper cases text."), core.WithParam("text", "text to upper case")); err != nil {
+		t.Fatal(err)
+	}
+	apc.RegisterProvider("test-upper", func(config core.ProviderConfig) (core.IProvider, error) {
+		return &upperProvider{tools: config.APCTools.Tools}, nil
+	})
+
+	client, err := apc.New("test-upper", core.ProviderConfig{Model: "model", APCTools: apcTools})
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := client.Complete(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "HELLO" {
+		t.Errorf("expected HELLO, got %q", answer)
+	}
+}
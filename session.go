@@ -0,0 +1,615 @@
+package apc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/internal/logger"
+)
+
+// Session is a conversation with its own message history. Send can be called
+// repeatedly to continue the conversation. Sessions created from the same APC
+// share its configuration but never each other's history.
+//
+// A Session serializes its own calls; use separate sessions for concurrent
+// conversations.
+type Session struct {
+	// public
+	Id       string
+	Provider core.IProvider
+	// private
+	providerName   string
+	providerConfig core.ProviderConfig
+	historyStore   core.HistoryStore
+	mu             sync.Mutex
+	// totals of the last call, only accessed by ProcessResponse while it runs
+	usage      core.Usage
+	toolRounds int
+	// serializes the tool approvers of concurrent tool calls
+	approvalMu sync.Mutex
+}
+
+func newSession(id string, providerName string, providerConfig core.ProviderConfig, historyStore core.HistoryStore) (*Session, error) {
+	provider, err := newProvider(providerName, providerConfig)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		id = newSessionId()
+	}
+	return &Session{
+		Id:             id,
+		Provider:       provider,
+		providerName:   providerName,
+		providerConfig: providerConfig,
+		historyStore:   historyStore,
+	}, nil
+}
+
+// newSessionId returns a sortable, unique session id like
+// 20250102-150405-9f86d081.
+func newSessionId() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// Save writes the session history to the history store of the APC. Sessions
+// are saved automatically after each Send, so this is only needed to persist
+// changes made otherwise, e.g. through SwitchProvider.
+func (s *Session) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.save()
+}
+
+func (s *Session) save() error {
+	if s.historyStore == nil {
+		return fmt.Errorf("[Session.Save] No history store configured, see apc.WithHistoryStore")
+	}
+	history, err := s.Provider.ExportHistory()
+	if err != nil {
+		return err
+	}
+	return s.historyStore.Save(s.Id, history)
+}
+
+// autoSave saves the session if a history store is configured. Failures are
+// logged rather than returned so that they don't discard an answer.
+func (s *Session) autoSave() {
+	if s.historyStore == nil {
+		return
+	}
+	if err := s.save(); err != nil {
+		logger.Error("[Session] Failed to save session `%s`: %v", s.Id, err)
+	}
+}
+
+// History returns the session history as provider-neutral messages.
+func (s *Session) History() ([]core.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Provider.ExportHistory()
+}
+
+// SwitchProvider continues the conversation on another provider. The history
+// is converted to the new provider's wire format; the system prompt, model
+// and tools are taken from providerConfig.
+func (s *Session) SwitchProvider(providerName string, providerConfig core.ProviderConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.Provider.ExportHistory()
+	if err != nil {
+		return err
+	}
+	provider, err := newProvider(providerName, providerConfig)
+	if err != nil {
+		return err
+	}
+	if err := provider.ImportHistory(history); err != nil {
+		return err
+	}
+	s.Provider = provider
+	s.providerName = providerName
+	s.providerConfig = providerConfig
+	return nil
+}
+
+// Send sends the user prompt within the session, runs tool calls requested by
+// the model and returns the final answer. The prompt, tool calls and answer
+// are kept in the session history for the next Send.
+func (s *Session) Send(ctx context.Context, userPrompt string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.autoSave()
+
+	answer, err := s.run(ctx, userPrompt, nil)
+	return answer, s.finalizeError(err)
+}
+
+// Stream works like Send but streams the response. The returned channel
+// receives text and tool call deltas as they arrive, tool calls and results
+// as the agentic loop runs, and is closed after a final StreamEventDone or
+// StreamEventError event. The caller must drain the channel or cancel ctx.
+func (s *Session) Stream(ctx context.Context, userPrompt string) <-chan core.StreamEvent {
+	eventChan := make(chan core.StreamEvent, streamBufferSize)
+	go func() {
+		defer close(eventChan)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		answer, err := s.run(ctx, userPrompt, eventChan)
+		err = s.finalizeError(err)
+		s.autoSave()
+		if err != nil {
+			send(ctx, eventChan, core.StreamEvent{Type: core.StreamEventError, Err: err})
+			return
+		}
+		send(ctx, eventChan, core.StreamEvent{Type: core.StreamEventDone, Text: answer})
+	}()
+	return eventChan
+}
+
+// Usage returns the token usage summed over all requests of the last Send or
+// Stream call.
+func (s *Session) Usage() core.Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.usage
+}
+
+// Reset clears the session history. The system prompt is kept.
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Provider.ResetMessageHistory()
+	s.autoSave()
+}
+
+// Fork returns a new session with a new id that starts with a copy of this
+// session's history. Both sessions evolve independently afterwards.
+func (s *Session) Fork() (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fork, err := newSession("", s.providerName, s.providerConfig, s.historyStore)
+	if err != nil {
+		return nil, err
+	}
+	if err := fork.Provider.SetMessageHistory(s.Provider.GetMessageHistory()); err != nil {
+		return nil, err
+	}
+	return fork, nil
+}
+
+func (s *Session) ProcessUserPrompt(ctx context.Context, userPromptChan <-chan string, msgHistoryChan chan<- []core.GenericMessage) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case prompt := <-userPromptChan:
+			logger.Info("[ProcessUserPrompt] ✅ Got user prompt")
+			send(ctx, msgHistoryChan, []core.GenericMessage{s.Provider.ConstructUserPromptMessage(prompt)})
+		}
+	}
+}
+
+func (s *Session) ProcessMessage(ctx context.Context, msgHistoryChan <-chan []core.GenericMessage, reqChan chan<- core.GenericRequest, errChan chan<- error) {
+	for {
+		var messages []core.GenericMessage
+		select {
+		case <-ctx.Done():
+			return
+		case messages = <-msgHistoryChan:
+		}
+
+		var err error
+		for _, msg := range messages {
+			err = s.Provider.AppendMessageHistory(msg)
+			if err != nil {
+				break
+			}
+
+		}
+		if err != nil {
+			send(ctx, errChan, err)
+			continue
+		}
+		isSenderRole := false
+		for _, msg := range messages {
+			isSenderRole, err = s.Provider.IsSenderRole(msg)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			send(ctx, errChan, err)
+			continue
+		}
+		if isSenderRole {
+			req, err := s.Provider.NewRequest()
+			if err != nil {
+				send(ctx, errChan, err)
+				continue
+			}
+			send(ctx, reqChan, req)
+		}
+	}
+}
+
+func (s *Session) ProcessRequest(ctx context.Context, reqChan <-chan core.GenericRequest, respChan chan<- core.GenericResponse, errChan chan<- error, eventChan chan<- core.StreamEvent) {
+	for {
+		var req core.GenericRequest
+		select {
+		case <-ctx.Done():
+			return
+		case req = <-reqChan:
+		}
+
+		logger.Info("[ProcessRequest] ⏳ Awaiting response...")
+		var resp core.GenericResponse
+		var err error
+		if eventChan != nil {
+			resp, err = s.Provider.SendStreamRequest(ctx, req, func(event core.StreamEvent) {
+				emit(ctx, eventChan, event)
+			})
+		} else {
+			resp, err = s.Provider.SendRequest(ctx, req)
+		}
+		if err != nil {
+			send(ctx, errChan, err)
+			continue
+		}
+		send(ctx, respChan, resp)
+	}
+}
+
+func (s *Session) ProcessToolCall(ctx context.Context, toolCallChan <-chan []core.ProviderToolCall, msgHistoryChan chan<- []core.GenericMessage, errChan chan<- error, eventChan chan<- core.StreamEvent) {
+	for {
+		var toolCalls []core.ProviderToolCall
+		select {
+		case <-ctx.Done():
+			return
+		case toolCalls = <-toolCallChan:
+		}
+
+		// results are collected by index, the tool messages are constructed in
+		// the order of the tool calls
+		results := make([]*core.ToolResult, len(toolCalls))
+		apcTools := &s.providerConfig.APCTools
+		sem := make(chan struct{}, apcTools.Concurrency())
+		var wg sync.WaitGroup
+		for i, toolCall := range toolCalls {
+			if apcTools.IsSequential(toolCall.Function.Name) {
+				wg.Wait()
+				results[i] = s.execToolCall(ctx, toolCall, i+1, len(toolCalls), errChan, eventChan)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+			case sem <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() { <-sem }()
+					results[i] = s.execToolCall(ctx, toolCall, i+1, len(toolCalls), errChan, eventChan)
+				}()
+			}
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		toolMessages := make([]core.GenericMessage, 0, len(results))
+		for i, toolResult := range results {
+			if toolResult != nil {
+				toolMessages = append(toolMessages, s.Provider.ConstructToolMessage(toolCalls[i], *toolResult))
+			}
+		}
+		if len(toolMessages) > 0 {
+			send(ctx, msgHistoryChan, toolMessages)
+		} else {
+			logger.Warning("\t\t\t[ProcessToolCall] ⚠️ No tool message constructed")
+			// TODO: compare tool name(s) with registered tools, validate it. if tool name(s) are wrong, retry it
+		}
+	}
+}
+
+// execToolCall runs a single tool call and returns the result for the model,
+// or nil if the call is invalid or failed fatally. It's safe to call
+// concurrently.
+func (s *Session) execToolCall(ctx context.Context, toolCall core.ProviderToolCall, n int, total int, errChan chan<- error, eventChan chan<- core.StreamEvent) *core.ToolResult {
+	logger.Info("[ProcessToolCall] ⚡ Call tool `%s` [%d/%d]", toolCall.Function.Name, n, total)
+	isToolCallValid, err := s.Provider.IsToolCallValid(toolCall)
+	if err != nil {
+		send(ctx, errChan, err)
+		return nil
+	}
+	if !isToolCallValid {
+		return nil
+	}
+	emit(ctx, eventChan, core.StreamEvent{Type: core.StreamEventToolCall, ToolCall: toolCall})
+	var argsStr string
+	var argsMap = make(map[string]any)
+	if toolCall.Function.Arguments != nil && string(toolCall.Function.Arguments) != "{}" {
+		var err error
+		if toolCall.Function.Arguments[0] == '"' { // string
+			err = json.Unmarshal([]byte(toolCall.Function.Arguments), &argsStr)
+			if err != nil {
+				send(ctx, errChan, newToolError(toolCall, fmt.Errorf("failed to decode arguments `%s`: %w", string(toolCall.Function.Arguments), err)))
+				return nil
+			}
+			err = json.Unmarshal([]byte(argsStr), &argsMap)
+			if err != nil {
+				send(ctx, errChan, newToolError(toolCall, fmt.Errorf("failed to decode arguments `%s`: %w", string(toolCall.Function.Arguments), err)))
+				return nil
+			}
+		} else { // object ready
+			err = json.Unmarshal([]byte(toolCall.Function.Arguments), &argsMap)
+			if err != nil {
+				send(ctx, errChan, newToolError(toolCall, fmt.Errorf("failed to decode arguments `%s`: %w", string(toolCall.Function.Arguments), err)))
+				return nil
+			}
+		}
+	}
+
+	if approver := s.providerConfig.APCTools.ApproverFor(toolCall.Function.Name); approver != nil {
+		s.approvalMu.Lock()
+		approval, err := approver(ctx, toolCall.Function.Name, argsMap)
+		s.approvalMu.Unlock()
+		if err != nil {
+			send(ctx, errChan, newToolError(toolCall, fmt.Errorf("approval failed: %w", err)))
+			return nil
+		}
+		if !approval.Approved {
+			reason := approval.Reason
+			if reason == "" {
+				reason = "The user denied the tool call."
+			}
+			logger.Warning("[ProcessToolCall] Tool call `%s` denied: %s", toolCall.Function.Name, reason)
+			emit(ctx, eventChan, core.StreamEvent{Type: core.StreamEventToolResult, ToolCall: toolCall, Text: reason, Err: newToolError(toolCall, fmt.Errorf("%w: %s", core.ErrToolCallDenied, reason))})
+			return &core.ToolResult{ToolCallId: toolCall.Id, Name: toolCall.Function.Name, Content: reason, IsError: true}
+		}
+		if approval.Arguments != nil {
+			argsMap = approval.Arguments
+		}
+	}
+
+	var result core.ToolResult
+	var toolError error
+	toolCtx := ctx
+	if timeout := s.providerConfig.APCTools.Timeout(toolCall.Function.Name); timeout > 0 {
+		var cancel context.CancelFunc
+		toolCtx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("timed out after %s: %w", timeout, context.DeadlineExceeded))
+		defer cancel()
+	}
+	toolResult, toolErr := s.providerConfig.APCTools.ExecTool(toolCtx, toolCall.Function.Name, argsMap)
+	if toolErr != nil && ctx.Err() == nil && toolCtx.Err() != nil {
+		// report the timeout rather than how the tool noticed it
+		toolErr = context.Cause(toolCtx)
+	}
+	if toolErr != nil {
+		// reported back to the model, not fatal
+		toolError = newToolError(toolCall, toolErr)
+		result = core.ToolResult{Content: toolErr.Error(), IsError: true}
+		logger.Warning("[ProcessToolCall] Tool `%s` returned err: %s", toolCall.Function.Name, result.Content)
+	} else {
+		var err error
+		result, err = toToolResult(toolResult)
+		if err != nil {
+			send(ctx, errChan, newToolError(toolCall, err))
+			return nil
+		}
+		if result.IsError {
+			toolError = newToolError(toolCall, errors.New(result.Text()))
+			logger.Warning("[ProcessToolCall] Tool `%s` reported an error: %s", toolCall.Function.Name, result.Text())
+		} else {
+			logger.Info("[ProcessToolCall] ✅ Tool call successful `%s` [%d/%d]", toolCall.Function.Name, n, total)
+		}
+	}
+	result.ToolCallId = toolCall.Id
+	result.Name = toolCall.Function.Name
+	emit(ctx, eventChan, core.StreamEvent{Type: core.StreamEventToolResult, ToolCall: toolCall, Text: result.Text(), Err: toolError})
+	return &result
+}
+
+// toToolResult converts the value returned by a tool: strings are sent as is,
+// a core.ToolResult or []core.Part as given and anything else as JSON.
+func toToolResult(value any) (core.ToolResult, error) {
+	switch v := value.(type) {
+	case string:
+		return core.ToolResult{Content: v}, nil
+	case core.ToolResult:
+		return v, nil
+	case *core.ToolResult:
+		if v != nil {
+			return *v, nil
+		}
+	case []core.Part:
+		for _, part := range v {
+			if part.Type != core.PartTypeText && part.Type != core.PartTypeImage {
+				return core.ToolResult{}, fmt.Errorf("unsupported `%s` part in result, expected text or image", part.Type)
+			}
+		}
+		return core.ToolResult{Parts: v}, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return core.ToolResult{}, fmt.Errorf("failed to encode result of type %T: %w", value, err)
+	}
+	return core.ToolResult{Content: string(b)}, nil
+}
+
+func newToolError(toolCall core.ProviderToolCall, err error) error {
+	return &core.ToolError{Tool: toolCall.Function.Name, ToolCallId: toolCall.Id, Err: err}
+}
+
+func (s *Session) ProcessResponse(ctx context.Context, respChan <-chan core.GenericResponse, msgHistoryChan chan<- []core.GenericMessage, toolCallChan chan<- []core.ProviderToolCall, errChan chan<- error, outChan chan<- string, eventChan chan<- core.StreamEvent) {
+	for {
+		var resp core.GenericResponse
+		select {
+		case <-ctx.Done():
+			return
+		case resp = <-respChan:
+		}
+
+		logger.Info("[ProcessResponse] 📦 Got response")
+		msg, err := s.Provider.GetMessageFromResponse(resp)
+		if err != nil {
+			send(ctx, errChan, err)
+			continue
+		}
+		finishReason, err := s.Provider.GetFinishReasonFromResponse(resp)
+		if err != nil {
+			send(ctx, errChan, err)
+			continue
+		}
+		usage, err := s.Provider.GetUsageFromResponse(resp)
+		if err != nil {
+			send(ctx, errChan, err)
+			continue
+		}
+		s.usage = s.usage.Add(usage)
+		emit(ctx, eventChan, core.StreamEvent{Type: core.StreamEventFinish, FinishReason: finishReason})
+
+		limits := s.providerConfig.Limits
+		if limits.MaxOutputTokens > 0 && finishReason == s.Provider.FinishReasonMaxTokens() {
+			s.abortWithLimit(ctx, msg, resp, &core.LimitExceededError{Limit: core.LimitOutputTokens, Max: int64(limits.MaxOutputTokens)}, errChan)
+			continue
+		}
+		isToolCall, err := s.Provider.IsToolCall(resp)
+		if err != nil {
+			send(ctx, errChan, err)
+			continue
+		}
+		if isToolCall && limits.MaxToolRounds > 0 && s.toolRounds >= limits.MaxToolRounds {
+			s.abortWithLimit(ctx, msg, resp, &core.LimitExceededError{Limit: core.LimitToolRounds, Max: int64(limits.MaxToolRounds)}, errChan)
+			continue
+		}
+		if limits.MaxTotalTokens > 0 && s.usage.TotalTokens() > limits.MaxTotalTokens {
+			s.abortWithLimit(ctx, msg, resp, &core.LimitExceededError{Limit: core.LimitTotalTokens, Max: int64(limits.MaxTotalTokens)}, errChan)
+			continue
+		}
+		send(ctx, msgHistoryChan, []core.GenericMessage{msg})
+
+		if !isToolCall {
+			answer, err := s.Provider.GetAnswerFromResponse(resp)
+			if err != nil {
+				send(ctx, errChan, err)
+				continue
+			}
+			send(ctx, outChan, answer)
+		} else {
+			toolCalls, err := s.Provider.GetToolCallsFromResponse(resp)
+			if err != nil {
+				send(ctx, errChan, err)
+				continue
+			}
+			s.toolRounds += 1
+			send(ctx, toolCallChan, toolCalls)
+		}
+		logger.Info("[ProcessResponse] ✅ Response processed successfully")
+	}
+}
+
+// abortWithLimit reports a tripped limit. The response message is kept in the
+// history unless it requests tool calls, since a history must not end with
+// tool calls lacking results. The pipeline is idle while ProcessResponse holds
+// the response, so the message is appended directly.
+func (s *Session) abortWithLimit(ctx context.Context, msg core.GenericMessage, resp core.GenericResponse, limitErr *core.LimitExceededError, errChan chan<- error) {
+	logger.Warning("[ProcessResponse] ⛔ %v", limitErr)
+	toolCalls, err := s.Provider.GetToolCallsFromResponse(resp)
+	if err == nil && len(toolCalls) == 0 {
+		if err := s.Provider.AppendMessageHistory(msg); err != nil {
+			logger.Warning("[ProcessResponse] Failed to keep response in history: %v", err)
+		}
+	}
+	send(ctx, errChan, error(limitErr))
+}
+
+// finalizeError completes a LimitExceededError with the totals of the call
+// and the resulting history. It must be called after run returned.
+func (s *Session) finalizeError(err error) error {
+	var limitErr *core.LimitExceededError
+	if !errors.As(err, &limitErr) {
+		return err
+	}
+	limitErr.Usage = s.usage
+	limitErr.ToolRounds = s.toolRounds
+	history, historyErr := s.Provider.ExportHistory()
+	if historyErr != nil {
+		logger.Warning("[Session] Failed to export history: %v", historyErr)
+	}
+	limitErr.History = history
+	return err
+}
+
+// run wires up the processing pipeline for a single user prompt and blocks
+// until the final answer is produced, an error occurs or ctx is done. When
+// eventChan is not nil, requests are streamed and events are forwarded to it.
+func (s *Session) run(ctx context.Context, userPrompt string, eventChan chan<- core.StreamEvent) (string, error) {
+	s.usage = core.Usage{}
+	s.toolRounds = 0
+	if timeout := s.providerConfig.Limits.Timeout; timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, &core.LimitExceededError{Limit: core.LimitTimeout, Max: int64(timeout)})
+		defer cancelTimeout()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	userPromptChan := make(chan string, 1)
+	toolCallChan := make(chan []core.ProviderToolCall, 1)
+	msgHistoryChan := make(chan []core.GenericMessage, 1)
+	reqChan := make(chan core.GenericRequest, 1)
+	respChan := make(chan core.GenericResponse, 1)
+	outChan := make(chan string, 1)
+	errChan := make(chan error, 1)
+
+	stages := []func(){
+		func() { s.ProcessUserPrompt(ctx, userPromptChan, msgHistoryChan) },
+		func() { s.ProcessMessage(ctx, msgHistoryChan, reqChan, errChan) },
+		func() { s.ProcessRequest(ctx, reqChan, respChan, errChan, eventChan) },
+		func() { s.ProcessToolCall(ctx, toolCallChan, msgHistoryChan, errChan, eventChan) },
+		func() { s.ProcessResponse(ctx, respChan, msgHistoryChan, toolCallChan, errChan, outChan, eventChan) },
+	}
+	wg.Add(len(stages))
+	for _, stage := range stages {
+		go func() {
+			defer wg.Done()
+			stage()
+		}()
+	}
+
+	userPromptChan <- userPrompt
+	select {
+	case answer := <-outChan:
+		return answer, nil
+	case err := <-errChan:
+		logger.PrintV(s.Provider.GetMessageHistory())
+		if cause := context.Cause(ctx); cause != nil { // e.g. the request failed due to the timeout
+			err = cause
+		}
+		return "", err
+	case <-ctx.Done():
+		return "", context.Cause(ctx)
+	}
+}
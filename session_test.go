@@ -0,0 +1,132 @@
+package apc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/assagman/apc/core"
+	"github.com/assagman/apc/mock"
+)
+
+func userMessage(text string) core.Message {
+	return core.Message{Role: core.RoleUser, Parts: []core.Part{core.TextPart(text)}}
+}
+
+func assistantMessage(text string) core.Message {
+	return core.Message{Role: core.RoleAssistant, Parts: []core.Part{core.TextPart(text)}}
+}
+
+func sessionHistory(t *testing.T, session *Session) []core.Message {
+	t.Helper()
+	history, err := session.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return history
+}
+
+func TestSession_Isolated(t *testing.T) {
+	script := mock.NewScript(mock.Text("hello a"), mock.Text("hello b"))
+	client := newMockClient(t, script, core.ProviderConfig{Model: "test"})
+	a, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Id == b.Id {
+		t.Errorf("expected distinct session ids, got %s twice", a.Id)
+	}
+
+	if _, err := a.Send(context.Background(), "hi a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Send(context.Background(), "hi b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// each request only carries the history of its own session
+	requests := script.Requests()
+	if expected := []core.Message{userMessage("hi b")}; !reflect.DeepEqual(expected, requests[1].Messages) {
+		t.Errorf("unexpected messages of session b: %+v", requests[1].Messages)
+	}
+	if expected := []core.Message{userMessage("hi a"), assistantMessage("hello a")}; !reflect.DeepEqual(expected, sessionHistory(t, a)) {
+		t.Errorf("unexpected history of session a: %+v", sessionHistory(t, a))
+	}
+	if expected := []core.Message{userMessage("hi b"), assistantMessage("hello b")}; !reflect.DeepEqual(expected, sessionHistory(t, b)) {
+		t.Errorf("unexpected history of session b: %+v", sessionHistory(t, b))
+	}
+}
+
+func TestSession_Reset(t *testing.T) {
+	script := mock.NewScript(mock.Text("hello"), mock.Text("hello again"))
+	client := newMockClient(t, script, core.ProviderConfig{Model: "test", SystemPrompt: "be brief"})
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.Send(context.Background(), "hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	session.Reset()
+	if history := sessionHistory(t, session); len(history) != 0 {
+		t.Errorf("expected an empty history after Reset, got %+v", history)
+	}
+	if _, err := session.Send(context.Background(), "hi again"); err != nil {
+		t.Fatal(err)
+	}
+	request := script.Requests()[1]
+	if request.SystemPrompt != "be brief" {
+		t.Errorf("expected the system prompt to be kept, got %q", request.SystemPrompt)
+	}
+	if expected := []core.Message{userMessage("hi again")}; !reflect.DeepEqual(expected, request.Messages) {
+		t.Errorf("expected the turns before Reset to be dropped, got %+v", request.Messages)
+	}
+}
+
+func TestSession_Fork(t *testing.T) {
+	script := mock.NewScript(mock.Text("hello"), mock.Text("fork answer"), mock.Text("parent answer"))
+	client := newMockClient(t, script, core.ProviderConfig{Model: "test"})
+	parent, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parent.Send(context.Background(), "hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	fork, err := parent.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.Id == parent.Id {
+		t.Errorf("expected the fork to get a new id, got %s", fork.Id)
+	}
+	shared := []core.Message{userMessage("hi"), assistantMessage("hello")}
+	if !reflect.DeepEqual(shared, sessionHistory(t, fork)) {
+		t.Errorf("expected the fork to start with the parent history, got %+v", sessionHistory(t, fork))
+	}
+
+	if _, err := fork.Send(context.Background(), "fork question"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(shared, sessionHistory(t, parent)) {
+		t.Errorf("expected the fork to leave the parent unchanged, got %+v", sessionHistory(t, parent))
+	}
+	if _, err := parent.Send(context.Background(), "parent question"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append(shared[:len(shared):len(shared)], userMessage("fork question"), assistantMessage("fork answer"))
+	if !reflect.DeepEqual(expected, sessionHistory(t, fork)) {
+		t.Errorf("unexpected history of the fork: %+v", sessionHistory(t, fork))
+	}
+	expected = append(shared[:len(shared):len(shared)], userMessage("parent question"), assistantMessage("parent answer"))
+	if !reflect.DeepEqual(expected, sessionHistory(t, parent)) {
+		t.Errorf("unexpected history of the parent: %+v", sessionHistory(t, parent))
+	}
+}